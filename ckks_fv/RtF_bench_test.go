@@ -6,9 +6,7 @@ import (
 	"math"
 	"testing"
 
-	"github.com/ldsec/lattigo/v2/ring"
 	"github.com/ldsec/lattigo/v2/utils"
-	"golang.org/x/crypto/sha3"
 )
 
 // Benchmark RtF framework with HERA for 80-bit security full-slots parameter
@@ -187,10 +185,7 @@ func benchmarkRtFHera(b *testing.B, name string, numRound int, paramIndex int, r
 			rand.Read(nonces[i])
 		}
 
-		keystream = make([][]uint64, params.N())
-		for i := 0; i < params.N(); i++ {
-			keystream[i] = plainHera(numRound, nonces[i], key, params.PlainModulus())
-		}
+		keystream = NewHera(numRound, key, params.PlainModulus()).KeyStream(nonces)
 
 		for s := 0; s < 16; s++ {
 			for i := 0; i < params.N()/2; i++ {
@@ -224,10 +219,7 @@ func benchmarkRtFHera(b *testing.B, name string, numRound int, paramIndex int, r
 			rand.Read(nonces[i])
 		}
 
-		keystream = make([][]uint64, params.Slots())
-		for i := 0; i < params.Slots(); i++ {
-			keystream[i] = plainHera(numRound, nonces[i], key, params.PlainModulus())
-		}
+		keystream = NewHera(numRound, key, params.PlainModulus()).KeyStream(nonces)
 
 		for s := 0; s < 16; s++ {
 			for i := 0; i < params.Slots()/2; i++ {
@@ -334,9 +326,7 @@ func benchmarkRtFRubato(b *testing.B, rubatoParam int) {
 	// Rubato parameter
 	blocksize := RubatoParams[rubatoParam].Blocksize
 	outputsize := blocksize - 4
-	numRound := RubatoParams[rubatoParam].NumRound
 	plainModulus := RubatoParams[rubatoParam].PlainModulus
-	sigma := RubatoParams[rubatoParam].Sigma
 
 	// RtF Rubato parameters
 	hbtpParams := RtFRubatoParams[0]
@@ -403,10 +393,7 @@ func benchmarkRtFRubato(b *testing.B, rubatoParam int) {
 	rand.Read(counter)
 
 	// Get keystream
-	keystream = make([][]uint64, params.N())
-	for i := 0; i < params.N(); i++ {
-		keystream[i] = plainRubato(blocksize, numRound, nonces[i], counter, key, plainModulus, sigma)
-	}
+	keystream = NewRubato(rubatoParam, key).KeyStream(nonces, counter)
 
 	for s := 0; s < outputsize; s++ {
 		for i := 0; i < params.N()/2; i++ {
@@ -496,274 +483,3 @@ func printDebug(params *Parameters, ciphertext *Ciphertext, valuesWant []complex
 
 	fmt.Println(precStats.String())
 }
-
-func plainHera(roundNum int, nonce []byte, key []uint64, plainModulus uint64) (state []uint64) {
-	nr := roundNum
-	xof := sha3.NewShake256()
-	xof.Write(nonce)
-	state = make([]uint64, 16)
-
-	rks := make([][]uint64, nr+1)
-
-	for r := 0; r <= nr; r++ {
-		rks[r] = make([]uint64, 16)
-		for st := 0; st < 16; st++ {
-			rks[r][st] = SampleZqx(xof, plainModulus) * key[st] % plainModulus
-		}
-	}
-
-	for i := 0; i < 16; i++ {
-		state[i] = uint64(i + 1)
-	}
-
-	// round0
-	for st := 0; st < 16; st++ {
-		state[st] = (state[st] + rks[0][st]) % plainModulus
-	}
-
-	for r := 1; r < roundNum; r++ {
-		for col := 0; col < 4; col++ {
-			y0 := 2*state[col] + 3*state[col+4] + 1*state[col+8] + 1*state[col+12]
-			y1 := 2*state[col+4] + 3*state[col+8] + 1*state[col+12] + 1*state[col]
-			y2 := 2*state[col+8] + 3*state[col+12] + 1*state[col] + 1*state[col+4]
-			y3 := 2*state[col+12] + 3*state[col] + 1*state[col+4] + 1*state[col+8]
-
-			state[col] = y0 % plainModulus
-			state[col+4] = y1 % plainModulus
-			state[col+8] = y2 % plainModulus
-			state[col+12] = y3 % plainModulus
-		}
-
-		for row := 0; row < 4; row++ {
-			y0 := 2*state[4*row] + 3*state[4*row+1] + 1*state[4*row+2] + 1*state[4*row+3]
-			y1 := 2*state[4*row+1] + 3*state[4*row+2] + 1*state[4*row+3] + 1*state[4*row]
-			y2 := 2*state[4*row+2] + 3*state[4*row+3] + 1*state[4*row] + 1*state[4*row+1]
-			y3 := 2*state[4*row+3] + 3*state[4*row] + 1*state[4*row+1] + 1*state[4*row+2]
-
-			state[4*row] = y0 % plainModulus
-			state[4*row+1] = y1 % plainModulus
-			state[4*row+2] = y2 % plainModulus
-			state[4*row+3] = y3 % plainModulus
-		}
-
-		for st := 0; st < 16; st++ {
-			state[st] = (state[st] * state[st] % plainModulus) * state[st] % plainModulus
-		}
-
-		for st := 0; st < 16; st++ {
-			state[st] = (state[st] + rks[r][st]) % plainModulus
-		}
-	}
-	for col := 0; col < 4; col++ {
-		y0 := 2*state[col] + 3*state[col+4] + 1*state[col+8] + 1*state[col+12]
-		y1 := 2*state[col+4] + 3*state[col+8] + 1*state[col+12] + 1*state[col]
-		y2 := 2*state[col+8] + 3*state[col+12] + 1*state[col] + 1*state[col+4]
-		y3 := 2*state[col+12] + 3*state[col] + 1*state[col+4] + 1*state[col+8]
-
-		state[col] = y0 % plainModulus
-		state[col+4] = y1 % plainModulus
-		state[col+8] = y2 % plainModulus
-		state[col+12] = y3 % plainModulus
-	}
-
-	for row := 0; row < 4; row++ {
-		y0 := 2*state[4*row] + 3*state[4*row+1] + 1*state[4*row+2] + 1*state[4*row+3]
-		y1 := 2*state[4*row+1] + 3*state[4*row+2] + 1*state[4*row+3] + 1*state[4*row]
-		y2 := 2*state[4*row+2] + 3*state[4*row+3] + 1*state[4*row] + 1*state[4*row+1]
-		y3 := 2*state[4*row+3] + 3*state[4*row] + 1*state[4*row+1] + 1*state[4*row+2]
-
-		state[4*row] = y0 % plainModulus
-		state[4*row+1] = y1 % plainModulus
-		state[4*row+2] = y2 % plainModulus
-		state[4*row+3] = y3 % plainModulus
-	}
-
-	for st := 0; st < 16; st++ {
-		state[st] = (state[st] * state[st] % plainModulus) * state[st] % plainModulus
-	}
-
-	for col := 0; col < 4; col++ {
-		y0 := 2*state[col] + 3*state[col+4] + 1*state[col+8] + 1*state[col+12]
-		y1 := 2*state[col+4] + 3*state[col+8] + 1*state[col+12] + 1*state[col]
-		y2 := 2*state[col+8] + 3*state[col+12] + 1*state[col] + 1*state[col+4]
-		y3 := 2*state[col+12] + 3*state[col] + 1*state[col+4] + 1*state[col+8]
-
-		state[col] = y0 % plainModulus
-		state[col+4] = y1 % plainModulus
-		state[col+8] = y2 % plainModulus
-		state[col+12] = y3 % plainModulus
-	}
-
-	for row := 0; row < 4; row++ {
-		y0 := 2*state[4*row] + 3*state[4*row+1] + 1*state[4*row+2] + 1*state[4*row+3]
-		y1 := 2*state[4*row+1] + 3*state[4*row+2] + 1*state[4*row+3] + 1*state[4*row]
-		y2 := 2*state[4*row+2] + 3*state[4*row+3] + 1*state[4*row] + 1*state[4*row+1]
-		y3 := 2*state[4*row+3] + 3*state[4*row] + 1*state[4*row+1] + 1*state[4*row+2]
-
-		state[4*row] = y0 % plainModulus
-		state[4*row+1] = y1 % plainModulus
-		state[4*row+2] = y2 % plainModulus
-		state[4*row+3] = y3 % plainModulus
-	}
-
-	for st := 0; st < 16; st++ {
-		state[st] = (state[st] + rks[roundNum][st]) % plainModulus
-	}
-	return
-}
-
-func plainRubato(blocksize int, numRound int, nonce []byte, counter []byte, key []uint64, plainModulus uint64, sigma float64) (state []uint64) {
-	xof := sha3.NewShake256()
-	xof.Write(nonce)
-	xof.Write(counter)
-	state = make([]uint64, blocksize)
-
-	prng, err := utils.NewPRNG()
-	if err != nil {
-		panic(err)
-	}
-	gaussianSampler := ring.NewGaussianSampler(prng)
-
-	rks := make([][]uint64, numRound+1)
-
-	for r := 0; r <= numRound; r++ {
-		rks[r] = make([]uint64, blocksize)
-		for i := 0; i < blocksize; i++ {
-			rks[r][i] = SampleZqx(xof, plainModulus) * key[i] % plainModulus
-		}
-	}
-
-	for i := 0; i < blocksize; i++ {
-		state[i] = uint64(i + 1)
-	}
-
-	// Initial AddRoundKey
-	for i := 0; i < blocksize; i++ {
-		state[i] = (state[i] + rks[0][i]) % plainModulus
-	}
-
-	// Round Functions
-	for r := 1; r < numRound; r++ {
-		rubatoLinearLayer(state, plainModulus)
-		rubatoFeistel(state, plainModulus)
-		for i := 0; i < blocksize; i++ {
-			state[i] = (state[i] + rks[r][i]) % plainModulus
-		}
-	}
-
-	// Finalization
-	rubatoLinearLayer(state, plainModulus)
-	rubatoFeistel(state, plainModulus)
-	rubatoLinearLayer(state, plainModulus)
-	if sigma > 0 {
-		rubatoAddGaussianNoise(state, plainModulus, gaussianSampler, sigma)
-	}
-	for i := 0; i < blocksize; i++ {
-		state[i] = (state[i] + rks[numRound][i]) % plainModulus
-	}
-	state = state[0 : blocksize-4]
-
-	return
-}
-
-func rubatoLinearLayer(state []uint64, plainModulus uint64) {
-	blocksize := len(state)
-	buf := make([]uint64, blocksize)
-
-	if blocksize == 16 {
-		// MixColumns
-		for row := 0; row < 4; row++ {
-			for col := 0; col < 4; col++ {
-				buf[row*4+col] = 2 * state[row*4+col]
-				buf[row*4+col] += 3 * state[((row+1)%4)*4+col]
-				buf[row*4+col] += state[((row+2)%4)*4+col]
-				buf[row*4+col] += state[((row+3)%4)*4+col]
-				buf[row*4+col] %= plainModulus
-			}
-		}
-		// MixRows
-		for row := 0; row < 4; row++ {
-			for col := 0; col < 4; col++ {
-				state[row*4+col] = 2 * buf[row*4+col]
-				state[row*4+col] += 3 * buf[row*4+(col+1)%4]
-				state[row*4+col] += buf[row*4+(col+2)%4]
-				state[row*4+col] += buf[row*4+(col+3)%4]
-				state[row*4+col] %= plainModulus
-			}
-		}
-	} else if blocksize == 36 {
-		// MixColumns
-		for row := 0; row < 6; row++ {
-			for col := 0; col < 6; col++ {
-				buf[row*6+col] = 4 * state[row*6+col]
-				buf[row*6+col] += 2 * state[((row+1)%6)*6+col]
-				buf[row*6+col] += 4 * state[((row+2)%6)*6+col]
-				buf[row*6+col] += 3 * state[((row+3)%6)*6+col]
-				buf[row*6+col] += state[((row+4)%6)*6+col]
-				buf[row*6+col] += state[((row+5)%6)*6+col]
-				buf[row*6+col] %= plainModulus
-			}
-		}
-		// MixRows
-		for row := 0; row < 6; row++ {
-			for col := 0; col < 6; col++ {
-				state[row*6+col] = 4 * buf[row*6+col]
-				state[row*6+col] += 2 * buf[row*6+(col+1)%6]
-				state[row*6+col] += 4 * buf[row*6+(col+2)%6]
-				state[row*6+col] += 3 * buf[row*6+(col+3)%6]
-				state[row*6+col] += buf[row*6+(col+4)%6]
-				state[row*6+col] += buf[row*6+(col+5)%6]
-				state[row*6+col] %= plainModulus
-			}
-		}
-	} else if blocksize == 64 {
-		// MixColumns
-		for row := 0; row < 8; row++ {
-			for col := 0; col < 8; col++ {
-				buf[row*8+col] = 5 * state[row*8+col]
-				buf[row*8+col] += 3 * state[((row+1)%8)*8+col]
-				buf[row*8+col] += 4 * state[((row+2)%8)*8+col]
-				buf[row*8+col] += 3 * state[((row+3)%8)*8+col]
-				buf[row*8+col] += 6 * state[((row+4)%8)*8+col]
-				buf[row*8+col] += 2 * state[((row+5)%8)*8+col]
-				buf[row*8+col] += state[((row+6)%8)*8+col]
-				buf[row*8+col] += state[((row+7)%8)*8+col]
-				buf[row*8+col] %= plainModulus
-			}
-		}
-		// MixRows
-		for row := 0; row < 8; row++ {
-			for col := 0; col < 8; col++ {
-				state[row*8+col] = 5 * buf[row*8+col]
-				state[row*8+col] += 3 * buf[row*8+(col+1)%8]
-				state[row*8+col] += 4 * buf[row*8+(col+2)%8]
-				state[row*8+col] += 3 * buf[row*8+(col+3)%8]
-				state[row*8+col] += 6 * buf[row*8+(col+4)%8]
-				state[row*8+col] += 2 * buf[row*8+(col+5)%8]
-				state[row*8+col] += buf[row*8+(col+6)%8]
-				state[row*8+col] += buf[row*8+(col+7)%8]
-				state[row*8+col] %= plainModulus
-			}
-		}
-	} else {
-		panic("Invalid blocksize")
-	}
-}
-
-func rubatoFeistel(state []uint64, plainModulus uint64) {
-	blocksize := len(state)
-	buf := make([]uint64, blocksize)
-
-	for i := 0; i < blocksize; i++ {
-		buf[i] = state[i]
-	}
-
-	for i := 1; i < blocksize; i++ {
-		state[i] = (buf[i] + buf[i-1]*buf[i-1]) % plainModulus
-	}
-}
-
-func rubatoAddGaussianNoise(state []uint64, plainModulus uint64, gaussianSampler *ring.GaussianSampler, sigma float64) {
-	bound := int(6 * sigma)
-	gaussianSampler.AGN(state, plainModulus, sigma, bound)
-}
@@ -55,7 +55,7 @@ func NewBootstrapper(params *Parameters, btpParams *BootstrappingParameters, btp
 
 	btp = newBootstrapper(params, btpParams)
 
-	btp.BootstrappingKey = &BootstrappingKey{btpKey.Rlk, btpKey.Rtks}
+	btp.BootstrappingKey = &BootstrappingKey{Rlk: btpKey.Rlk, Rtks: btpKey.Rtks}
 	if err = btp.CheckKeys(); err != nil {
 		return nil, fmt.Errorf("invalid bootstrapping key: %w", err)
 	}
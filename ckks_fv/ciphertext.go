@@ -44,6 +44,7 @@ func NewCiphertextCKKS(params *Parameters, degree, level int, scale float64) (ci
 
 	ciphertext.scale = scale
 	ciphertext.isNTT = true
+	ciphertext.isCKKS = true
 
 	return ciphertext
 }
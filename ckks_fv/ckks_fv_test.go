@@ -0,0 +1,84 @@
+package ckks_fv
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+)
+
+var flagLongTest = flag.Bool("long", false, "run the long test suite (RtF parameters at their original ring degree). Requires -timeout=0.")
+
+// testLogN is the ring degree used to scale down the RtF parameters in the default test suite.
+const testLogN = 10
+
+func testString(opname string, p *Parameters) string {
+	return fmt.Sprintf("%sLogN=%d/LogSlots=%d/LogFVSlots=%d/logQP=%d/t=%d", opname, p.LogN(), p.LogSlots(), p.LogFVSlots(), p.LogQP(), p.PlainModulus())
+}
+
+// genTestHalfBootParams returns a copy of hbtpParams whose ring degree is reduced to testLogN,
+// unless the long test suite is run. Full-slots parameters stay full-slots.
+func genTestHalfBootParams(hbtpParams *HalfBootParameters) *HalfBootParameters {
+	hb := hbtpParams.Copy()
+	if *flagLongTest {
+		return hb
+	}
+
+	fullSlots := hb.LogSlots == hb.LogN-1
+	hb.LogN = testLogN
+	if fullSlots || hb.LogSlots > testLogN-1 {
+		hb.LogSlots = testLogN - 1
+	}
+	return hb
+}
+
+// genTestParams returns the Parameters of hbtpParams with FV slots set for full coefficients
+// when hbtpParams is a full-slots parameter, and set to the CKKS slots otherwise.
+func genTestParams(hbtpParams *HalfBootParameters) (params *Parameters, fullCoeffs bool) {
+	var err error
+	if params, err = hbtpParams.Params(); err != nil {
+		panic(err)
+	}
+
+	fullCoeffs = params.LogSlots() == params.LogN()-1
+	if fullCoeffs {
+		params.SetLogFVSlots(params.LogN())
+	} else {
+		params.SetLogFVSlots(params.LogSlots())
+	}
+	return
+}
+
+func newTestNonces(n int) (nonces [][]byte) {
+	nonces = make([][]byte, n)
+	for i := range nonces {
+		nonces[i] = make([]byte, 64)
+		rand.Read(nonces[i])
+	}
+	return
+}
+
+func newTestKey(n int, plainModulus uint64) (key []uint64) {
+	key = make([]uint64, n)
+	for i := range key {
+		key[i] = SampleZqx(rand.Reader, plainModulus)
+	}
+	return
+}
+
+// newKnownAnswerKey returns the deterministic key of the known-answer tests.
+func newKnownAnswerKey(n int, plainModulus uint64) (key []uint64) {
+	key = make([]uint64, n)
+	for i := range key {
+		key[i] = (uint64(i)*0x9E3779B97F4A7C15 + 1) % plainModulus
+	}
+	return
+}
+
+// newKnownAnswerNonce returns the deterministic nonce {seed, seed+1, ..., seed+63} of the known-answer tests.
+func newKnownAnswerNonce(seed byte) (nonce []byte) {
+	nonce = make([]byte, 64)
+	for i := range nonce {
+		nonce[i] = seed + byte(i)
+	}
+	return
+}
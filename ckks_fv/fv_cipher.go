@@ -0,0 +1,428 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MFVCipher is a common interface for the FV evaluation of the stream ciphers of the RtF framework.
+// The keystream is evaluated on one nonce per FV slot, and the counter is ignored by the ciphers which do not use it (HERA).
+// The modulus switching schedules (modDown) have NumRound+1 elements: the number of moduli dropped from the initial
+// states (see Reset), followed by the number of moduli dropped after the nonlinear layer of each round.
+type MFVCipher interface {
+	Crypt(nonce [][]byte, counter []byte, kCt []*Ciphertext, modDown []int) []*Ciphertext
+	CryptNoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext) []*Ciphertext
+	CryptAutoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext, noiseEstimator MFVNoiseEstimator) (res []*Ciphertext, modDown []int)
+	Reset(nbInitModDown int)
+	EncKey(key []uint64) (res []*Ciphertext)
+
+	// LoadKey checks that the symmetric key bundle produced by the client holds a key of KeySize elements
+	// at the level of the initial states, and returns its ciphertexts, which can be given to Crypt.
+	LoadKey(bundle *SymmetricKeyBundle) (kCt []*Ciphertext, err error)
+
+	// NumRound returns the number of rounds of the cipher.
+	NumRound() int
+	// KeySize returns the number of elements of the symmetric key, i.e. the size of the state.
+	KeySize() int
+	// BlockSize returns the number of keystream elements produced for each nonce, i.e. the number of
+	// leading ciphertexts of the output of Crypt which hold the keystream.
+	BlockSize() int
+
+	// SetWorkers sets the number of goroutines over which the independent state words are evaluated, each
+	// with a shallow copy of the evaluator and its own encoder. The output is identical to the serial
+	// evaluation, which is the default (workers = 1).
+	SetWorkers(workers int)
+}
+
+// NewMFVCipher creates the FV evaluation of the given cipher, where cipherParam is the number of rounds for
+// CipherHera, the index of RubatoParams for CipherRubato and the index of PastaParams for CipherPasta.
+func NewMFVCipher(cipher CipherType, cipherParam int, params *Parameters, encoder MFVEncoder, encryptor MFVEncryptor, evaluator MFVEvaluator, nbInitModDown int) MFVCipher {
+	switch cipher {
+	case CipherHera:
+		return newMFVHera(HeraParam{Blocksize: 16, NumRound: cipherParam}, params, encoder, encryptor, evaluator, nbInitModDown).cipher
+	case CipherRubato:
+		return newMFVRubato(cipherParam, params, encoder, encryptor, evaluator, nbInitModDown).cipher
+	case CipherPasta:
+		return newMFVPasta(cipherParam, params, encoder, encryptor, evaluator, nbInitModDown).cipher
+	default:
+		panic(fmt.Sprintf("cannot NewMFVCipher: invalid cipher %v", cipher))
+	}
+}
+
+// mfvCipherRounds is implemented by the FV evaluation of the rounds of a stream cipher.
+// mfvCipher evaluates addRoundKey(0), then round(r) for each round r, followed by the modulus switching of the
+// round and by addRoundKey(r), or by finalize() after the last round.
+type mfvCipherRounds interface {
+	// init derives the round constants from the nonces and the counter.
+	init(nonce [][]byte, counter []byte)
+	addRoundKey(round int)
+	round(round int)
+	finalize()
+}
+
+// mfvCipher holds the state of the FV evaluation of a stream cipher, and schedules its rounds and modulus switching.
+type mfvCipher struct {
+	numRound      int
+	stateSize     int
+	blockSize     int
+	slots         int
+	nbInitModDown int
+	keyState      bool // the state is initialized with the key instead of the constants (1, ..., stateSize)
+
+	params    *Parameters
+	encoder   MFVEncoder
+	encryptor MFVEncryptor
+	evaluator MFVEvaluator
+
+	// Worker pool, where evaluators[0] and encoders[0] are the evaluator and the encoder of the cipher
+	evaluators []MFVEvaluator
+	encoders   []MFVEncoder
+
+	stCt []*Ciphertext
+	mkCt []*Ciphertext
+	rkCt []*Ciphertext   // Buffer for round key
+	rcPt []*PlaintextMul // Buffer for round constants
+
+	rounds mfvCipherRounds
+}
+
+func newMFVCipher(numRound, stateSize, blockSize int, keyState bool, params *Parameters, encoder MFVEncoder, encryptor MFVEncryptor, evaluator MFVEvaluator, nbInitModDown int) (c *mfvCipher) {
+	c = new(mfvCipher)
+
+	c.numRound = numRound
+	c.stateSize = stateSize
+	c.blockSize = blockSize
+	c.keyState = keyState
+	c.slots = params.FVSlots()
+
+	c.params = params
+	c.encoder = encoder
+	c.encryptor = encryptor
+	c.evaluator = evaluator
+	c.evaluators = []MFVEvaluator{evaluator}
+	c.encoders = []MFVEncoder{encoder}
+
+	c.stCt = make([]*Ciphertext, stateSize)
+	c.mkCt = make([]*Ciphertext, stateSize)
+	c.rkCt = make([]*Ciphertext, stateSize)
+	c.rcPt = make([]*PlaintextMul, stateSize)
+
+	c.Reset(nbInitModDown)
+	return
+}
+
+func (c *mfvCipher) NumRound() int {
+	return c.numRound
+}
+
+func (c *mfvCipher) KeySize() int {
+	return c.stateSize
+}
+
+func (c *mfvCipher) BlockSize() int {
+	return c.blockSize
+}
+
+func (c *mfvCipher) SetWorkers(workers int) {
+	if workers < 1 {
+		panic(fmt.Sprintf("cannot SetWorkers: workers should be positive but is %d", workers))
+	}
+	for len(c.evaluators) < workers {
+		c.evaluators = append(c.evaluators, c.evaluator.ShallowCopy())
+		c.encoders = append(c.encoders, NewMFVEncoder(c.params))
+	}
+	c.evaluators = c.evaluators[:workers]
+	c.encoders = c.encoders[:workers]
+}
+
+// parallel calls f(w, i) for i = 0, ..., n-1, spreading the indices over the worker pool, where w is the
+// index of the worker whose evaluator and encoder f should use.
+func (c *mfvCipher) parallel(n int, f func(w, i int)) {
+	parallelize(len(c.evaluators), n, f)
+}
+
+// parallelize calls f(w, i) for i = 0, ..., n-1, spreading the indices over at most workers goroutines,
+// where w < workers is the index of the goroutine. The calls for different indices should be independent.
+func parallelize(workers, n int, f func(w, i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(0, i)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				f(w, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// Reset encrypts the initial states (1, ..., stateSize) and drops nbInitModDown moduli.
+// If the state is initialized with the key, nbInitModDown moduli are dropped from the key by EncKey instead.
+func (c *mfvCipher) Reset(nbInitModDown int) {
+	c.nbInitModDown = nbInitModDown
+	if c.keyState {
+		return
+	}
+	state := make([]uint64, c.slots)
+
+	for i := 0; i < c.stateSize; i++ {
+		for j := 0; j < c.slots; j++ {
+			state[j] = uint64(i + 1) // ic = 1, ..., stateSize
+		}
+		icPT := NewPlaintextFV(c.params)
+		c.encoder.EncodeUintSmall(state, icPT)
+		c.stCt[i] = encryptNew(c.encryptor, c.evaluator, icPT)
+		if nbInitModDown > 0 {
+			c.evaluator.ModSwitchMany(c.stCt[i], c.stCt[i], nbInitModDown)
+		}
+	}
+}
+
+// EncKey encrypts the symmetric key replicated in all the FV slots, at the level of the initial states.
+// As it requires the plaintext key, the server should rather use LoadKey with a bundle produced by the client.
+func (c *mfvCipher) EncKey(key []uint64) (res []*Ciphertext) {
+	if len(key) != c.stateSize {
+		panic(fmt.Sprintf("cannot EncKey: key should have %d elements but %d given", c.stateSize, len(key)))
+	}
+	return encryptKey(c.params, c.encoder, c.encryptor, c.evaluator, key, nil, c.nbInitModDown)
+}
+
+func (c *mfvCipher) LoadKey(bundle *SymmetricKeyBundle) (kCt []*Ciphertext, err error) {
+	if err = bundle.Validate(c.params); err != nil {
+		return nil, fmt.Errorf("invalid symmetric key bundle: %w", err)
+	}
+
+	if len(bundle.Ciphertexts) != c.stateSize {
+		return nil, fmt.Errorf("invalid symmetric key bundle: %d ciphertexts are expected but %d given", c.stateSize, len(bundle.Ciphertexts))
+	}
+
+	if level := c.params.MaxLevel() - c.nbInitModDown; bundle.Ciphertexts[0].Level() != level {
+		return nil, fmt.Errorf("invalid symmetric key bundle: ciphertexts should be at level %d but are at level %d", level, bundle.Ciphertexts[0].Level())
+	}
+
+	kCt = make([]*Ciphertext, c.stateSize)
+	copy(kCt, bundle.Ciphertexts)
+	return kCt, nil
+}
+
+// encryptKey encrypts each element of the key replicated in the given FV slots, or in all the FV slots if fvSlots
+// is nil, and drops nbInitModDown moduli.
+func encryptKey(params *Parameters, encoder MFVEncoder, encryptor MFVEncryptor, evaluator MFVEvaluator, key []uint64, fvSlots []int, nbInitModDown int) (res []*Ciphertext) {
+	slots := params.FVSlots()
+	res = make([]*Ciphertext, len(key))
+
+	for i := range key {
+		dupKey := make([]uint64, slots)
+		if fvSlots == nil {
+			for j := 0; j < slots; j++ {
+				dupKey[j] = key[i]
+			}
+		} else {
+			for _, j := range fvSlots {
+				dupKey[j] = key[i]
+			}
+		}
+
+		keyPt := NewPlaintextFV(params)
+		encoder.EncodeUintSmall(dupKey, keyPt)
+		res[i] = encryptNew(encryptor, evaluator, keyPt)
+		if nbInitModDown > 0 {
+			evaluator.ModSwitchMany(res[i], res[i], nbInitModDown)
+		}
+	}
+	return
+}
+
+// crypt evaluates the cipher, calling modSwitch after the nonlinear layer of each round.
+func (c *mfvCipher) crypt(nonce [][]byte, counter []byte, kCt []*Ciphertext, modSwitch func(round int)) []*Ciphertext {
+	for i := 0; i < c.stateSize; i++ {
+		if c.keyState {
+			c.stCt[i] = copyNew(c.evaluator, kCt[i])
+		} else {
+			c.mkCt[i] = copyNew(c.evaluator, kCt[i])
+		}
+	}
+	c.rounds.init(nonce, counter)
+	c.switchKey()
+
+	c.rounds.addRoundKey(0)
+	for r := 1; r <= c.numRound; r++ {
+		c.rounds.round(r)
+		modSwitch(r)
+		if r < c.numRound {
+			c.rounds.addRoundKey(r)
+		}
+	}
+	c.rounds.finalize()
+	return c.stCt
+}
+
+// Compute ciphertexts without modulus switching
+func (c *mfvCipher) CryptNoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext) []*Ciphertext {
+	return c.crypt(nonce, counter, kCt, func(round int) {})
+}
+
+// Compute ciphertexts with automatic modulus switching
+func (c *mfvCipher) CryptAutoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext, noiseEstimator MFVNoiseEstimator) ([]*Ciphertext, []int) {
+	modDown := make([]int, c.numRound+1)
+	modDown[0] = c.nbInitModDown
+	res := c.crypt(nonce, counter, kCt, func(round int) {
+		c.modSwitchAuto(round, noiseEstimator, modDown)
+	})
+	return res, modDown
+}
+
+// Compute ciphertexts with modulus switching as given in modDown
+func (c *mfvCipher) Crypt(nonce [][]byte, counter []byte, kCt []*Ciphertext, modDown []int) []*Ciphertext {
+	if modDown[0] != c.nbInitModDown {
+		errorString := fmt.Sprintf("nbInitModDown expected %d but %d given", c.nbInitModDown, modDown[0])
+		panic(errorString)
+	}
+
+	return c.crypt(nonce, counter, kCt, func(round int) {
+		c.modSwitch(modDown[round])
+	})
+}
+
+// switchKey brings the encrypted key to the level of the state. The key of a key-state cipher is the state itself.
+func (c *mfvCipher) switchKey() {
+	if c.keyState {
+		return
+	}
+	c.parallel(c.stateSize, func(w, i int) {
+		nbSwitch := c.mkCt[i].Level() - c.stCt[i].Level()
+		if nbSwitch > 0 {
+			c.evaluators[w].ModSwitchMany(c.mkCt[i], c.mkCt[i], nbSwitch)
+		}
+	})
+}
+
+// addRoundKey adds the key multiplied by the round constants rc to the first size elements of the state.
+func (c *mfvCipher) addRoundKey(rc [][]uint64, size int, reduce bool) {
+	c.parallel(size, func(w, i int) {
+		ev := c.evaluators[w]
+		c.rcPt[i] = NewPlaintextMulLvl(c.params, c.stCt[i].Level())
+		c.encoders[w].EncodeUintMulSmall(rc[i], c.rcPt[i])
+
+		c.rkCt[i] = ev.MulNew(c.mkCt[i], c.rcPt[i])
+		if reduce {
+			ev.Add(c.stCt[i], c.rkCt[i], c.stCt[i])
+		} else {
+			ev.AddNoMod(c.stCt[i], c.rkCt[i], c.stCt[i])
+		}
+	})
+}
+
+// linLayer applies MixColumns then MixRows, with the circulant matrix of first row mat, to the state seen as a
+// square matrix, and only computes the first outputs elements of the result. As the coefficients of the matrix
+// are positive, each output is the sum of its row or column plus the inputs multiplied by the coefficients minus one.
+func (c *mfvCipher) linLayer(mat []uint64, outputs int) {
+	stCt := c.stCt
+	n := len(mat)
+	buf := make([]*Ciphertext, n*n)
+
+	// MixColumns
+	c.parallel(n, func(w, col int) {
+		in := make([]*Ciphertext, n)
+		for row := 0; row < n; row++ {
+			in[row] = stCt[row*n+col]
+		}
+		sum := c.sum(c.evaluators[w], in)
+		for row := 0; row < n; row++ {
+			buf[row*n+col] = c.mix(c.evaluators[w], mat, sum, in, row)
+		}
+	})
+
+	// MixRows
+	c.parallel((outputs+n-1)/n, func(w, row int) {
+		in := buf[row*n : (row+1)*n]
+		sum := c.sum(c.evaluators[w], in)
+		for col := 0; col < n && row*n+col < outputs; col++ {
+			stCt[row*n+col] = c.mix(c.evaluators[w], mat, sum, in, col)
+		}
+	})
+}
+
+// mfvMaxLazyAdd is the number of ciphertexts which can be added without modular reduction.
+const mfvMaxLazyAdd = 7
+
+// sum returns the reduced sum of the ciphertexts.
+func (c *mfvCipher) sum(ev MFVEvaluator, in []*Ciphertext) (res *Ciphertext) {
+	res = copyNew(ev, in[0])
+	terms := 1
+	for _, ct := range in[1:] {
+		if terms == mfvMaxLazyAdd {
+			ev.Reduce(res, res)
+			terms = 1
+		}
+		ev.AddNoMod(res, ct, res)
+		terms++
+	}
+	ev.Reduce(res, res)
+	return
+}
+
+// mix returns sum + (mat[k]-1)*in[(i+k)%n] for k = 0, ..., n-1, i.e. the i-th output of the circulant matrix.
+func (c *mfvCipher) mix(ev MFVEvaluator, mat []uint64, sum *Ciphertext, in []*Ciphertext, i int) (res *Ciphertext) {
+	n := len(in)
+	res = copyNew(ev, sum)
+	terms := 1
+	for k, coeff := range mat {
+		for m := uint64(1); m < coeff; m++ {
+			if terms == mfvMaxLazyAdd {
+				ev.Reduce(res, res)
+				terms = 1
+			}
+			ev.AddNoMod(res, in[(i+k)%n], res)
+			terms++
+		}
+	}
+	ev.Reduce(res, res)
+	return
+}
+
+// modSwitchAuto drops the moduli found by findModSwitchAuto with the cipher rule from the state and the key, and
+// records their number in modDown[round].
+func (c *mfvCipher) modSwitchAuto(round int, noiseEstimator MFVNoiseEstimator, modDown []int) {
+	if nbModSwitch := findModSwitchAuto(c.evaluator, c.params, c.stCt, noiseEstimator, cipherModSwitchRule); nbModSwitch > 0 {
+		modDown[round] = nbModSwitch
+		c.modSwitch(nbModSwitch)
+	}
+}
+
+func (c *mfvCipher) modSwitch(nbSwitch int) {
+	if nbSwitch <= 0 {
+		return
+	}
+	c.parallel(c.stateSize, func(w, i int) {
+		c.evaluators[w].ModSwitchMany(c.stCt[i], c.stCt[i], nbSwitch)
+		if !c.keyState {
+			c.evaluators[w].ModSwitchMany(c.mkCt[i], c.mkCt[i], nbSwitch)
+		}
+	})
+}
+
+// mfvFeistel computes x_i = x_i + x_{i-1}^2 for i > 0 on the given part of the state of c.
+// All the squares are computed from the input before being added, as in the serial evaluation in decreasing order of i.
+func mfvFeistel(c *mfvCipher, stCt []*Ciphertext) {
+	n := len(stCt)
+	sq := make([]*Ciphertext, n-1)
+	c.parallel(n-1, func(w, i int) {
+		sq[i] = c.evaluators[w].MulNew(stCt[i], stCt[i])
+		c.evaluators[w].Relinearize(sq[i], sq[i])
+	})
+	c.parallel(n-1, func(w, i int) {
+		c.evaluators[w].Add(stCt[i+1], sq[i], stCt[i+1])
+	})
+}
@@ -0,0 +1,113 @@
+package ckks_fv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMFVCipher(t *testing.T) {
+	testMFVCipher(t, CipherHera, 4, genTestHalfBootParams(RtFHeraParams[0]), HeraModDownParams80[0].CipherModDown)
+
+	hbtpParams := genTestHalfBootParams(RtFRubatoParams[0])
+	hbtpParams.PlainModulus = RubatoParams[RUBATO128S].PlainModulus
+	testMFVCipher(t, CipherRubato, RUBATO128S, hbtpParams, RubatoModDownParams[RUBATO128S].CipherModDown)
+}
+
+// testMFVCipher checks that the keystream evaluated through the common MFVCipher interface decrypts
+// exactly to the keystream of the client-side cipher, with and without modulus switching.
+func testMFVCipher(t *testing.T, cipherType CipherType, cipherParam int, hbtpParams *HalfBootParameters, modDown []int) {
+	params, _ := genTestParams(hbtpParams)
+
+	kgen := NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairSparse(hbtpParams.H)
+	rlk := kgen.GenRelinearizationKey(sk)
+
+	fvEncoder := NewMFVEncoder(params)
+	fvEncryptor := NewMFVEncryptorFromPk(params, pk)
+	fvDecryptor := NewMFVDecryptor(params, sk)
+	fvEvaluator := NewMFVEvaluator(params, EvaluationKey{Rlk: rlk}, nil)
+
+	nonces := newTestNonces(params.FVSlots())
+	counter := newTestNonces(1)[0][:8]
+
+	t.Run(testString("MFVCipher/"+cipherType.String()+"/InvalidCipher/", params), func(t *testing.T) {
+		assert.Panics(t, func() { NewMFVCipher(CipherType(-1), cipherParam, params, fvEncoder, fvEncryptor, fvEvaluator, 0) })
+	})
+
+	cipher := NewMFVCipher(cipherType, cipherParam, params, fvEncoder, fvEncryptor, fvEvaluator, modDown[0])
+	key := newTestKey(cipher.KeySize(), params.PlainModulus())
+
+	var keystream [][]uint64
+	switch cipherType {
+	case CipherHera:
+		require.Equal(t, cipherParam, cipher.NumRound())
+		require.Equal(t, 16, cipher.KeySize())
+		require.Equal(t, 16, cipher.BlockSize())
+		keystream = NewHera(cipherParam, key, params.PlainModulus()).KeyStream(nonces)
+	case CipherRubato:
+		require.Equal(t, RubatoParams[cipherParam].NumRound, cipher.NumRound())
+		require.Equal(t, RubatoParams[cipherParam].Blocksize, cipher.KeySize())
+		require.Equal(t, RubatoParams[cipherParam].Blocksize-4, cipher.BlockSize())
+		rubato := NewRubato(cipherParam, key)
+		keystream = make([][]uint64, len(nonces))
+		for i := range nonces {
+			keystream[i] = rubato.CryptNoNoise(nonces[i], counter)
+		}
+	}
+
+	verify := func(t *testing.T, fvKeystreams []*Ciphertext) {
+		require.Len(t, fvKeystreams, cipher.KeySize())
+		for s := 0; s < cipher.BlockSize(); s++ {
+			have := fvEncoder.DecodeUintSmallNew(fvDecryptor.DecryptNew(fvKeystreams[s]))
+			for i := 0; i < params.FVSlots(); i++ {
+				require.Equal(t, keystream[i][s], have[i], "state %d, slot %d", s, i)
+			}
+		}
+	}
+
+	t.Run(testString("MFVCipher/"+cipherType.String()+"/Crypt/", params), func(t *testing.T) {
+		kCt := cipher.EncKey(key)
+		verify(t, cipher.Crypt(nonces, counter, kCt, modDown))
+		assert.Panics(t, func() { cipher.EncKey(key[1:]) })
+
+		invalidModDown := append([]int{modDown[0] + 1}, modDown[1:]...)
+		assert.Panics(t, func() { cipher.Crypt(nonces, counter, kCt, invalidModDown) })
+	})
+
+	t.Run(testString("MFVCipher/"+cipherType.String()+"/Workers/", params), func(t *testing.T) {
+		assert.Panics(t, func() { cipher.SetWorkers(0) })
+
+		// The serial and the parallel evaluations start from the same encrypted initial states
+		c := cipher.(*mfvCipher)
+		kCt := cipher.EncKey(key)
+		cipher.Reset(modDown[0])
+		initCt := make([]*Ciphertext, c.stateSize)
+		for i := range initCt {
+			initCt[i] = c.stCt[i].CopyNew().Ciphertext()
+		}
+
+		serial := make([]*Ciphertext, c.stateSize)
+		for i, ct := range cipher.Crypt(nonces, counter, kCt, modDown) {
+			serial[i] = ct.CopyNew().Ciphertext()
+		}
+
+		cipher.SetWorkers(4)
+		copy(c.stCt, initCt)
+		parallel := cipher.Crypt(nonces, counter, kCt, modDown)
+		for i := range serial {
+			require.Equal(t, serial[i].Degree(), parallel[i].Degree())
+			for j := range serial[i].Value() {
+				require.Equal(t, serial[i].Value()[j].Coeffs, parallel[i].Value()[j].Coeffs, "state %d", i)
+			}
+		}
+		verify(t, parallel)
+		cipher.SetWorkers(1)
+	})
+
+	t.Run(testString("MFVCipher/"+cipherType.String()+"/CryptNoModSwitch/", params), func(t *testing.T) {
+		cipher.Reset(0)
+		verify(t, cipher.CryptNoModSwitch(nonces, counter, cipher.EncKey(key)))
+	})
+}
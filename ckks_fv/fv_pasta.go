@@ -0,0 +1,232 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// PastaParam is a parameter set of the PASTA cipher, whose state consists of two halves of Blocksize elements.
+type PastaParam struct {
+	Blocksize    int
+	PlainModulus uint64
+	NumRound     int
+}
+
+// Validate checks that the state size and the number of rounds are positive, and that the plaintext modulus is
+// a prime smaller than 2^63 (so that the sum of two elements does not overflow) for which the cube is invertible,
+// i.e. gcd(3, PlainModulus-1) = 1.
+func (pastaParam PastaParam) Validate() error {
+	if pastaParam.Blocksize < 1 {
+		return fmt.Errorf("blocksize should be a positive integer but is %d", pastaParam.Blocksize)
+	}
+	if pastaParam.NumRound < 1 {
+		return fmt.Errorf("numRound should be a positive integer but is %d", pastaParam.NumRound)
+	}
+	if !new(big.Int).SetUint64(pastaParam.PlainModulus).ProbablyPrime(20) {
+		return fmt.Errorf("plaintext modulus %d is not a prime", pastaParam.PlainModulus)
+	}
+	return validateCubeModulus(pastaParam.PlainModulus)
+}
+
+// Parameter sets of PASTA with the block sizes and numbers of rounds of PASTA3 and PASTA4.
+// Their plaintext moduli are chosen for the RtF framework (cubing is a bijection of Z_t), and no security
+// level is claimed for them. PASTA4L is PASTA4 with a 33-bit plaintext modulus.
+const (
+	PASTA3 = iota
+	PASTA4
+	PASTA4L
+)
+
+var PastaParams = []PastaParam{
+	{
+		// PASTA3
+		Blocksize:    128,
+		PlainModulus: 0x3ee0001,
+		NumRound:     3,
+	},
+	{
+		// PASTA4
+		Blocksize:    32,
+		PlainModulus: 0x3ee0001,
+		NumRound:     4,
+	},
+	{
+		// PASTA4L
+		Blocksize:    32,
+		PlainModulus: 0x100180001,
+		NumRound:     4,
+	},
+}
+
+type MFVPasta interface {
+	Crypt(nonce [][]byte, counter []byte, kCt []*Ciphertext, pastaModDown []int) []*Ciphertext
+	CryptNoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext) []*Ciphertext
+	CryptAutoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext, noiseEstimator MFVNoiseEstimator) (res []*Ciphertext, pastaModDown []int)
+	Reset(nbInitModDown int)
+	EncKey(key []uint64) (res []*Ciphertext)
+	LoadKey(bundle *SymmetricKeyBundle) (kCt []*Ciphertext, err error)
+}
+
+type mfvPasta struct {
+	cipher *mfvCipher
+
+	pastaParam int
+	blocksize  int
+	layers     []*pastaAffineLayer // Randomness of the current affine layer, for each slot
+	xof        []sha3.ShakeHash
+}
+
+func NewMFVPasta(pastaParam int, params *Parameters, encoder MFVEncoder, encryptor MFVEncryptor, evaluator MFVEvaluator, nbInitModDown int) MFVPasta {
+	if pastaParam < 0 || pastaParam >= len(PastaParams) {
+		panic(fmt.Sprintf("cannot NewMFVPasta: invalid pastaParam %d", pastaParam))
+	}
+	if err := PastaParams[pastaParam].Validate(); err != nil {
+		panic(fmt.Sprintf("cannot NewMFVPasta: %v", err))
+	}
+	return newMFVPasta(pastaParam, params, encoder, encryptor, evaluator, nbInitModDown)
+}
+
+func newMFVPasta(pastaParam int, params *Parameters, encoder MFVEncoder, encryptor MFVEncryptor, evaluator MFVEvaluator, nbInitModDown int) *mfvPasta {
+	pasta := new(mfvPasta)
+
+	pasta.pastaParam = pastaParam
+	pasta.blocksize = PastaParams[pastaParam].Blocksize
+
+	// The state of PASTA is initialized with the key, and the left half is the keystream
+	pasta.cipher = newMFVCipher(PastaParams[pastaParam].NumRound, 2*pasta.blocksize, pasta.blocksize, true, params, encoder, encryptor, evaluator, nbInitModDown)
+	pasta.cipher.rounds = pasta
+
+	slots := params.FVSlots()
+	pasta.xof = make([]sha3.ShakeHash, slots)
+	pasta.layers = make([]*pastaAffineLayer, slots)
+	for i := range pasta.layers {
+		pasta.layers[i] = newPastaAffineLayer(pasta.blocksize)
+	}
+	return pasta
+}
+
+func (pasta *mfvPasta) Reset(nbInitModDown int) {
+	pasta.cipher.Reset(nbInitModDown)
+}
+
+func (pasta *mfvPasta) EncKey(key []uint64) (res []*Ciphertext) {
+	return pasta.cipher.EncKey(key)
+}
+
+func (pasta *mfvPasta) LoadKey(bundle *SymmetricKeyBundle) (kCt []*Ciphertext, err error) {
+	return pasta.cipher.LoadKey(bundle)
+}
+
+// Compute ciphertexts without modulus switching
+func (pasta *mfvPasta) CryptNoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext) []*Ciphertext {
+	return pasta.cipher.CryptNoModSwitch(nonce, counter, kCt)
+}
+
+// Compute ciphertexts with automatic modulus switching
+func (pasta *mfvPasta) CryptAutoModSwitch(nonce [][]byte, counter []byte, kCt []*Ciphertext, noiseEstimator MFVNoiseEstimator) ([]*Ciphertext, []int) {
+	return pasta.cipher.CryptAutoModSwitch(nonce, counter, kCt, noiseEstimator)
+}
+
+// Compute ciphertexts with modulus switching as given in pastaModDown
+func (pasta *mfvPasta) Crypt(nonce [][]byte, counter []byte, kCt []*Ciphertext, pastaModDown []int) []*Ciphertext {
+	return pasta.cipher.Crypt(nonce, counter, kCt, pastaModDown)
+}
+
+// Initialize the XOF of each slot, from which the affine layers are sampled round by round
+func (pasta *mfvPasta) init(nonce [][]byte, counter []byte) {
+	for i := range pasta.xof {
+		pasta.xof[i] = newPastaXOF(nonce[i], counter)
+	}
+}
+
+// The key is the initial state of PASTA, so that it is mixed through the affine layers
+func (pasta *mfvPasta) addRoundKey(round int) {
+	pasta.affineLayer()
+}
+
+func (pasta *mfvPasta) round(round int) {
+	if round < pasta.cipher.numRound {
+		pasta.feistel(pasta.cipher.stCt[:pasta.blocksize])
+		pasta.feistel(pasta.cipher.stCt[pasta.blocksize:])
+	} else {
+		pasta.cube()
+	}
+}
+
+func (pasta *mfvPasta) finalize() {
+	pasta.affineLayer()
+}
+
+// affineLayer multiplies each half of the state by the matrix of its slot, adds the round constants and mixes the halves.
+func (pasta *mfvPasta) affineLayer() {
+	c := pasta.cipher
+	ev := c.evaluator
+	n := pasta.blocksize
+	t := c.params.PlainModulus()
+	lvl := c.stCt[0].Level()
+
+	for slot, layer := range pasta.layers {
+		layer.sample(pasta.xof[slot], t)
+	}
+
+	vals := make([][]uint64, len(c.encoders))
+	for w := range vals {
+		vals[w] = make([]uint64, c.slots)
+	}
+	tmp := make([]*Ciphertext, n)
+	res := make([]*Ciphertext, 2*n)
+	for h := 0; h < 2; h++ {
+		for i := 0; i < n; i++ {
+			for _, layer := range pasta.layers {
+				layer.nextRow(h, i, t)
+			}
+
+			c.parallel(n, func(w, j int) {
+				for slot, layer := range pasta.layers {
+					vals[w][slot] = layer.row[h][j]
+				}
+				matPt := NewPlaintextMulLvl(c.params, lvl)
+				c.encoders[w].EncodeUintMulSmall(vals[w], matPt)
+				tmp[j] = c.evaluators[w].MulNew(c.stCt[h*n+j], matPt)
+			})
+
+			res[h*n+i] = tmp[0]
+			for j := 1; j < n; j++ {
+				ev.Add(res[h*n+i], tmp[j], res[h*n+i])
+			}
+
+			for slot, layer := range pasta.layers {
+				vals[0][slot] = layer.rc[h][i]
+			}
+			rcPt := NewPlaintextFVLvl(c.params, lvl)
+			c.encoder.EncodeUintSmall(vals[0], rcPt)
+			ev.Add(res[h*n+i], rcPt, res[h*n+i])
+		}
+	}
+
+	// Mix
+	for i := 0; i < n; i++ {
+		sum := ev.AddNew(res[i], res[i+n])
+		ev.Add(res[i], sum, res[i])
+		ev.Add(res[i+n], sum, res[i+n])
+	}
+	copy(c.stCt, res)
+}
+
+func (pasta *mfvPasta) feistel(stCt []*Ciphertext) {
+	mfvFeistel(pasta.cipher, stCt)
+}
+
+func (pasta *mfvPasta) cube() {
+	c := pasta.cipher
+	stCt := c.stCt
+	c.parallel(len(stCt), func(w, i int) {
+		ev := c.evaluators[w]
+		x2 := ev.MulNew(stCt[i], stCt[i])
+		y2 := ev.RelinearizeNew(x2)
+		x3 := ev.MulNew(y2, stCt[i])
+		stCt[i] = ev.RelinearizeNew(x3)
+	})
+}
@@ -11,6 +11,14 @@ import (
 // If the input ciphertext is at level one or more, the input scale does not need to be an exact power of two as one level
 // can be used to do a scale matching.
 func (hbtp *HalfBootstrapper) HalfBoot(ct *Ciphertext, repack bool) (ct0, ct1 *Ciphertext) {
+	ct0, ct1 = hbtp.modRaiseCoeffsToSlots(ct)
+	return hbtp.evalModRepack(ct0, ct1, repack)
+}
+
+// modRaiseCoeffsToSlots raises the modulus of ct and returns the two halves of its coefficients in the slots of ct0
+// and ct1. Each slot holds (m + MessageRatio*I)/SinRange, where I is the integer overflow of the modulus raising and
+// the message m is divided by the factor Q0/2^round(log2(Q0)) folded in the CoeffsToSlots matrices.
+func (hbtp *HalfBootstrapper) modRaiseCoeffsToSlots(ct *Ciphertext) (ct0, ct1 *Ciphertext) {
 
 	//var t time.Time
 	// var ct0, ct1 *Ciphertext
@@ -46,11 +54,24 @@ func (hbtp *HalfBootstrapper) HalfBoot(ct *Ciphertext, repack bool) (ct0, ct1 *C
 		hbtp.ckksEvaluator.ScaleUp(ct, math.Round(hbtp.prescale/ct.Scale()), ct)
 	}
 
+	// Sparse-secret encapsulation: ct_{Q_0} under s -> ct_{Q_0} under the ephemeral sparse secret
+	if hbtp.EphemeralSecretWeight != 0 {
+		if hbtp.swkDtS == nil || hbtp.swkStD == nil {
+			panic("cannot HalfBoot: switching keys of the sparse-secret encapsulation are not set (see SetEncapsulationKeys)")
+		}
+		hbtp.ckksEvaluator.SwitchKeys(ct, hbtp.swkDtS, ct)
+	}
+
 	// ModUp ct_{Q_0} -> ct_{Q_L}
 	//t = time.Now()
 	ct = hbtp.modUp(ct)
 	//log.Println("After ModUp  :", time.Now().Sub(t), ct.Level(), ct.Scale())
 
+	// ct_{Q_L} under the ephemeral sparse secret -> ct_{Q_L} under s
+	if hbtp.EphemeralSecretWeight != 0 {
+		hbtp.ckksEvaluator.SwitchKeys(ct, hbtp.swkStD, ct)
+	}
+
 	// Brings the ciphertext scale to sineQi/(Q0/scale) if its under
 	hbtp.ckksEvaluator.ScaleUp(ct, math.Round(hbtp.postscale/ct.Scale()), ct)
 
@@ -65,10 +86,24 @@ func (hbtp *HalfBootstrapper) HalfBoot(ct *Ciphertext, repack bool) (ct0, ct1 *C
 	ct0, ct1 = CoeffsToSlotsWithoutRepack(ct, hbtp.pDFTInvWithoutRepack, hbtp.ckksEvaluator)
 	//log.Println("After CtS    :", time.Now().Sub(t), ct0.Level(), ct0.Scale())
 
+	return ct0, ct1
+}
+
+// evalModRepack removes the overflows of the modulus raising from the outputs of modRaiseCoeffsToSlots with the
+// SineEval, which is valid as long as they are smaller than SinRange, and repacks ct1 into ct0 if repack is set.
+func (hbtp *HalfBootstrapper) evalModRepack(ct0, ct1 *Ciphertext, repack bool) (*Ciphertext, *Ciphertext) {
+
 	// Part 2 : SineEval
 	//t = time.Now()
-	if repack {
-		hbtp.ckksEvaluator.Rotate(ct1, hbtp.params.Slots()/2, ct1)
+	if repack && halfBootSparseFVSlots(hbtp.params) {
+		// The slots beyond the FV slots hold the unused coefficients, whose ModRaise overflows would add up
+		// before the SineEval: they are brought back to zero by the SineEval before the repacking.
+		ct0, ct1 = hbtp.evaluateSine(ct0, ct1)
+		hbtp.ckksEvaluator.Rotate(ct1, halfBootRepackRotation(hbtp.params), ct1)
+		hbtp.ckksEvaluator.Add(ct0, ct1, ct0)
+		ct1 = nil
+	} else if repack {
+		hbtp.ckksEvaluator.Rotate(ct1, halfBootRepackRotation(hbtp.params), ct1)
 		hbtp.ckksEvaluator.Add(ct0, ct1, ct0)
 		ct0, _ = hbtp.evaluateSine(ct0, nil)
 		ct1 = nil
@@ -97,6 +132,34 @@ func (hbtp *HalfBootstrapper) HalfBoot(ct *Ciphertext, repack bool) (ct0, ct1 *C
 	return ct0, ct1
 }
 
+// HalfBootComplex half-bootstraps a ciphertext whose FV slots hold complex values z_k = x_k + i*y_k, for k < FVSlots/2,
+// with x_k in the k-th FV slot and y_k in the (FVSlots/2+k)-th FV slot, i.e. such that HalfBoot without repacking returns
+// the x_k in the first FVSlots/2 slots of ct0 and the y_k in the first FVSlots/2 slots of ct1. It returns ct0 + i*ct1,
+// whose k-th slot holds z_k for k < FVSlots/2 and zero otherwise (with LogSlots slots). The multiplication by i and the
+// addition consume no level, so that the output is at the level of the outputs of HalfBoot.
+func (hbtp *HalfBootstrapper) HalfBootComplex(ct *Ciphertext) (ctOut *Ciphertext) {
+	ct0, ct1 := hbtp.HalfBoot(ct, false)
+	hbtp.ckksEvaluator.MultByi(ct1, ct1)
+	hbtp.ckksEvaluator.Add(ct0, ct1, ct0)
+	return ct0
+}
+
+// halfBootRepackRotation returns the rotation of the repacking of HalfBoot, which moves the first FVSlots/2 slots of ct1
+// after the first FVSlots/2 slots of ct0 (ciphertexts with LogSlots < LogN-1 are periodic in Slots). It is Slots/2 if
+// the FV slots are not set or if FVSlots >= Slots.
+func halfBootRepackRotation(params *Parameters) int {
+	if halfBootSparseFVSlots(params) {
+		return params.Slots() - params.FVSlots()/2
+	}
+	return params.Slots() / 2
+}
+
+// halfBootSparseFVSlots returns true if the FV slots are set and fewer than the CKKS slots, in which case
+// HalfBoot evaluates the sine on both halves before the repacking so that the other slots are zero.
+func halfBootSparseFVSlots(params *Parameters) bool {
+	return params.LogFVSlots() > 0 && params.LogFVSlots() < params.LogSlots()
+}
+
 func (hbtp *HalfBootstrapper) subSum(ct *Ciphertext) *Ciphertext {
 
 	for i := hbtp.params.logSlots; i < hbtp.params.MaxLogSlots(); i++ {
@@ -1,8 +1,12 @@
 package ckks_fv
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
-	//"fmt"
+
+	"github.com/ldsec/lattigo/v2/utils"
 )
 
 // HalfBootParameters is a struct for the default half-boot parameters
@@ -17,7 +21,7 @@ type HalfBootParameters struct {
 	PlainModulus uint64
 	Scale        float64
 	Sigma        float64
-	H            int     // Hamming weight of the secret key
+	H            int     // Hamming weight of the secret key (0 for a uniform ternary secret key)
 	SinType      SinType // Chose betwenn [Sin(2*pi*x)] or [cos(2*pi*x/r) with double angle formula]
 	MessageRatio float64 // Ratio between Q0 and m, i.e. Q[0]/|m|
 	SinRange     int     // K parameter (interpolation in the range -K to K)
@@ -25,6 +29,12 @@ type HalfBootParameters struct {
 	SinRescal    int     // Number of rescale and double angle formula (only applies for cos)
 	ArcSineDeg   int     // Degree of the Taylor arcsine composed with f(2*pi*x) (if zero then not used)
 	MaxN1N2Ratio float64 // n1/n2 ratio for the bsgs algo for matrix x vector eval
+
+	// EphemeralSecretWeight is the Hamming weight of the ephemeral sparse secret key of the sparse-secret encapsulation.
+	// If non-zero, the ciphertext is switched to the ephemeral secret key before the ModRaise and back to the secret
+	// key right after it, so that the range of the sine evaluation (SinRange) only depends on EphemeralSecretWeight and
+	// the secret key can be dense (H = 0). The switching keys are generated by KeyGenerator.GenEncapsulationSwitchingKeys.
+	EphemeralSecretWeight int
 }
 
 // Params generates a new set of Parameters from the HalfBootParameters
@@ -59,6 +69,8 @@ func (hb *HalfBootParameters) Copy() *HalfBootParameters {
 		SinRescal:    hb.SinRescal,
 		ArcSineDeg:   hb.ArcSineDeg,
 		MaxN1N2Ratio: hb.MaxN1N2Ratio,
+
+		EphemeralSecretWeight: hb.EphemeralSecretWeight,
 	}
 
 	// KeySwitchModuli
@@ -91,6 +103,355 @@ func (hb *HalfBootParameters) Copy() *HalfBootParameters {
 	return paramsCopy
 }
 
+// Equals compares two sets of HalfBootParameters for equality.
+func (hb *HalfBootParameters) Equals(other *HalfBootParameters) bool {
+	if hb == other {
+		return true
+	}
+
+	if hb == nil || other == nil {
+		return false
+	}
+
+	if hb.LogN != other.LogN || hb.LogSlots != other.LogSlots || hb.PlainModulus != other.PlainModulus ||
+		hb.Scale != other.Scale || hb.Sigma != other.Sigma || hb.H != other.H || hb.SinType != other.SinType ||
+		hb.MessageRatio != other.MessageRatio || hb.SinRange != other.SinRange || hb.SinDeg != other.SinDeg ||
+		hb.SinRescal != other.SinRescal || hb.ArcSineDeg != other.ArcSineDeg || hb.MaxN1N2Ratio != other.MaxN1N2Ratio ||
+		hb.EphemeralSecretWeight != other.EphemeralSecretWeight ||
+		hb.SineEvalModuli.ScalingFactor != other.SineEvalModuli.ScalingFactor {
+		return false
+	}
+
+	equalModuli := func(a, b []uint64) bool {
+		return len(a) == len(b) && utils.EqualSliceUint64(a, b)
+	}
+
+	if !equalModuli(hb.ResidualModuli, other.ResidualModuli) || !equalModuli(hb.KeySwitchModuli, other.KeySwitchModuli) ||
+		!equalModuli(hb.DiffScaleModulus, other.DiffScaleModulus) || !equalModuli(hb.SineEvalModuli.Qi, other.SineEvalModuli.Qi) ||
+		!equalModuli(hb.CoeffsToSlotsModuli.Qi, other.CoeffsToSlotsModuli.Qi) {
+		return false
+	}
+
+	if len(hb.CoeffsToSlotsModuli.ScalingFactor) != len(other.CoeffsToSlotsModuli.ScalingFactor) {
+		return false
+	}
+
+	for i := range hb.CoeffsToSlotsModuli.ScalingFactor {
+		if len(hb.CoeffsToSlotsModuli.ScalingFactor[i]) != len(other.CoeffsToSlotsModuli.ScalingFactor[i]) {
+			return false
+		}
+
+		for j := range hb.CoeffsToSlotsModuli.ScalingFactor[i] {
+			if hb.CoeffsToSlotsModuli.ScalingFactor[i][j] != other.CoeffsToSlotsModuli.ScalingFactor[i][j] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Validate checks that the HalfBootParameters are consistent: the ring degree, the number of slots,
+// the sine evaluation settings and the moduli chain (NTT-friendly primes for the ring degree).
+func (hb *HalfBootParameters) Validate() error {
+	if hb.LogN < MinLogN || hb.LogN > MaxLogN {
+		return fmt.Errorf("LogN should be between %d and %d", MinLogN, MaxLogN)
+	}
+
+	if hb.LogSlots < 0 || hb.LogSlots > hb.LogN-1 {
+		return fmt.Errorf("LogSlots should be between 0 and %d", hb.LogN-1)
+	}
+
+	// The plaintext modulus can be left to zero when it is given by the cipher (e.g. Rubato)
+	if hb.PlainModulus == 1 {
+		return errors.New("invalid plaintext modulus")
+	}
+
+	if hb.H < 0 || hb.H > 1<<hb.LogN {
+		return errors.New("invalid secret Hamming weight")
+	}
+
+	if hb.EphemeralSecretWeight < 0 || hb.EphemeralSecretWeight > 1<<hb.LogN {
+		return errors.New("invalid ephemeral secret Hamming weight")
+	}
+
+	if hb.SinType > Cos2 {
+		return fmt.Errorf("invalid SinType %d", hb.SinType)
+	}
+
+	if hb.SinRange < 0 || hb.SinDeg < 0 || hb.SinRescal < 0 || hb.ArcSineDeg < 0 {
+		return errors.New("invalid sine evaluation parameters")
+	}
+
+	if len(hb.DiffScaleModulus) != 1 {
+		return errors.New("DiffScaleModulus should contain exactly one modulus")
+	}
+
+	if len(hb.CoeffsToSlotsModuli.ScalingFactor) != len(hb.CoeffsToSlotsModuli.Qi) {
+		return errors.New("CoeffsToSlotsModuli should have one list of scaling factors per modulus")
+	}
+
+	Qi := append(append([]uint64{}, hb.ResidualModuli...), hb.DiffScaleModulus...)
+	Qi = append(Qi, hb.SineEvalModuli.Qi...)
+	Qi = append(Qi, hb.CoeffsToSlotsModuli.Qi...)
+
+	return checkModuli(&Moduli{Qi, hb.KeySwitchModuli}, hb.LogN)
+}
+
+// HalfBootParametersVersion is the version of the binary encoding of HalfBootParameters, stored in its first byte.
+// The encoding of version 1, which has no EphemeralSecretWeight, is still decoded by UnmarshalBinary.
+const HalfBootParametersVersion = 2
+
+// MarshalBinary returns a []byte representation of the HalfBootParameters.
+func (hb *HalfBootParameters) MarshalBinary() ([]byte, error) {
+	if err := hb.Validate(); err != nil {
+		return nil, fmt.Errorf("cannot MarshalBinary: %w", err)
+	}
+
+	// Data 112 byte + #CtS byte + (#moduli + #CtS scaling factors) * 8 byte:
+	// 1 byte : version
+	// 1 byte : logN
+	// 1 byte : logSlots
+	// 8 byte : t
+	// 8 byte : scale
+	// 8 byte : sigma
+	// 8 byte : H
+	// 8 byte : SinType
+	// 8 byte : MessageRatio
+	// 8 byte : SinRange
+	// 8 byte : SinDeg
+	// 8 byte : SinRescal
+	// 8 byte : ArcSineDeg
+	// 8 byte : MaxN1N2Ratio
+	// 8 byte : SineEval scaling factor
+	// 1 byte : #ResidualModuli
+	// 1 byte : #KeySwitchModuli
+	// 1 byte : #DiffScaleModulus
+	// 1 byte : #SineEvalModuli
+	// 1 byte : #CoeffsToSlotsModuli
+	// #CtS byte : #scaling factors of each CoeffsToSlots modulus
+	// #moduli * 8 byte : moduli
+	// #CtS scaling factors * 8 byte : CoeffsToSlots scaling factors
+	// 8 byte : EphemeralSecretWeight (not in version 1, whose data are 104 byte)
+	ctsScalingFactors := 0
+	for i := range hb.CoeffsToSlotsModuli.ScalingFactor {
+		if len(hb.CoeffsToSlotsModuli.ScalingFactor[i]) > 0xFF {
+			return nil, errors.New("cannot MarshalBinary: too many CoeffsToSlots scaling factors")
+		}
+		ctsScalingFactors += len(hb.CoeffsToSlotsModuli.ScalingFactor[i])
+	}
+
+	moduliCount := len(hb.ResidualModuli) + len(hb.KeySwitchModuli) + len(hb.DiffScaleModulus) + len(hb.SineEvalModuli.Qi) + len(hb.CoeffsToSlotsModuli.Qi)
+
+	b := utils.NewBuffer(make([]byte, 0, 112+len(hb.CoeffsToSlotsModuli.Qi)+(moduliCount+ctsScalingFactors)<<3))
+
+	b.WriteUint8(HalfBootParametersVersion)
+	b.WriteUint8(uint8(hb.LogN))
+	b.WriteUint8(uint8(hb.LogSlots))
+	b.WriteUint64(hb.PlainModulus)
+	b.WriteUint64(math.Float64bits(hb.Scale))
+	b.WriteUint64(math.Float64bits(hb.Sigma))
+	b.WriteUint64(uint64(hb.H))
+	b.WriteUint64(uint64(hb.SinType))
+	b.WriteUint64(math.Float64bits(hb.MessageRatio))
+	b.WriteUint64(uint64(hb.SinRange))
+	b.WriteUint64(uint64(hb.SinDeg))
+	b.WriteUint64(uint64(hb.SinRescal))
+	b.WriteUint64(uint64(hb.ArcSineDeg))
+	b.WriteUint64(math.Float64bits(hb.MaxN1N2Ratio))
+	b.WriteUint64(math.Float64bits(hb.SineEvalModuli.ScalingFactor))
+	b.WriteUint8(uint8(len(hb.ResidualModuli)))
+	b.WriteUint8(uint8(len(hb.KeySwitchModuli)))
+	b.WriteUint8(uint8(len(hb.DiffScaleModulus)))
+	b.WriteUint8(uint8(len(hb.SineEvalModuli.Qi)))
+	b.WriteUint8(uint8(len(hb.CoeffsToSlotsModuli.Qi)))
+	for i := range hb.CoeffsToSlotsModuli.ScalingFactor {
+		b.WriteUint8(uint8(len(hb.CoeffsToSlotsModuli.ScalingFactor[i])))
+	}
+	b.WriteUint64Slice(hb.ResidualModuli)
+	b.WriteUint64Slice(hb.KeySwitchModuli)
+	b.WriteUint64Slice(hb.DiffScaleModulus)
+	b.WriteUint64Slice(hb.SineEvalModuli.Qi)
+	b.WriteUint64Slice(hb.CoeffsToSlotsModuli.Qi)
+	for i := range hb.CoeffsToSlotsModuli.ScalingFactor {
+		for _, sf := range hb.CoeffsToSlotsModuli.ScalingFactor[i] {
+			b.WriteUint64(math.Float64bits(sf))
+		}
+	}
+	b.WriteUint64(uint64(hb.EphemeralSecretWeight))
+
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a []byte into a HalfBootParameters struct and checks its validity.
+// The encoding of version 1 is decoded with EphemeralSecretWeight = 0.
+func (hb *HalfBootParameters) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 104 {
+		return errors.New("invalid halfboot parameters encoding")
+	}
+
+	// Number of 8-byte words of EphemeralSecretWeight
+	var lenWeight int
+	switch data[0] {
+	case 1:
+	case HalfBootParametersVersion:
+		lenWeight = 1
+	default:
+		return fmt.Errorf("unsupported halfboot parameters version %d", data[0])
+	}
+
+	b := utils.NewBuffer(data[1:])
+
+	hb.LogN = int(b.ReadUint8())
+	hb.LogSlots = int(b.ReadUint8())
+	hb.PlainModulus = b.ReadUint64()
+	hb.Scale = math.Float64frombits(b.ReadUint64())
+	hb.Sigma = math.Float64frombits(b.ReadUint64())
+	hb.H = int(b.ReadUint64())
+	hb.SinType = SinType(b.ReadUint64())
+	hb.MessageRatio = math.Float64frombits(b.ReadUint64())
+	hb.SinRange = int(b.ReadUint64())
+	hb.SinDeg = int(b.ReadUint64())
+	hb.SinRescal = int(b.ReadUint64())
+	hb.ArcSineDeg = int(b.ReadUint64())
+	hb.MaxN1N2Ratio = math.Float64frombits(b.ReadUint64())
+	hb.SineEvalModuli.ScalingFactor = math.Float64frombits(b.ReadUint64())
+
+	lenResidual := int(b.ReadUint8())
+	lenKeySwitch := int(b.ReadUint8())
+	lenDiffScale := int(b.ReadUint8())
+	lenSineEval := int(b.ReadUint8())
+	lenCtS := int(b.ReadUint8())
+
+	if len(b.Bytes()) < lenCtS {
+		return errors.New("invalid halfboot parameters encoding")
+	}
+
+	lenCtSScalingFactors := make([]uint8, lenCtS)
+	b.ReadUint8Slice(lenCtSScalingFactors)
+
+	ctsScalingFactors := 0
+	for _, l := range lenCtSScalingFactors {
+		ctsScalingFactors += int(l)
+	}
+
+	if len(b.Bytes()) != (lenResidual+lenKeySwitch+lenDiffScale+lenSineEval+lenCtS+ctsScalingFactors+lenWeight)<<3 {
+		return errors.New("invalid halfboot parameters encoding")
+	}
+
+	hb.ResidualModuli = make([]uint64, lenResidual)
+	hb.KeySwitchModuli = make([]uint64, lenKeySwitch)
+	hb.DiffScaleModulus = make([]uint64, lenDiffScale)
+	hb.SineEvalModuli.Qi = make([]uint64, lenSineEval)
+	hb.CoeffsToSlotsModuli.Qi = make([]uint64, lenCtS)
+
+	b.ReadUint64Slice(hb.ResidualModuli)
+	b.ReadUint64Slice(hb.KeySwitchModuli)
+	b.ReadUint64Slice(hb.DiffScaleModulus)
+	b.ReadUint64Slice(hb.SineEvalModuli.Qi)
+	b.ReadUint64Slice(hb.CoeffsToSlotsModuli.Qi)
+
+	hb.CoeffsToSlotsModuli.ScalingFactor = make([][]float64, lenCtS)
+	for i := range hb.CoeffsToSlotsModuli.ScalingFactor {
+		hb.CoeffsToSlotsModuli.ScalingFactor[i] = make([]float64, lenCtSScalingFactors[i])
+		for j := range hb.CoeffsToSlotsModuli.ScalingFactor[i] {
+			hb.CoeffsToSlotsModuli.ScalingFactor[i][j] = math.Float64frombits(b.ReadUint64())
+		}
+	}
+
+	hb.EphemeralSecretWeight = 0
+	if lenWeight == 1 {
+		hb.EphemeralSecretWeight = int(b.ReadUint64())
+	}
+
+	return hb.Validate()
+}
+
+// halfBootParametersJSON is the JSON representation of HalfBootParameters. The embedded moduli
+// are given explicit names since the promoted fields of SineEvalModuli and CoeffsToSlotsModuli collide.
+type halfBootParametersJSON struct {
+	LogN                  int
+	LogSlots              int
+	PlainModulus          uint64
+	Scale                 float64
+	Sigma                 float64
+	H                     int
+	SinType               SinType
+	MessageRatio          float64
+	SinRange              int
+	SinDeg                int
+	SinRescal             int
+	ArcSineDeg            int
+	MaxN1N2Ratio          float64
+	EphemeralSecretWeight int
+	ResidualModuli        []uint64
+	KeySwitchModuli       []uint64
+	DiffScaleModulus      []uint64
+	SineEvalModuli        SineEvalModuli
+	CoeffsToSlotsModuli   CoeffsToSlotsModuli
+}
+
+// MarshalJSON returns a JSON representation of the HalfBootParameters.
+func (hb *HalfBootParameters) MarshalJSON() ([]byte, error) {
+	if err := hb.Validate(); err != nil {
+		return nil, fmt.Errorf("cannot MarshalJSON: %w", err)
+	}
+
+	return json.Marshal(&halfBootParametersJSON{
+		LogN:                  hb.LogN,
+		LogSlots:              hb.LogSlots,
+		PlainModulus:          hb.PlainModulus,
+		Scale:                 hb.Scale,
+		Sigma:                 hb.Sigma,
+		H:                     hb.H,
+		SinType:               hb.SinType,
+		MessageRatio:          hb.MessageRatio,
+		SinRange:              hb.SinRange,
+		SinDeg:                hb.SinDeg,
+		SinRescal:             hb.SinRescal,
+		ArcSineDeg:            hb.ArcSineDeg,
+		MaxN1N2Ratio:          hb.MaxN1N2Ratio,
+		EphemeralSecretWeight: hb.EphemeralSecretWeight,
+		ResidualModuli:        hb.ResidualModuli,
+		KeySwitchModuli:       hb.KeySwitchModuli,
+		DiffScaleModulus:      hb.DiffScaleModulus,
+		SineEvalModuli:        hb.SineEvalModuli,
+		CoeffsToSlotsModuli:   hb.CoeffsToSlotsModuli,
+	})
+}
+
+// UnmarshalJSON decodes a JSON representation into a HalfBootParameters struct and checks its validity.
+func (hb *HalfBootParameters) UnmarshalJSON(data []byte) (err error) {
+	aux := new(halfBootParametersJSON)
+	if err = json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	*hb = HalfBootParameters{
+		ResidualModuli:        aux.ResidualModuli,
+		KeySwitchModuli:       aux.KeySwitchModuli,
+		SineEvalModuli:        aux.SineEvalModuli,
+		DiffScaleModulus:      aux.DiffScaleModulus,
+		CoeffsToSlotsModuli:   aux.CoeffsToSlotsModuli,
+		LogN:                  aux.LogN,
+		LogSlots:              aux.LogSlots,
+		PlainModulus:          aux.PlainModulus,
+		Scale:                 aux.Scale,
+		Sigma:                 aux.Sigma,
+		H:                     aux.H,
+		SinType:               aux.SinType,
+		MessageRatio:          aux.MessageRatio,
+		SinRange:              aux.SinRange,
+		SinDeg:                aux.SinDeg,
+		SinRescal:             aux.SinRescal,
+		ArcSineDeg:            aux.ArcSineDeg,
+		MaxN1N2Ratio:          aux.MaxN1N2Ratio,
+		EphemeralSecretWeight: aux.EphemeralSecretWeight,
+	}
+
+	return hb.Validate()
+}
+
 // DiffScaleModulus is used to set scale after the SineEval step.
 type DiffScaleModulus []uint64
 
@@ -34,18 +34,30 @@ type HalfBootstrapper struct {
 	pDFTInvWithoutRepack   []*PtDiagMatrix // Matrice vectors
 
 	rotKeyIndex []int // a list of the required rotation keys
+
+	swkDtS *SwitchingKey // Switching key from the secret key to the ephemeral sparse secret key
+	swkStD *SwitchingKey // Switching key from the ephemeral sparse secret key to the secret key
 }
 
 // NewHalfBootstrapper creates a new HalfBootstrapper.
 func NewHalfBootstrapper(params *Parameters, hbtpParams *HalfBootParameters, btpKey BootstrappingKey) (hbtp *HalfBootstrapper, err error) {
+	return NewHalfBootstrapperWithMatrices(params, hbtpParams, btpKey, nil)
+}
+
+// NewHalfBootstrapperWithMatrices creates a new HalfBootstrapper from precomputed CoeffsToSlots matrices
+// (see HalfBootstrapper.CoeffsToSlotsMatrices and UnmarshalCoeffsToSlotsMatrices), which saves their
+// generation. The matrices are generated if pDFTInv is nil.
+func NewHalfBootstrapperWithMatrices(params *Parameters, hbtpParams *HalfBootParameters, btpKey BootstrappingKey, pDFTInv []*PtDiagMatrix) (hbtp *HalfBootstrapper, err error) {
 
 	if hbtpParams.SinType == SinType(Sin) && hbtpParams.SinRescal != 0 {
 		return nil, fmt.Errorf("cannot use double angle formul for SinType = Sin -> must use SinType = Cos")
 	}
 
-	hbtp = newHalfBootstrapper(params, hbtpParams)
+	if hbtp, err = newHalfBootstrapper(params, hbtpParams, pDFTInv); err != nil {
+		return nil, err
+	}
 
-	hbtp.BootstrappingKey = &BootstrappingKey{btpKey.Rlk, btpKey.Rtks}
+	hbtp.BootstrappingKey = &BootstrappingKey{Rlk: btpKey.Rlk, Rtks: btpKey.Rtks}
 	if err = hbtp.CheckKeys(); err != nil {
 		return nil, fmt.Errorf("invalid bootstrapping key: %w", err)
 	}
@@ -56,7 +68,8 @@ func NewHalfBootstrapper(params *Parameters, hbtpParams *HalfBootParameters, btp
 
 // newHalfBootstrapper is a constructor of "dummy" half-bootstrapper to enable the generation of bootstrapping-related constants
 // without providing a bootstrapping key. To be replaced by a propper factorization of the bootstrapping pre-computations.
-func newHalfBootstrapper(params *Parameters, hbtpParams *HalfBootParameters) (hbtp *HalfBootstrapper) {
+// The CoeffsToSlots matrices are generated if pDFTInv is nil.
+func newHalfBootstrapper(params *Parameters, hbtpParams *HalfBootParameters, pDFTInv []*PtDiagMatrix) (hbtp *HalfBootstrapper, err error) {
 	hbtp = new(HalfBootstrapper)
 
 	hbtp.params = params.Copy()
@@ -77,14 +90,65 @@ func newHalfBootstrapper(params *Parameters, hbtpParams *HalfBootParameters) (hb
 	hbtp.ckksEvaluator = NewCKKSEvaluator(params, EvaluationKey{}).(*ckksEvaluator) // creates an evaluator without keys for genDFTMatrices
 
 	hbtp.genSinePoly()
-	hbtp.genDFTMatrices()
+	if err = hbtp.genDFTMatrices(pDFTInv); err != nil {
+		return nil, err
+	}
 
 	hbtp.ctxpool = NewCiphertextCKKS(params, 1, params.MaxLevel(), 0)
 
-	return hbtp
+	return hbtp, nil
+}
+
+// CoeffsToSlotsMatrices returns the CoeffsToSlots matrices of the HalfBootstrapper, which can be stored
+// with MarshalCoeffsToSlotsMatrices and given back to NewHalfBootstrapperWithMatrices.
+func (hbtp *HalfBootstrapper) CoeffsToSlotsMatrices() []*PtDiagMatrix {
+	return hbtp.pDFTInvWithoutRepack
+}
+
+// checkCoeffsToSlotsMatrices checks that precomputed CoeffsToSlots matrices match the HalfBootParameters.
+func (hbtp *HalfBootstrapper) checkCoeffsToSlotsMatrices(pDFTInv []*PtDiagMatrix) error {
+	ctsLevels := hbtp.CtSLevels()
+	if len(pDFTInv) != len(ctsLevels) {
+		return fmt.Errorf("%d CoeffsToSlots matrices are expected but %d given", len(ctsLevels), len(pDFTInv))
+	}
+
+	cnt := 0
+	for i := range hbtp.CoeffsToSlotsModuli.ScalingFactor {
+		for _, scale := range hbtp.CoeffsToSlotsModuli.ScalingFactor[hbtp.CtSDepth(true)-i-1] {
+			matrix := pDFTInv[cnt]
+			if matrix == nil || matrix.Level != ctsLevels[cnt] || matrix.Scale != scale || matrix.LogSlots != hbtp.logdslots {
+				return fmt.Errorf("CoeffsToSlots matrix %d does not match the parameters", cnt)
+			}
+
+			for _, diag := range matrix.Vec {
+				if diag[0].Degree() != hbtp.params.N() || diag[0].LenModuli() <= matrix.Level || diag[1].LenModuli() != hbtp.params.PiCount() {
+					return fmt.Errorf("CoeffsToSlots matrix %d does not match the parameters", cnt)
+				}
+			}
+			cnt++
+		}
+	}
+
+	return nil
+}
+
+// SetEncapsulationKeys sets the switching keys of the sparse-secret encapsulation (see HalfBootParameters.EphemeralSecretWeight),
+// generated by KeyGenerator.GenEncapsulationSwitchingKeys. They must be set before HalfBoot if EphemeralSecretWeight is non-zero.
+func (hbtp *HalfBootstrapper) SetEncapsulationKeys(swkDtS, swkStD *SwitchingKey) error {
+	if hbtp.EphemeralSecretWeight == 0 {
+		return fmt.Errorf("cannot SetEncapsulationKeys: EphemeralSecretWeight is zero")
+	}
+
+	if swkDtS == nil || swkStD == nil {
+		return fmt.Errorf("cannot SetEncapsulationKeys: switching keys are nil")
+	}
+
+	hbtp.swkDtS, hbtp.swkStD = swkDtS, swkStD
+	return nil
 }
 
-// CheckKeys checks if all the necessary keys are present
+// CheckKeys checks if all the necessary keys are present. The switching keys of the sparse-secret encapsulation
+// are given separately by SetEncapsulationKeys.
 func (hbtp *HalfBootstrapper) CheckKeys() (err error) {
 
 	if hbtp.Rlk == nil {
@@ -110,7 +174,7 @@ func (hbtp *HalfBootstrapper) CheckKeys() (err error) {
 	return nil
 }
 
-func (hbtp *HalfBootstrapper) genDFTMatrices() {
+func (hbtp *HalfBootstrapper) genDFTMatrices(pDFTInv []*PtDiagMatrix) (err error) {
 
 	a := real(hbtp.sineEvalPoly.a)
 	b := real(hbtp.sineEvalPoly.b)
@@ -124,7 +188,14 @@ func (hbtp *HalfBootstrapper) genDFTMatrices() {
 	hbtp.diffScaleAfterSineEval = (qDiff * hbtp.params.scale) / hbtp.postscale
 
 	// CoeffsToSlotsWithoutRepack vectors
-	hbtp.pDFTInvWithoutRepack = hbtp.HalfBootParameters.GenCoeffsToSlotsMatrixWithoutRepack(hbtp.coeffsToSlotsDiffScale, hbtp.encoder)
+	if pDFTInv != nil {
+		if err = hbtp.checkCoeffsToSlotsMatrices(pDFTInv); err != nil {
+			return err
+		}
+		hbtp.pDFTInvWithoutRepack = pDFTInv
+	} else {
+		hbtp.pDFTInvWithoutRepack = hbtp.HalfBootParameters.GenCoeffsToSlotsMatrixWithoutRepack(hbtp.coeffsToSlotsDiffScale, hbtp.encoder)
+	}
 
 	// List of the rotation key values to needed for the bootstrapp
 	hbtp.rotKeyIndex = []int{}
@@ -140,6 +211,8 @@ func (hbtp *HalfBootstrapper) genDFTMatrices() {
 	for _, pVec := range hbtp.pDFTInvWithoutRepack {
 		hbtp.rotKeyIndex = AddMatrixRotToList(pVec, hbtp.rotKeyIndex, hbtp.params.Slots(), false)
 	}
+
+	return nil
 }
 
 func (hbtp *HalfBootstrapper) genSinePoly() {
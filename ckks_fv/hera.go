@@ -0,0 +1,212 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"math/bits"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Hera is an interface for the client-side (plaintext) HERA stream cipher.
+// The keystream it produces is bit-identical to the one evaluated homomorphically by MFVHera.
+type Hera interface {
+	Crypt(nonce []byte) (keystream []uint64)
+	KeyStream(nonces [][]byte) (keystream [][]uint64)
+	Blocksize() int
+	NumRound() int
+	PlainModulus() uint64
+}
+
+type plainHera struct {
+	blocksize    int
+	numRound     int
+	plainModulus uint64
+	key          []uint64
+	mat          []uint64 // first row of the circulant mixing matrix
+
+	state []uint64
+	buf   []uint64
+	rks   [][]uint64 // RoundKeys[round][state]
+}
+
+// NewHera creates a new client-side HERA cipher with numRound rounds over Z_plainModulus.
+// The key should consist of 16 elements of Z_plainModulus.
+func NewHera(numRound int, key []uint64, plainModulus uint64) Hera {
+	return NewHeraWithParam(HeraParam{Blocksize: 16, NumRound: numRound}, key, plainModulus)
+}
+
+// NewHeraWithParam creates a new client-side HERA cipher with the given state size and number of rounds over Z_plainModulus.
+// The key should consist of heraParam.Blocksize elements of Z_plainModulus, and gcd(3, plainModulus-1) should be 1
+// so that the cube is invertible.
+func NewHeraWithParam(heraParam HeraParam, key []uint64, plainModulus uint64) Hera {
+	if err := heraParam.Validate(); err != nil {
+		panic(fmt.Sprintf("cannot NewHera: %v", err))
+	}
+	if err := validateCubeModulus(plainModulus); err != nil {
+		panic(fmt.Sprintf("cannot NewHera: %v", err))
+	}
+
+	blocksize := heraParam.Blocksize
+	if len(key) != blocksize {
+		panic(fmt.Sprintf("cannot NewHera: key should have %d elements but %d given", blocksize, len(key)))
+	}
+
+	hera := new(plainHera)
+	hera.blocksize = blocksize
+	hera.numRound = heraParam.NumRound
+	hera.plainModulus = plainModulus
+	hera.mat = mixingMatrixRow(blocksize)
+
+	hera.key = make([]uint64, blocksize)
+	for i := 0; i < blocksize; i++ {
+		if key[i] >= plainModulus {
+			panic("cannot NewHera: key elements should be smaller than plainModulus")
+		}
+		hera.key[i] = key[i]
+	}
+
+	hera.state = make([]uint64, blocksize)
+	hera.buf = make([]uint64, blocksize)
+	hera.rks = make([][]uint64, hera.numRound+1)
+	for r := 0; r <= hera.numRound; r++ {
+		hera.rks[r] = make([]uint64, blocksize)
+	}
+	return hera
+}
+
+// Blocksize returns the size of the state, i.e. the number of keystream elements produced per nonce.
+func (hera *plainHera) Blocksize() int {
+	return hera.blocksize
+}
+
+// NumRound returns the number of rounds of the cipher.
+func (hera *plainHera) NumRound() int {
+	return hera.numRound
+}
+
+// PlainModulus returns the modulus of the keystream elements.
+func (hera *plainHera) PlainModulus() uint64 {
+	return hera.plainModulus
+}
+
+// KeyStream returns one keystream block of Blocksize elements per nonce.
+func (hera *plainHera) KeyStream(nonces [][]byte) (keystream [][]uint64) {
+	keystream = make([][]uint64, len(nonces))
+	for i := range nonces {
+		keystream[i] = hera.Crypt(nonces[i])
+	}
+	return
+}
+
+// Crypt returns the keystream block of Blocksize elements for the given nonce.
+func (hera *plainHera) Crypt(nonce []byte) (keystream []uint64) {
+	t := hera.plainModulus
+	state := hera.state
+
+	hera.init(nonce)
+
+	// Initial AddRoundKey
+	for st := 0; st < hera.blocksize; st++ {
+		state[st] = (uint64(st+1)%t + hera.rks[0][st]) % t // ic = 1, ..., blocksize
+	}
+
+	// Round Functions
+	for r := 1; r < hera.numRound; r++ {
+		hera.linLayer()
+		hera.cube()
+		hera.addRoundKey(r)
+	}
+
+	// Finalization
+	hera.linLayer()
+	hera.cube()
+	hera.linLayer()
+	hera.addRoundKey(hera.numRound)
+
+	keystream = make([]uint64, hera.blocksize)
+	copy(keystream, state)
+	return
+}
+
+// Compute Round Keys
+func (hera *plainHera) init(nonce []byte) {
+	t := hera.plainModulus
+	xof := sha3.NewShake256()
+	xof.Write(nonce)
+
+	for r := 0; r <= hera.numRound; r++ {
+		for st := 0; st < hera.blocksize; st++ {
+			hera.rks[r][st] = mulMod(SampleZqx(xof, t), hera.key[st], t)
+		}
+	}
+}
+
+func (hera *plainHera) addRoundKey(round int) {
+	for st := 0; st < hera.blocksize; st++ {
+		hera.state[st] = (hera.state[st] + hera.rks[round][st]) % hera.plainModulus
+	}
+}
+
+// linLayer applies MixColumns then MixRows, using the circulant matrix given by mixingMatrixRow.
+func (hera *plainHera) linLayer() {
+	mat := hera.mat
+	t := hera.plainModulus
+	state := hera.state
+	buf := hera.buf
+	n := len(mat)
+
+	// MixColumns
+	for row := 0; row < n; row++ {
+		for col := 0; col < n; col++ {
+			var sum uint64
+			for k := 0; k < n; k++ {
+				sum = (sum + mulMod(mat[k], state[((row+k)%n)*n+col], t)) % t
+			}
+			buf[row*n+col] = sum
+		}
+	}
+
+	// MixRows
+	for row := 0; row < n; row++ {
+		for col := 0; col < n; col++ {
+			var sum uint64
+			for k := 0; k < n; k++ {
+				sum = (sum + mulMod(mat[k], buf[row*n+(col+k)%n], t)) % t
+			}
+			state[row*n+col] = sum
+		}
+	}
+}
+
+func (hera *plainHera) cube() {
+	t := hera.plainModulus
+	for st := 0; st < hera.blocksize; st++ {
+		hera.state[st] = mulMod(mulMod(hera.state[st], hera.state[st], t), hera.state[st], t)
+	}
+}
+
+// mixingMatrixRow returns the first row of the circulant matrix of the linear layers of HERA and Rubato
+// for a state of the given size, i.e. (2, 3, 1, 1), (4, 2, 4, 3, 1, 1) or (5, 3, 4, 3, 6, 2, 1, 1),
+// and nil if the size is not supported. These are the matrices of the specification of Rubato
+// (Ha et al., "Rubato: Noisy Ciphers for Approximate Homomorphic Encryption", Eurocrypt 2022), the first
+// one being also the matrix of HERA. Whether they are MDS depends on the plaintext modulus, which is
+// checked by the tests for the moduli of RtFHeraParams and RubatoParams.
+func mixingMatrixRow(blocksize int) []uint64 {
+	switch blocksize {
+	case 16:
+		return []uint64{2, 3, 1, 1}
+	case 36:
+		return []uint64{4, 2, 4, 3, 1, 1}
+	case 64:
+		return []uint64{5, 3, 4, 3, 6, 2, 1, 1}
+	default:
+		return nil
+	}
+}
+
+// mulMod returns a*b mod t, for a and b smaller than t.
+func mulMod(a, b, t uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	_, r := bits.Div64(hi, lo, t)
+	return r
+}
@@ -0,0 +1,272 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/ring"
+)
+
+func TestHera(t *testing.T) {
+	t.Run("Hera/NewHera/InvalidInputs/", func(t *testing.T) {
+		assert.Panics(t, func() { NewHera(0, make([]uint64, 16), 65537) })
+		assert.Panics(t, func() { NewHera(4, make([]uint64, 15), 65537) })
+		key := make([]uint64, 16)
+		key[3] = 65537
+		assert.Panics(t, func() { NewHera(4, key, 65537) })
+		assert.Panics(t, func() { NewHeraWithParam(HeraParam{Blocksize: 25, NumRound: 4}, make([]uint64, 25), 65537) })
+		assert.Panics(t, func() { NewHeraWithParam(HeraParam{Blocksize: 36, NumRound: 4}, make([]uint64, 16), 65537) })
+		assert.Panics(t, func() { NewMFVHeraWithParam(HeraParam{Blocksize: 36, NumRound: 0}, nil, nil, nil, nil, 0) })
+
+		// The cube is not invertible modulo 7681 = 3*2560 + 1
+		assert.Panics(t, func() { NewHera(4, make([]uint64, 16), 7681) })
+		assert.Panics(t, func() { NewHera(4, make([]uint64, 16), 1<<63+1) })
+	})
+
+	t.Run("Hera/MixingMatrix/MDS/", func(t *testing.T) {
+		moduli := []uint64{testHeraLargePlainModulus}
+		for _, hbtpParams := range RtFHeraParams {
+			moduli = append(moduli, hbtpParams.PlainModulus)
+		}
+		for _, rubatoParam := range RubatoParams {
+			moduli = append(moduli, rubatoParam.PlainModulus)
+		}
+		for _, blocksize := range []int{16, 36, 64} {
+			for _, plainModulus := range moduli {
+				require.True(t, testIsCirculantMDS(mixingMatrixRow(blocksize), plainModulus), "blocksize %d, plainModulus %d", blocksize, plainModulus)
+			}
+		}
+	})
+
+	t.Run("Hera/Crypt/Blocksize/", func(t *testing.T) {
+		key := newTestKey(16, 268042241)
+		nonces := newTestNonces(1)
+		hera := NewHeraWithParam(HeraParam{Blocksize: 16, NumRound: 5}, key, 268042241)
+		require.Equal(t, 16, hera.Blocksize())
+		assert.Equal(t, NewHera(5, key, 268042241).Crypt(nonces[0]), hera.Crypt(nonces[0]))
+
+		// Large plaintext moduli do not overflow
+		plainModulus := testHeraLargePlainModulus
+		for _, blocksize := range []int{36, 64} {
+			hera := NewHeraWithParam(HeraParam{Blocksize: blocksize, NumRound: 4}, newTestKey(blocksize, plainModulus), plainModulus)
+			ks := hera.Crypt(nonces[0])
+			require.Len(t, ks, blocksize)
+			for _, k := range ks {
+				require.Less(t, k, plainModulus)
+			}
+		}
+	})
+
+	t.Run("Hera/Crypt/Deterministic/", func(t *testing.T) {
+		key := newTestKey(16, 268042241)
+		nonces := newTestNonces(2)
+		hera := NewHera(5, key, 268042241)
+		ks0 := hera.Crypt(nonces[0])
+		ks1 := hera.Crypt(nonces[1])
+		assert.Equal(t, ks0, hera.Crypt(nonces[0]))
+		assert.NotEqual(t, ks0, ks1)
+		assert.Equal(t, [][]uint64{ks0, ks1}, hera.KeyStream(nonces))
+	})
+
+	t.Run("Hera/Crypt/KnownAnswer/", func(t *testing.T) {
+		key := newKnownAnswerKey(16, 268042241)
+		nonces := [][]byte{newKnownAnswerNonce(0), newKnownAnswerNonce(64)}
+		for _, numRound := range []int{4, 5} {
+			assert.Equal(t, heraKnownAnswers[numRound], NewHera(numRound, key, 268042241).KeyStream(nonces), "NumRound %d", numRound)
+		}
+	})
+
+	numRounds := []int{4, 5}
+	if testing.Short() {
+		numRounds = []int{4}
+	}
+
+	for paramIndex := range RtFHeraParams {
+		for _, numRound := range numRounds {
+			testHeraAgainstMFVHera(t, paramIndex, numRound)
+		}
+	}
+
+	testHeraParamAgainstMFVHera(t, HeraParam{Blocksize: 36, NumRound: 4}, 40)
+}
+
+// testHeraLargePlainModulus is a 61-bit prime which is 2 mod 3.
+const testHeraLargePlainModulus = uint64(0x1fffffffffffffd3)
+
+// testIsCirculantMDS returns true if every square submatrix of the circulant matrix of first row row is invertible
+// modulo the prime plainModulus.
+func testIsCirculantMDS(row []uint64, plainModulus uint64) bool {
+	n := len(row)
+	for rows := 1; rows < 1<<n; rows++ {
+		for cols := 1; cols < 1<<n; cols++ {
+			if bits.OnesCount(uint(rows)) != bits.OnesCount(uint(cols)) {
+				continue
+			}
+			var sub [][]uint64
+			for i := 0; i < n; i++ {
+				if rows>>i&1 == 0 {
+					continue
+				}
+				var subRow []uint64
+				for j := 0; j < n; j++ {
+					if cols>>j&1 == 1 {
+						subRow = append(subRow, row[(j-i+n)%n])
+					}
+				}
+				sub = append(sub, subRow)
+			}
+			if !testIsInvertible(sub, plainModulus) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// testIsInvertible returns true if the square matrix m is invertible modulo the prime t, by Gaussian elimination.
+func testIsInvertible(m [][]uint64, t uint64) bool {
+	n := len(m)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && m[pivot][col]%t == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+
+		inv := new(big.Int).ModInverse(new(big.Int).SetUint64(m[col][col]%t), new(big.Int).SetUint64(t)).Uint64()
+		for r := col + 1; r < n; r++ {
+			f := mulMod(m[r][col]%t, inv, t)
+			for k := col; k < n; k++ {
+				m[r][k] = (m[r][k]%t + t - mulMod(f, m[col][k]%t, t)) % t
+			}
+		}
+	}
+	return true
+}
+
+// testHeraParamAgainstMFVHera checks that the keystream of a HERA variant evaluated homomorphically
+// with a logT-bit plaintext modulus decrypts exactly to the keystream of the client-side Hera.
+func testHeraParamAgainstMFVHera(t *testing.T, heraParam HeraParam, logT int) {
+	hbtpParams := genTestHalfBootParams(RtFHeraParams[1])
+	// The plaintext modulus is the first NTT-friendly logT-bit prime for which the cube is invertible
+	for _, t := range ring.GenerateNTTPrimes(logT, 2<<hbtpParams.LogN, 8) {
+		if validateCubeModulus(t) == nil {
+			hbtpParams.PlainModulus = t
+			break
+		}
+	}
+	params, _ := genTestParams(hbtpParams)
+	nbInitModDown := 8
+
+	t.Run(testString(fmt.Sprintf("Hera/MFVHera/Blocksize=%d/NumRound=%d/", heraParam.Blocksize, heraParam.NumRound), params), func(t *testing.T) {
+		kgen := NewKeyGenerator(params)
+		sk, pk := kgen.GenKeyPairSparse(hbtpParams.H)
+		rlk := kgen.GenRelinearizationKey(sk)
+
+		fvEncoder := NewMFVEncoder(params)
+		fvEncryptor := NewMFVEncryptorFromPk(params, pk)
+		fvDecryptor := NewMFVDecryptor(params, sk)
+		fvEvaluator := NewMFVEvaluator(params, EvaluationKey{Rlk: rlk}, nil)
+		fvNoiseEstimator := NewMFVNoiseEstimator(params, sk)
+
+		key := newTestKey(heraParam.Blocksize, params.PlainModulus())
+		nonces := newTestNonces(params.FVSlots())
+		keystream := NewHeraWithParam(heraParam, key, params.PlainModulus()).KeyStream(nonces)
+
+		verify := func(fvKeystreams []*Ciphertext) {
+			require.Len(t, fvKeystreams, heraParam.Blocksize)
+			for s := range fvKeystreams {
+				have := fvEncoder.DecodeUintSmallNew(fvDecryptor.DecryptNew(fvKeystreams[s]))
+				for i := 0; i < params.FVSlots(); i++ {
+					require.Equal(t, keystream[i][s], have[i], "state %d, slot %d", s, i)
+				}
+			}
+		}
+
+		mfvHera := NewMFVHeraWithParam(heraParam, params, fvEncoder, fvEncryptor, fvEvaluator, nbInitModDown)
+		kCt := mfvHera.EncKey(key)
+		fvKeystreams, heraModDown := mfvHera.CryptAutoModSwitch(nonces, kCt, fvNoiseEstimator)
+		verify(fvKeystreams)
+
+		mfvHera.Reset(nbInitModDown)
+		verify(mfvHera.Crypt(nonces, kCt, heraModDown))
+	})
+}
+
+// testHeraAgainstMFVHera checks that the keystream evaluated homomorphically by MFVHera
+// decrypts exactly to the keystream of the client-side Hera, slot by slot.
+func testHeraAgainstMFVHera(t *testing.T, paramIndex, numRound int) {
+	hbtpParams := genTestHalfBootParams(RtFHeraParams[paramIndex])
+	params, _ := genTestParams(hbtpParams)
+
+	var heraModDown []int
+	if numRound == 4 {
+		heraModDown = HeraModDownParams80[paramIndex].CipherModDown
+	} else {
+		heraModDown = HeraModDownParams128[paramIndex].CipherModDown
+	}
+
+	t.Run(testString(fmt.Sprintf("Hera/MFVHera/Param=%d/NumRound=%d/", paramIndex, numRound), params), func(t *testing.T) {
+		kgen := NewKeyGenerator(params)
+		sk, pk := kgen.GenKeyPairSparse(hbtpParams.H)
+		rlk := kgen.GenRelinearizationKey(sk)
+
+		fvEncoder := NewMFVEncoder(params)
+		fvEncryptor := NewMFVEncryptorFromPk(params, pk)
+		fvDecryptor := NewMFVDecryptor(params, sk)
+		fvEvaluator := NewMFVEvaluator(params, EvaluationKey{Rlk: rlk}, nil)
+
+		key := newTestKey(16, params.PlainModulus())
+		nonces := newTestNonces(params.FVSlots())
+
+		mfvHera := NewMFVHera(numRound, params, fvEncoder, fvEncryptor, fvEvaluator, heraModDown[0])
+		kCt := mfvHera.EncKey(key)
+		fvKeystreams := mfvHera.Crypt(nonces, kCt, heraModDown)
+		require.Len(t, fvKeystreams, 16)
+
+		hera := NewHera(numRound, key, params.PlainModulus())
+		require.Equal(t, numRound, hera.NumRound())
+		require.Equal(t, params.PlainModulus(), hera.PlainModulus())
+		keystream := hera.KeyStream(nonces)
+
+		for s := 0; s < 16; s++ {
+			have := fvEncoder.DecodeUintSmallNew(fvDecryptor.DecryptNew(fvKeystreams[s]))
+			for i := 0; i < params.FVSlots(); i++ {
+				require.Equal(t, keystream[i][s], have[i], "state %d, slot %d", s, i)
+			}
+		}
+	})
+}
+
+// heraKnownAnswers are the keystreams of the original implementation of HERA, indexed by the number of rounds,
+// for the key newKnownAnswerKey(16, 268042241) and the nonces newKnownAnswerNonce(0) and newKnownAnswerNonce(64).
+var heraKnownAnswers = map[int][][]uint64{
+	4: {
+		{
+			0x1bcf7b0, 0x3a3136b, 0xda0bb78, 0xfb89dd0, 0xbdcb74d, 0xb9dad81, 0x31ae04e, 0x4a7d590,
+			0xd919e95, 0x3342c2, 0x31f324c, 0x9028072, 0xbc3023c, 0x1266098, 0x301655a, 0xb31dafe,
+		},
+		{
+			0xdc6422f, 0x1387c7d, 0x313f839, 0x15d6bf7, 0xe338cad, 0xb79498f, 0xe634030, 0x660570f,
+			0x77e4c0, 0x13232e3, 0x8d559ce, 0x30f1ea1, 0x4cc8826, 0x304a7b5, 0xb15193, 0xd03a6f7,
+		},
+	},
+	5: {
+		{
+			0x6c8091f, 0x24dbdf6, 0x70ae2cd, 0xeedbc41, 0x89ea276, 0xbf96cac, 0x9e47d6c, 0xec16a4b,
+			0x65334a2, 0x18c316d, 0xe40c960, 0xa8ee722, 0x26645ff, 0xd8d8adc, 0x4977df5, 0x24d353d,
+		},
+		{
+			0xa620516, 0x715dddf, 0x24feb09, 0xc754f8e, 0xe2dda90, 0xf7f67cb, 0xc835fb1, 0x8947e31,
+			0xb749ac5, 0xe09e075, 0x9f7844e, 0xf613d3f, 0xdde2da3, 0x89ac1bb, 0xa3c1c1d, 0x62c2fd9,
+		},
+	},
+}
@@ -21,6 +21,8 @@ type KeyGenerator interface {
 	GenSwitchingKey(skInput, skOutput *SecretKey) (newevakey *SwitchingKey)
 	GenRelinearizationKey(sk *SecretKey) (evakey *RelinearizationKey)
 	GenSwitchingKeyForGalois(galEl uint64, sk *SecretKey) (swk *SwitchingKey)
+	GenKeyPairForHalfBoot(hbtpParams *HalfBootParameters) (sk *SecretKey, pk *PublicKey)
+	GenEncapsulationSwitchingKeys(hbtpParams *HalfBootParameters, sk *SecretKey) (swkDtS, swkStD *SwitchingKey)
 
 	GenRotationKeys(galEls []uint64, sk *SecretKey) (rks *RotationKeySet)
 
@@ -35,6 +37,8 @@ type KeyGenerator interface {
 
 	GenRotationIndexesForDiagMatrix(matrix *PtDiagMatrix) []int
 	GenRotationIndexesForSlotsToCoeffsMat(matrix [][]*PtDiagMatrixT) []int
+	GenRotationIndexesForTranscipher(tcParams *TranscipherParameters) []int
+	GenRotationIndexesForSchemeSwitch(ssParams *SchemeSwitchParameters) []int
 }
 
 // KeyGenerator is a structure that stores the elements required to create new keys,
@@ -183,6 +187,48 @@ func (keygen *keyGenerator) GenSwitchingKey(skInput, skOutput *SecretKey) (newev
 	return
 }
 
+// GenKeyPairForHalfBoot generates a new key pair for the half-bootstrapping with the given HalfBootParameters:
+// the SecretKey has exactly H non-zero coefficients, or the distribution [1/3, 1/3, 1/3] if H is zero.
+func (keygen *keyGenerator) GenKeyPairForHalfBoot(hbtpParams *HalfBootParameters) (sk *SecretKey, pk *PublicKey) {
+	if hbtpParams.H == 0 {
+		return keygen.GenKeyPair()
+	}
+	return keygen.GenKeyPairSparse(hbtpParams.H)
+}
+
+// GenEncapsulationSwitchingKeys generates the switching keys of the sparse-secret encapsulation of the
+// half-bootstrapping (see HalfBootParameters.EphemeralSecretWeight): swkDtS switches from sk to an ephemeral
+// secret key with exactly EphemeralSecretWeight non-zero coefficients, and swkStD switches back to sk.
+// The ephemeral secret key is discarded. As swkDtS is only used at level 0, its moduli above Q_0 are set
+// to zero, so that it only reveals an RLWE sample under the sparse ephemeral secret key modulo Q_0*P.
+func (keygen *keyGenerator) GenEncapsulationSwitchingKeys(hbtpParams *HalfBootParameters, sk *SecretKey) (swkDtS, swkStD *SwitchingKey) {
+	if hbtpParams.EphemeralSecretWeight == 0 {
+		panic("cannot GenEncapsulationSwitchingKeys: EphemeralSecretWeight is zero")
+	}
+
+	skSparse := keygen.GenSecretKeySparse(hbtpParams.EphemeralSecretWeight)
+
+	swkDtS = keygen.GenSwitchingKey(sk, skSparse)
+	swkStD = keygen.GenSwitchingKey(skSparse, sk)
+
+	for i := range swkDtS.Value {
+		for _, pol := range swkDtS.Value[i] {
+			if i != 0 {
+				pol.Zero()
+				continue
+			}
+			for j := 1; j < keygen.params.QiCount(); j++ {
+				for k := range pol.Coeffs[j] {
+					pol.Coeffs[j][k] = 0
+				}
+			}
+		}
+	}
+
+	skSparse.Value.Zero()
+	return
+}
+
 func (keygen *keyGenerator) GenSwitchingKeyForGalois(galoisEl uint64, sk *SecretKey) (swk *SwitchingKey) {
 	swk = NewSwitchingKey(keygen.params)
 	keygen.genrotKey(sk.Value, keygen.params.InverseGaloisElement(galoisEl), &swk.SwitchingKey)
@@ -416,6 +462,47 @@ func (keygen *keyGenerator) GenRotationIndexesForSlotsToCoeffsMat(pDcd [][]*PtDi
 	return rotKeyIndex
 }
 
+// GenRotationIndexesForTranscipher generates the rotation indexes for the Transcipherer, i.e. for the
+// SlotsToCoeffs of the keystream and for the half-bootstrapping. The keys should be generated with the
+// conjugation key. The KeyGenerator should be created from tcParams.Params().
+func (keygen *keyGenerator) GenRotationIndexesForTranscipher(tcParams *TranscipherParameters) (rotations []int) {
+	params := keygen.params
+
+	rotations = keygen.GenRotationIndexesForHalfBoot(params.LogSlots(), &tcParams.HalfBootParameters)
+
+	pDcds := tcParams.genSlotToCoeffMat(NewMFVEncoder(params))
+	for _, i := range keygen.GenRotationIndexesForSlotsToCoeffsMat(pDcds) {
+		if !utils.IsInSliceInt(i, rotations) {
+			rotations = append(rotations, i)
+		}
+	}
+
+	if repack := halfBootRepackRotation(params); !tcParams.FullCoeffs() && !utils.IsInSliceInt(repack, rotations) {
+		rotations = append(rotations, repack)
+	}
+	return
+}
+
+// GenRotationIndexesForSchemeSwitch generates the rotation indexes for the SchemeSwitcher, i.e. for the
+// SlotsToCoeffs of the CKKS to FV scheme switching. The keys should be generated with the conjugation key.
+func (keygen *keyGenerator) GenRotationIndexesForSchemeSwitch(ssParams *SchemeSwitchParameters) (rotations []int) {
+	params := keygen.params
+	slots := params.Slots()
+	dslots := 1 << schemeSwitchLogdSlots(params)
+
+	rotations = []int{}
+	for i, pVec := range genSchemeSwitchDiagMatrices(params, ssParams) {
+		index := make(map[int]bool, len(pVec))
+		for j := range pVec {
+			index[j] = true
+		}
+		N1 := findbestbabygiantstepsplit(pVec, dslots, ssParams.MaxN1N2Ratio)
+		rotations = addMatrixRotToList(index, rotations, N1, slots, dslots != slots && i == 0)
+	}
+
+	return
+}
+
 func addMatrixRotToList(pVec map[int]bool, rotations []int, N1, slots int, repack bool) []int {
 
 	if len(pVec) < 3 {
@@ -24,7 +24,7 @@ type EvaluationKey struct {
 	Rtks *RotationKeySet
 }
 
-// BootstrappingKey is a type for a CKKS bootstrapping key, wich regroups the necessary public relinearization
+// BootstrappingKey is a type for a CKKS bootstrapping key, which regroups the necessary public relinearization
 // and rotation keys (i.e., an EvaluationKey).
 type BootstrappingKey EvaluationKey
 
@@ -0,0 +1,897 @@
+package ckks_fv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/ldsec/lattigo/v2/ring"
+)
+
+// decodePoly decodes a ring.Poly written with ring.Poly.WriteTo at the beginning of data and returns the
+// number of bytes read. Contrary to ring.Poly.DecodePolyNew, it checks that data is large enough.
+func decodePoly(data []byte) (pol *ring.Poly, pointer int, err error) {
+	if len(data) < 2 || data[0] > MaxLogN || len(data) < 2+(int(data[1])<<(data[0]+3)) {
+		return nil, 0, errors.New("too small bytearray")
+	}
+
+	pol = new(ring.Poly)
+	if pointer, err = pol.DecodePolyNew(data); err != nil {
+		return nil, 0, err
+	}
+
+	return pol, pointer, nil
+}
+
+// ElementVersion is the version of the binary encoding of Element, stored in the first byte of its header.
+const ElementVersion = 1
+
+// elementMetaDataLen is the length in bytes of the header of an Element, cf. Element.GetDataLen.
+const elementMetaDataLen = 12
+
+// GetDataLen returns the length in bytes of the target Element.
+func (el *Element) GetDataLen(WithMetaData bool) (dataLen int) {
+	// MetaData is :
+	// 1 byte : Version
+	// 1 byte : Degree
+	// 8 byte : Scale
+	// 1 byte : isCKKS
+	// 1 byte : isNTT
+	if WithMetaData {
+		dataLen += elementMetaDataLen
+	}
+
+	for _, pol := range el.value {
+		dataLen += pol.GetDataLen(WithMetaData)
+	}
+
+	return dataLen
+}
+
+// MarshalBinary encodes an Element on a byte slice. The level is given by the number of moduli
+// of the polynomials.
+func (el *Element) MarshalBinary() (data []byte, err error) {
+
+	if len(el.value) == 0 || len(el.value) > 0xFF {
+		return nil, errors.New("cannot MarshalBinary: invalid element degree")
+	}
+
+	data = make([]byte, el.GetDataLen(true))
+
+	data[0] = ElementVersion
+
+	data[1] = uint8(el.Degree() + 1)
+
+	binary.LittleEndian.PutUint64(data[2:10], math.Float64bits(el.scale))
+
+	if el.isCKKS {
+		data[10] = 1
+	}
+
+	if el.isNTT {
+		data[11] = 1
+	}
+
+	var pointer, inc int
+
+	pointer = elementMetaDataLen
+
+	for _, pol := range el.value {
+
+		if inc, err = pol.WriteTo(data[pointer:]); err != nil {
+			return nil, err
+		}
+
+		pointer += inc
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled Element on the target Element.
+func (el *Element) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < elementMetaDataLen { // cf. Element.GetDataLen()
+		return errors.New("too small bytearray")
+	}
+
+	if data[0] != ElementVersion {
+		return fmt.Errorf("unsupported element version %d", data[0])
+	}
+
+	if data[1] == 0 {
+		return errors.New("invalid element degree")
+	}
+
+	if data[10] > 1 || data[11] > 1 {
+		return errors.New("invalid element flags")
+	}
+
+	el.value = make([]*ring.Poly, uint8(data[1]))
+
+	el.scale = math.Float64frombits(binary.LittleEndian.Uint64(data[2:10]))
+
+	el.isCKKS = data[10] == 1
+
+	el.isNTT = data[11] == 1
+
+	var pointer, inc int
+	pointer = elementMetaDataLen
+
+	for i := range el.value {
+
+		if el.value[i], inc, err = decodePoly(data[pointer:]); err != nil {
+			return err
+		}
+
+		pointer += inc
+	}
+
+	if pointer != len(data) {
+		return errors.New("remaining unparsed data")
+	}
+
+	return nil
+}
+
+// GetDataLen returns the length in bytes of the target Ciphertext.
+func (ciphertext *Ciphertext) GetDataLen(WithMetaData bool) (dataLen int) {
+	return ciphertext.Element.GetDataLen(WithMetaData)
+}
+
+// MarshalBinary encodes a Ciphertext on a byte slice. The total size
+// in byte is 12 + (2 + 8 * N * (level + 1)) * (degree + 1).
+func (ciphertext *Ciphertext) MarshalBinary() (data []byte, err error) {
+	return ciphertext.Element.MarshalBinary()
+}
+
+// UnmarshalBinary decodes a previously marshaled Ciphertext on the target Ciphertext.
+func (ciphertext *Ciphertext) UnmarshalBinary(data []byte) (err error) {
+	ciphertext.Element = new(Element)
+	return ciphertext.Element.UnmarshalBinary(data)
+}
+
+// GetDataLen returns the length in bytes of the target Plaintext.
+func (plaintext *Plaintext) GetDataLen(WithMetaData bool) (dataLen int) {
+	return plaintext.Element.GetDataLen(WithMetaData)
+}
+
+// MarshalBinary encodes a Plaintext on a byte slice.
+func (plaintext *Plaintext) MarshalBinary() (data []byte, err error) {
+	return plaintext.Element.MarshalBinary()
+}
+
+// UnmarshalBinary decodes a previously marshaled Plaintext on the target Plaintext.
+func (plaintext *Plaintext) UnmarshalBinary(data []byte) (err error) {
+	plaintext.Element = new(Element)
+	if err = plaintext.Element.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	if plaintext.Degree() != 0 {
+		return errors.New("a plaintext should have degree 0")
+	}
+
+	plaintext.value = plaintext.Element.value[0]
+	return nil
+}
+
+// GetDataLen returns the length in bytes of the target PlaintextRingT.
+func (plaintext *PlaintextRingT) GetDataLen(WithMetaData bool) (dataLen int) {
+	return plaintext.Element.GetDataLen(WithMetaData)
+}
+
+// MarshalBinary encodes a PlaintextRingT on a byte slice.
+func (plaintext *PlaintextRingT) MarshalBinary() (data []byte, err error) {
+	return plaintext.Element.MarshalBinary()
+}
+
+// UnmarshalBinary decodes a previously marshaled PlaintextRingT on the target PlaintextRingT.
+func (plaintext *PlaintextRingT) UnmarshalBinary(data []byte) (err error) {
+	if err = (*Plaintext)(plaintext).UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	if plaintext.IsCKKS() {
+		return errors.New("a PlaintextRingT should be an FV plaintext")
+	}
+
+	return nil
+}
+
+// GetDataLen returns the length in bytes of the target PlaintextMul.
+func (plaintext *PlaintextMul) GetDataLen(WithMetaData bool) (dataLen int) {
+	return plaintext.Element.GetDataLen(WithMetaData)
+}
+
+// MarshalBinary encodes a PlaintextMul on a byte slice.
+func (plaintext *PlaintextMul) MarshalBinary() (data []byte, err error) {
+	return plaintext.Element.MarshalBinary()
+}
+
+// UnmarshalBinary decodes a previously marshaled PlaintextMul on the target PlaintextMul.
+func (plaintext *PlaintextMul) UnmarshalBinary(data []byte) (err error) {
+	if err = (*Plaintext)(plaintext).UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	if plaintext.IsCKKS() {
+		return errors.New("a PlaintextMul should be an FV plaintext")
+	}
+
+	return nil
+}
+
+// sortedDiagonals returns the indexes of the non-zero diagonals of a diagonalized matrix in increasing order.
+func sortedDiagonals(vec map[int][2]*ring.Poly) (index []int) {
+	index = make([]int, 0, len(vec))
+	for i := range vec {
+		index = append(index, i)
+	}
+	sort.Ints(index)
+	return
+}
+
+// getDiagonalsDataLen returns the length in bytes of the non-zero diagonals of a diagonalized matrix.
+func getDiagonalsDataLen(vec map[int][2]*ring.Poly) (dataLen int) {
+	for _, diag := range vec {
+		dataLen += 8 + diag[0].GetDataLen(true) + diag[1].GetDataLen(true)
+	}
+	return
+}
+
+// writeDiagonals writes the non-zero diagonals of a diagonalized matrix on data, in increasing order of
+// their index, and returns the number of written bytes.
+func writeDiagonals(vec map[int][2]*ring.Poly, data []byte) (pointer int, err error) {
+	var inc int
+	for _, i := range sortedDiagonals(vec) {
+		binary.LittleEndian.PutUint64(data[pointer:pointer+8], uint64(int64(i)))
+		pointer += 8
+
+		for _, pol := range vec[i] {
+			if inc, err = pol.WriteTo(data[pointer:]); err != nil {
+				return pointer, err
+			}
+			pointer += inc
+		}
+	}
+	return
+}
+
+// readDiagonals reads nbDiags diagonals written with writeDiagonals and returns the number of read bytes.
+func readDiagonals(data []byte, nbDiags int) (vec map[int][2]*ring.Poly, pointer int, err error) {
+	// A diagonal takes at least 12 bytes: its index and the metadata of its two polynomials
+	if nbDiags > len(data)/12 {
+		return nil, 0, errors.New("too small bytearray")
+	}
+
+	var inc int
+	vec = make(map[int][2]*ring.Poly, nbDiags)
+	for j := 0; j < nbDiags; j++ {
+		if len(data[pointer:]) < 8 {
+			return nil, pointer, errors.New("too small bytearray")
+		}
+
+		i := int(int64(binary.LittleEndian.Uint64(data[pointer : pointer+8])))
+		pointer += 8
+
+		if _, exists := vec[i]; exists {
+			return nil, pointer, fmt.Errorf("diagonal %d is duplicated", i)
+		}
+
+		var diag [2]*ring.Poly
+		for k := range diag {
+			if diag[k], inc, err = decodePoly(data[pointer:]); err != nil {
+				return nil, pointer, err
+			}
+			pointer += inc
+		}
+
+		if diag[0].Degree() != diag[1].Degree() {
+			return nil, pointer, fmt.Errorf("diagonal %d has polynomials of different ring degrees", i)
+		}
+
+		vec[i] = diag
+	}
+	return
+}
+
+// GetDataLen returns the length in bytes of the target PtDiagMatrix.
+func (matrix *PtDiagMatrix) GetDataLen(WithMetaData bool) (dataLen int) {
+	// MetaData is :
+	// 1 byte : LogSlots
+	// 1 byte : Level
+	// 8 byte : N1
+	// 8 byte : Scale
+	// 1 byte : naive
+	// 1 byte : isGaussian
+	// 4 byte : #diagonals
+	if WithMetaData {
+		dataLen += 24
+	}
+
+	return dataLen + getDiagonalsDataLen(matrix.Vec)
+}
+
+// MarshalBinary encodes a PtDiagMatrix on a byte slice. The diagonals are written in increasing
+// order of their index, so that the encoding of a given matrix is deterministic.
+func (matrix *PtDiagMatrix) MarshalBinary() (data []byte, err error) {
+	if matrix.LogSlots < 0 || matrix.LogSlots > MaxLogN || matrix.Level < 0 || matrix.Level > 0xFF || matrix.N1 < 0 {
+		return nil, errors.New("cannot MarshalBinary: invalid matrix")
+	}
+
+	data = make([]byte, matrix.GetDataLen(true))
+
+	data[0] = uint8(matrix.LogSlots)
+	data[1] = uint8(matrix.Level)
+	binary.LittleEndian.PutUint64(data[2:10], uint64(matrix.N1))
+	binary.LittleEndian.PutUint64(data[10:18], math.Float64bits(matrix.Scale))
+	if matrix.naive {
+		data[18] = 1
+	}
+	if matrix.isGaussian {
+		data[19] = 1
+	}
+	binary.LittleEndian.PutUint32(data[20:24], uint32(len(matrix.Vec)))
+
+	if _, err = writeDiagonals(matrix.Vec, data[24:]); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled PtDiagMatrix on the target PtDiagMatrix.
+func (matrix *PtDiagMatrix) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 24 { // cf. PtDiagMatrix.GetDataLen()
+		return errors.New("too small bytearray")
+	}
+
+	matrix.LogSlots = int(data[0])
+	matrix.Level = int(data[1])
+	matrix.N1 = int(binary.LittleEndian.Uint64(data[2:10]))
+	matrix.Scale = math.Float64frombits(binary.LittleEndian.Uint64(data[10:18]))
+	matrix.naive = data[18] == 1
+	matrix.isGaussian = data[19] == 1
+
+	var pointer int
+	if matrix.Vec, pointer, err = readDiagonals(data[24:], int(binary.LittleEndian.Uint32(data[20:24]))); err != nil {
+		return err
+	}
+
+	if 24+pointer != len(data) {
+		return errors.New("remaining unparsed data")
+	}
+
+	return nil
+}
+
+// GetDataLen returns the length in bytes of the target PtDiagMatrixT.
+func (matrix *PtDiagMatrixT) GetDataLen(WithMetaData bool) (dataLen int) {
+	// MetaData is :
+	// 1 byte : LogFVSlots
+	// 8 byte : N1
+	// 1 byte : naive
+	// 4 byte : #diagonals
+	if WithMetaData {
+		dataLen += 14
+	}
+
+	return dataLen + getDiagonalsDataLen(matrix.Vec)
+}
+
+// MarshalBinary encodes a PtDiagMatrixT on a byte slice. The diagonals are written in increasing
+// order of their index, so that the encoding of a given matrix is deterministic.
+func (matrix *PtDiagMatrixT) MarshalBinary() (data []byte, err error) {
+	if matrix.LogFVSlots < 0 || matrix.LogFVSlots > MaxLogN || matrix.N1 < 0 {
+		return nil, errors.New("cannot MarshalBinary: invalid matrix")
+	}
+
+	data = make([]byte, matrix.GetDataLen(true))
+
+	data[0] = uint8(matrix.LogFVSlots)
+	binary.LittleEndian.PutUint64(data[1:9], uint64(matrix.N1))
+	if matrix.naive {
+		data[9] = 1
+	}
+	binary.LittleEndian.PutUint32(data[10:14], uint32(len(matrix.Vec)))
+
+	if _, err = writeDiagonals(matrix.Vec, data[14:]); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled PtDiagMatrixT on the target PtDiagMatrixT.
+func (matrix *PtDiagMatrixT) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 14 { // cf. PtDiagMatrixT.GetDataLen()
+		return errors.New("too small bytearray")
+	}
+
+	matrix.LogFVSlots = int(data[0])
+	matrix.N1 = int(binary.LittleEndian.Uint64(data[1:9]))
+	matrix.naive = data[9] == 1
+
+	var pointer int
+	if matrix.Vec, pointer, err = readDiagonals(data[14:], int(binary.LittleEndian.Uint32(data[10:14]))); err != nil {
+		return err
+	}
+
+	if 14+pointer != len(data) {
+		return errors.New("remaining unparsed data")
+	}
+
+	return nil
+}
+
+// MarshalCoeffsToSlotsMatrices encodes the CoeffsToSlots matrices of a HalfBootstrapper on a byte slice
+// (see HalfBootstrapper.CoeffsToSlotsMatrices).
+func MarshalCoeffsToSlotsMatrices(pDFTInv []*PtDiagMatrix) (data []byte, err error) {
+	// 4 byte : #matrices
+	// then for each matrix, 8 byte : length + matrix
+	data = make([]byte, 4, 4+len(pDFTInv)*8)
+	binary.LittleEndian.PutUint32(data, uint32(len(pDFTInv)))
+
+	var b []byte
+	for _, matrix := range pDFTInv {
+		if b, err = matrix.MarshalBinary(); err != nil {
+			return nil, err
+		}
+		data = appendWithLength(data, b)
+	}
+
+	return data, nil
+}
+
+// UnmarshalCoeffsToSlotsMatrices decodes CoeffsToSlots matrices previously marshaled with MarshalCoeffsToSlotsMatrices.
+// The result can be given to NewHalfBootstrapperWithMatrices, which checks it against the parameters.
+func UnmarshalCoeffsToSlotsMatrices(data []byte) (pDFTInv []*PtDiagMatrix, err error) {
+	var b [][]byte
+	if b, err = splitWithLength(data); err != nil {
+		return nil, err
+	}
+
+	pDFTInv = make([]*PtDiagMatrix, len(b))
+	for i := range pDFTInv {
+		pDFTInv[i] = new(PtDiagMatrix)
+		if err = pDFTInv[i].UnmarshalBinary(b[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return pDFTInv, nil
+}
+
+// MarshalSlotToCoeffMatFV encodes the SlotsToCoeffs matrices generated by MFVEncoder.GenSlotToCoeffMatFV on a byte slice.
+func MarshalSlotToCoeffMatFV(pDcds [][]*PtDiagMatrixT) (data []byte, err error) {
+	// 4 byte : #levels
+	// then for each level, 8 byte : length + 4 byte : #matrices
+	// then for each matrix, 8 byte : length + matrix
+	data = make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(len(pDcds)))
+
+	var b []byte
+	for _, pDcd := range pDcds {
+		level := make([]byte, 4)
+		binary.LittleEndian.PutUint32(level, uint32(len(pDcd)))
+		for _, matrix := range pDcd {
+			if b, err = matrix.MarshalBinary(); err != nil {
+				return nil, err
+			}
+			level = appendWithLength(level, b)
+		}
+		data = appendWithLength(data, level)
+	}
+
+	return data, nil
+}
+
+// UnmarshalSlotToCoeffMatFV decodes SlotsToCoeffs matrices previously marshaled with MarshalSlotToCoeffMatFV
+// and checks that they match the given parameters. The result can be given to NewMFVEvaluator.
+func UnmarshalSlotToCoeffMatFV(params *Parameters, data []byte) (pDcds [][]*PtDiagMatrixT, err error) {
+	var levels, b [][]byte
+	if levels, err = splitWithLength(data); err != nil {
+		return nil, err
+	}
+
+	if len(levels) != params.QiCount() {
+		return nil, fmt.Errorf("%d levels of matrices are expected but %d given", params.QiCount(), len(levels))
+	}
+
+	pDcds = make([][]*PtDiagMatrixT, len(levels))
+	for level := range pDcds {
+		if b, err = splitWithLength(levels[level]); err != nil {
+			return nil, err
+		}
+
+		if level > 0 && len(b) != len(pDcds[0]) {
+			return nil, errors.New("all the levels should have the same number of matrices")
+		}
+
+		pDcds[level] = make([]*PtDiagMatrixT, len(b))
+		for i := range pDcds[level] {
+			matrix := new(PtDiagMatrixT)
+			if err = matrix.UnmarshalBinary(b[i]); err != nil {
+				return nil, err
+			}
+
+			if matrix.LogFVSlots != params.LogFVSlots() {
+				return nil, fmt.Errorf("matrix has LogFVSlots %d but the parameters have LogFVSlots %d", matrix.LogFVSlots, params.LogFVSlots())
+			}
+
+			for _, diag := range matrix.Vec {
+				if diag[0].Degree() != params.N() || diag[0].LenModuli() != level+1 || diag[1].LenModuli() != params.PiCount() {
+					return nil, fmt.Errorf("matrix %d at level %d does not match the parameters", i, level)
+				}
+			}
+
+			pDcds[level][i] = matrix
+		}
+	}
+
+	return pDcds, nil
+}
+
+// appendWithLength appends b to data, prefixed with its length on 8 bytes.
+func appendWithLength(data, b []byte) []byte {
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, uint64(len(b)))
+	return append(append(data, length...), b...)
+}
+
+// splitWithLength splits data, made of a 4 byte count followed by that many length-prefixed
+// byte slices (cf. appendWithLength), into the byte slices.
+func splitWithLength(data []byte) (b [][]byte, err error) {
+	if len(data) < 4 {
+		return nil, errors.New("too small bytearray")
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[:4]))
+	if count > len(data)/8 {
+		return nil, errors.New("too small bytearray")
+	}
+
+	pointer := 4
+	b = make([][]byte, count)
+	for i := range b {
+		if len(data[pointer:]) < 8 {
+			return nil, errors.New("too small bytearray")
+		}
+
+		length := binary.LittleEndian.Uint64(data[pointer : pointer+8])
+		pointer += 8
+
+		if uint64(len(data[pointer:])) < length {
+			return nil, errors.New("too small bytearray")
+		}
+
+		b[i] = data[pointer : pointer+int(length)]
+		pointer += int(length)
+	}
+
+	if pointer != len(data) {
+		return nil, errors.New("remaining unparsed data")
+	}
+
+	return b, nil
+}
+
+// SymmetricCiphertextVersion is the version of the binary encoding of SymmetricCiphertext.
+// Version 2 appends the complex flag to the metadata of version 1, which is still decoded (as real data).
+const SymmetricCiphertextVersion = 2
+
+// symmetricCiphertextMetaDataLen returns the length in bytes of the metadata of the given version of the
+// binary encoding of SymmetricCiphertext.
+func symmetricCiphertextMetaDataLen(version byte) int {
+	if version == 1 {
+		return 19
+	}
+	return 20
+}
+
+// coeffByteLen returns the number of bytes needed to store an element of Z_t.
+func coeffByteLen(plainModulus uint64) int {
+	return (bits.Len64(plainModulus-1) + 7) >> 3
+}
+
+// GetDataLen returns the length in bytes of the target SymmetricCiphertext.
+// Only the FVSlots masked coefficients of each block are stored, each on the
+// smallest number of bytes that can hold an element of Z_t.
+func (symCt *SymmetricCiphertext) GetDataLen(WithMetaData bool) (dataLen int) {
+	// MetaData is :
+	// 1 byte : version
+	// 1 byte : cipher
+	// 1 byte : cipher parameter
+	// 1 byte : logN
+	// 1 byte : logFVSlots
+	// 8 byte : plaintext modulus
+	// 2 byte : nonce length
+	// 2 byte : counter length
+	// 2 byte : #blocks
+	// 1 byte : complex
+	if WithMetaData {
+		dataLen += symmetricCiphertextMetaDataLen(SymmetricCiphertextVersion)
+	}
+
+	if len(symCt.Nonces) > 0 {
+		dataLen += len(symCt.Nonces) * len(symCt.Nonces[0])
+	}
+	dataLen += len(symCt.Counter)
+	dataLen += len(symCt.Blocks) * symCt.FVSlots() * coeffByteLen(symCt.PlainModulus)
+
+	return dataLen
+}
+
+// MarshalBinary encodes a SymmetricCiphertext on a byte slice.
+// All the nonces should have the same length.
+func (symCt *SymmetricCiphertext) MarshalBinary() (data []byte, err error) {
+	slots := symCt.FVSlots()
+
+	if symCt.LogN < MinLogN || symCt.LogN > MaxLogN || symCt.LogFVSlots < 1 || symCt.LogFVSlots > symCt.LogN {
+		return nil, errors.New("cannot MarshalBinary: invalid LogN or LogFVSlots")
+	}
+
+	if symCt.PlainModulus < 2 {
+		return nil, errors.New("cannot MarshalBinary: invalid plaintext modulus")
+	}
+
+	if len(symCt.Nonces) != slots {
+		return nil, fmt.Errorf("cannot MarshalBinary: %d nonces are expected but %d given", slots, len(symCt.Nonces))
+	}
+
+	nonceLen := len(symCt.Nonces[0])
+	for i := range symCt.Nonces {
+		if len(symCt.Nonces[i]) != nonceLen {
+			return nil, errors.New("cannot MarshalBinary: nonces should have the same length")
+		}
+	}
+
+	if symCt.CipherParam < 0 || symCt.CipherParam > 0xFF || nonceLen > 0xFFFF || len(symCt.Counter) > 0xFFFF || len(symCt.Blocks) > 0xFFFF {
+		return nil, errors.New("cannot MarshalBinary: symmetric ciphertext too large")
+	}
+
+	data = make([]byte, symCt.GetDataLen(true))
+
+	data[0] = SymmetricCiphertextVersion
+	data[1] = uint8(symCt.Cipher)
+	data[2] = uint8(symCt.CipherParam)
+	data[3] = uint8(symCt.LogN)
+	data[4] = uint8(symCt.LogFVSlots)
+	binary.LittleEndian.PutUint64(data[5:13], symCt.PlainModulus)
+	binary.LittleEndian.PutUint16(data[13:15], uint16(nonceLen))
+	binary.LittleEndian.PutUint16(data[15:17], uint16(len(symCt.Counter)))
+	binary.LittleEndian.PutUint16(data[17:19], uint16(len(symCt.Blocks)))
+	if symCt.Complex {
+		data[19] = 1
+	}
+
+	pointer := 20
+
+	for i := range symCt.Nonces {
+		pointer += copy(data[pointer:], symCt.Nonces[i])
+	}
+
+	pointer += copy(data[pointer:], symCt.Counter)
+
+	coeffLen := coeffByteLen(symCt.PlainModulus)
+	gap := 1 << (symCt.LogN - symCt.LogFVSlots)
+	buff := make([]byte, 8)
+	for s, block := range symCt.Blocks {
+		coeffs := block.Value()[0].Coeffs[0]
+		if len(coeffs) != 1<<symCt.LogN {
+			return nil, fmt.Errorf("cannot MarshalBinary: block %d has an invalid ring degree", s)
+		}
+
+		for i := 0; i < slots; i++ {
+			binary.LittleEndian.PutUint64(buff, coeffs[i*gap])
+			pointer += copy(data[pointer:pointer+coeffLen], buff)
+		}
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled SymmetricCiphertext on the target SymmetricCiphertext.
+func (symCt *SymmetricCiphertext) UnmarshalBinary(data []byte) (err error) {
+	if len(data) == 0 {
+		return errors.New("too small bytearray")
+	}
+
+	if data[0] != 1 && data[0] != SymmetricCiphertextVersion {
+		return fmt.Errorf("unsupported symmetric ciphertext version %d", data[0])
+	}
+
+	metaDataLen := symmetricCiphertextMetaDataLen(data[0])
+	if len(data) < metaDataLen { // cf. SymmetricCiphertext.GetDataLen()
+		return errors.New("too small bytearray")
+	}
+
+	symCt.Cipher = CipherType(data[1])
+	symCt.CipherParam = int(data[2])
+	symCt.LogN = int(data[3])
+	symCt.LogFVSlots = int(data[4])
+	symCt.PlainModulus = binary.LittleEndian.Uint64(data[5:13])
+
+	if symCt.LogN < MinLogN || symCt.LogN > MaxLogN || symCt.LogFVSlots < 1 || symCt.LogFVSlots > symCt.LogN {
+		return errors.New("invalid LogN or LogFVSlots")
+	}
+
+	if symCt.PlainModulus < 2 {
+		return errors.New("invalid plaintext modulus")
+	}
+
+	nonceLen := int(binary.LittleEndian.Uint16(data[13:15]))
+	counterLen := int(binary.LittleEndian.Uint16(data[15:17]))
+	nbBlocks := int(binary.LittleEndian.Uint16(data[17:19]))
+
+	symCt.Complex = false
+	if metaDataLen > 19 {
+		if data[19] > 1 {
+			return errors.New("invalid complex flag")
+		}
+		symCt.Complex = data[19] == 1
+	}
+
+	slots := symCt.FVSlots()
+	coeffLen := coeffByteLen(symCt.PlainModulus)
+
+	if len(data) != metaDataLen+slots*nonceLen+counterLen+nbBlocks*slots*coeffLen {
+		return errors.New("invalid bytearray length")
+	}
+
+	pointer := metaDataLen
+
+	symCt.Nonces = make([][]byte, slots)
+	for i := range symCt.Nonces {
+		symCt.Nonces[i] = make([]byte, nonceLen)
+		pointer += copy(symCt.Nonces[i], data[pointer:pointer+nonceLen])
+	}
+
+	symCt.Counter = make([]byte, counterLen)
+	pointer += copy(symCt.Counter, data[pointer:pointer+counterLen])
+
+	gap := 1 << (symCt.LogN - symCt.LogFVSlots)
+	buff := make([]byte, 8)
+	symCt.Blocks = make([]*PlaintextRingT, nbBlocks)
+	for s := range symCt.Blocks {
+		pol := ring.NewPoly(1<<symCt.LogN, 1)
+		for i := 0; i < slots; i++ {
+			copy(buff, data[pointer:pointer+coeffLen])
+			pointer += coeffLen
+
+			c := binary.LittleEndian.Uint64(buff)
+			if c >= symCt.PlainModulus {
+				return fmt.Errorf("block %d has a coefficient larger than the plaintext modulus", s)
+			}
+			pol.Coeffs[0][i*gap] = c
+		}
+
+		symCt.Blocks[s] = &PlaintextRingT{&Element{value: []*ring.Poly{pol}}, pol}
+	}
+
+	return nil
+}
+
+// SymmetricKeyBundleVersion is the version of the binary encoding of SymmetricKeyBundle.
+const SymmetricKeyBundleVersion = 1
+
+// GetDataLen returns the length in bytes of the target SymmetricKeyBundle.
+func (bundle *SymmetricKeyBundle) GetDataLen(WithMetaData bool) (dataLen int) {
+	// MetaData is :
+	// 1 byte : version
+	// 1 byte : cipher
+	// 1 byte : cipher parameter
+	// 1 byte : logN
+	// 1 byte : logFVSlots
+	// 8 byte : plaintext modulus
+	// 4 byte : #slots (0 for all the slots)
+	// 4 byte : #ciphertexts
+	if WithMetaData {
+		dataLen += 21
+	}
+
+	// 4 byte : slot
+	dataLen += 4 * len(bundle.Slots)
+
+	// 8 byte : length + ciphertext
+	for _, ct := range bundle.Ciphertexts {
+		dataLen += 8 + ct.GetDataLen(true)
+	}
+
+	return dataLen
+}
+
+// MarshalBinary encodes a SymmetricKeyBundle on a byte slice.
+func (bundle *SymmetricKeyBundle) MarshalBinary() (data []byte, err error) {
+	if bundle.LogN < MinLogN || bundle.LogN > MaxLogN || bundle.LogFVSlots < 1 || bundle.LogFVSlots > bundle.LogN {
+		return nil, errors.New("cannot MarshalBinary: invalid LogN or LogFVSlots")
+	}
+
+	if bundle.CipherParam < 0 || bundle.CipherParam > 0xFF {
+		return nil, errors.New("cannot MarshalBinary: invalid cipher parameter")
+	}
+
+	if bundle.Slots != nil && len(bundle.Slots) == 0 {
+		return nil, errors.New("cannot MarshalBinary: empty slot group")
+	}
+
+	data = make([]byte, 17+4*len(bundle.Slots)+4, bundle.GetDataLen(true))
+
+	data[0] = SymmetricKeyBundleVersion
+	data[1] = uint8(bundle.Cipher)
+	data[2] = uint8(bundle.CipherParam)
+	data[3] = uint8(bundle.LogN)
+	data[4] = uint8(bundle.LogFVSlots)
+	binary.LittleEndian.PutUint64(data[5:13], bundle.PlainModulus)
+	binary.LittleEndian.PutUint32(data[13:17], uint32(len(bundle.Slots)))
+	pointer := 17
+	for _, k := range bundle.Slots {
+		binary.LittleEndian.PutUint32(data[pointer:pointer+4], uint32(k))
+		pointer += 4
+	}
+	binary.LittleEndian.PutUint32(data[pointer:pointer+4], uint32(len(bundle.Ciphertexts)))
+
+	var b []byte
+	for _, ct := range bundle.Ciphertexts {
+		if b, err = ct.MarshalBinary(); err != nil {
+			return nil, err
+		}
+		data = appendWithLength(data, b)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled SymmetricKeyBundle on the target SymmetricKeyBundle.
+func (bundle *SymmetricKeyBundle) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 21 { // cf. SymmetricKeyBundle.GetDataLen()
+		return errors.New("too small bytearray")
+	}
+
+	if data[0] != SymmetricKeyBundleVersion {
+		return fmt.Errorf("unsupported symmetric key bundle version %d", data[0])
+	}
+
+	bundle.Cipher = CipherType(data[1])
+	bundle.CipherParam = int(data[2])
+	bundle.LogN = int(data[3])
+	bundle.LogFVSlots = int(data[4])
+	bundle.PlainModulus = binary.LittleEndian.Uint64(data[5:13])
+
+	if bundle.LogN < MinLogN || bundle.LogN > MaxLogN || bundle.LogFVSlots < 1 || bundle.LogFVSlots > bundle.LogN {
+		return errors.New("invalid LogN or LogFVSlots")
+	}
+
+	nbSlots := int(binary.LittleEndian.Uint32(data[13:17]))
+	if nbSlots > (len(data)-21)/4 {
+		return errors.New("too small bytearray")
+	}
+
+	bundle.Slots = nil
+	pointer := 17
+	if nbSlots > 0 {
+		bundle.Slots = make([]int, nbSlots)
+		for j := range bundle.Slots {
+			bundle.Slots[j] = int(binary.LittleEndian.Uint32(data[pointer : pointer+4]))
+			pointer += 4
+		}
+	}
+
+	var b [][]byte
+	if b, err = splitWithLength(data[pointer:]); err != nil {
+		return err
+	}
+
+	bundle.Ciphertexts = make([]*Ciphertext, len(b))
+	for i := range b {
+		bundle.Ciphertexts[i] = new(Ciphertext)
+		if err = bundle.Ciphertexts[i].UnmarshalBinary(b[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,436 @@
+package ckks_fv
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestMarshaller(t *testing.T) {
+	testCases := []*TranscipherParameters{
+		genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1]),
+		genTestTranscipherParams(RtFRubatoParams[0], CipherRubato, RUBATO80M, 2, RubatoModDownParams[RUBATO80M]),
+	}
+
+	for _, tcParams := range testCases {
+		params, err := tcParams.Params()
+		require.NoError(t, err)
+
+		testMarshallerSymmetricCiphertext(t, tcParams, params)
+		testMarshallerElements(t, params)
+		testMarshallerDiagMatrices(t, tcParams, params)
+	}
+
+	hbtpParamsList := append(append([]*HalfBootParameters{}, RtFHeraParams...), RtFRubatoParams...)
+	hbtpParamsList = append(append(hbtpParamsList, RtFHeraDenseParams...), RtFRubatoDenseParams...)
+	for _, hbtpParams := range hbtpParamsList {
+		testMarshallerHalfBootParameters(t, hbtpParams)
+	}
+
+	testMarshallerModDownParams(t)
+}
+
+func testMarshallerDiagMatrices(t *testing.T, tcParams *TranscipherParameters, params *Parameters) {
+	t.Run(testString("Marshaller/PtDiagMatrixT/", params), func(t *testing.T) {
+		pDcds := NewMFVEncoder(params).GenSlotToCoeffMatFV(tcParams.Radix)
+
+		b, err := pDcds[0][0].MarshalBinary()
+		require.NoError(t, err)
+		require.Len(t, b, pDcds[0][0].GetDataLen(true))
+		matrix := new(PtDiagMatrixT)
+		require.NoError(t, matrix.UnmarshalBinary(b))
+		assert.Equal(t, pDcds[0][0], matrix)
+		assert.Error(t, matrix.UnmarshalBinary(b[:len(b)-1]))
+
+		// The number of diagonals is checked against the length of the data before any allocation
+		bad := append([]byte{}, b...)
+		binary.LittleEndian.PutUint32(bad[10:14], 0xFFFFFFFF)
+		assert.Error(t, matrix.UnmarshalBinary(bad))
+
+		b, err = MarshalSlotToCoeffMatFV(pDcds)
+		require.NoError(t, err)
+		pDcdsNew, err := UnmarshalSlotToCoeffMatFV(params, b)
+		require.NoError(t, err)
+		assert.Equal(t, pDcds, pDcdsNew)
+
+		// Deterministic encoding
+		bNew, err := MarshalSlotToCoeffMatFV(pDcdsNew)
+		require.NoError(t, err)
+		assert.Equal(t, b, bNew)
+
+		_, err = UnmarshalSlotToCoeffMatFV(params, b[:len(b)-1])
+		assert.Error(t, err)
+
+		other := params.Copy()
+		other.SetLogFVSlots(params.LogFVSlots() - 1)
+		_, err = UnmarshalSlotToCoeffMatFV(other, b)
+		assert.Error(t, err)
+
+		b, err = MarshalSlotToCoeffMatFV(pDcds[1:])
+		require.NoError(t, err)
+		_, err = UnmarshalSlotToCoeffMatFV(params, b)
+		assert.Error(t, err)
+	})
+
+	t.Run(testString("Marshaller/PtDiagMatrix/", params), func(t *testing.T) {
+		hbtpParams := &tcParams.HalfBootParameters
+		hbtp, err := newHalfBootstrapper(params, hbtpParams, nil)
+		require.NoError(t, err)
+		pDFTInv := hbtp.CoeffsToSlotsMatrices()
+
+		b, err := pDFTInv[0].MarshalBinary()
+		require.NoError(t, err)
+		require.Len(t, b, pDFTInv[0].GetDataLen(true))
+		matrix := new(PtDiagMatrix)
+		require.NoError(t, matrix.UnmarshalBinary(b))
+		assert.Equal(t, pDFTInv[0], matrix)
+		assert.Error(t, matrix.UnmarshalBinary(b[:len(b)-1]))
+
+		bad := append([]byte{}, b...)
+		binary.LittleEndian.PutUint32(bad[20:24], 0xFFFFFFFF)
+		assert.Error(t, matrix.UnmarshalBinary(bad))
+
+		b, err = MarshalCoeffsToSlotsMatrices(pDFTInv)
+		require.NoError(t, err)
+		pDFTInvNew, err := UnmarshalCoeffsToSlotsMatrices(b)
+		require.NoError(t, err)
+		assert.Equal(t, pDFTInv, pDFTInvNew)
+
+		hbtpNew, err := newHalfBootstrapper(params, hbtpParams, pDFTInvNew)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, hbtp.rotKeyIndex, hbtpNew.rotKeyIndex)
+		assert.Equal(t, hbtp.CoeffsToSlotsMatrices(), hbtpNew.CoeffsToSlotsMatrices())
+
+		_, err = newHalfBootstrapper(params, hbtpParams, pDFTInvNew[1:])
+		assert.Error(t, err)
+
+		pDFTInvNew[0].Level--
+		_, err = newHalfBootstrapper(params, hbtpParams, pDFTInvNew)
+		assert.Error(t, err)
+	})
+}
+
+func testMarshallerHalfBootParameters(t *testing.T, hbtpParams *HalfBootParameters) {
+	params, err := hbtpParams.Params()
+	require.NoError(t, err)
+
+	t.Run(testString("Marshaller/HalfBootParameters/Binary/", params), func(t *testing.T) {
+		b, err := hbtpParams.MarshalBinary()
+		require.NoError(t, err)
+
+		hbNew := new(HalfBootParameters)
+		require.NoError(t, hbNew.UnmarshalBinary(b))
+		assert.Equal(t, hbtpParams, hbNew)
+		assert.True(t, hbtpParams.Equals(hbNew))
+
+		assert.Equal(t, byte(HalfBootParametersVersion), b[0])
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(b[:111]))
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(b[:len(b)-1]))
+
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(b[1:]))
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(b[:len(b)-8]))
+
+		bad := append([]byte{}, b...)
+		bad[0] = HalfBootParametersVersion + 1
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(bad))
+
+		// The encoding of version 1 has no EphemeralSecretWeight, which is decoded as zero
+		v1 := append([]byte{1}, b[1:len(b)-8]...)
+		hbV1 := new(HalfBootParameters)
+		require.NoError(t, hbV1.UnmarshalBinary(v1))
+		want := hbtpParams.Copy()
+		want.EphemeralSecretWeight = 0
+		assert.True(t, want.Equals(hbV1))
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(v1[:len(v1)-1]))
+		bad = append([]byte{1}, b[1:]...)
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(bad))
+
+		// Decreases LogN so that LogSlots is too large
+		bad = append([]byte{}, b...)
+		bad[1] = bad[2]
+		assert.Error(t, new(HalfBootParameters).UnmarshalBinary(bad))
+	})
+
+	t.Run(testString("Marshaller/HalfBootParameters/JSON/", params), func(t *testing.T) {
+		b, err := json.Marshal(hbtpParams)
+		require.NoError(t, err)
+
+		hbNew := new(HalfBootParameters)
+		require.NoError(t, json.Unmarshal(b, hbNew))
+		assert.Equal(t, hbtpParams, hbNew)
+		assert.True(t, hbtpParams.Equals(hbNew))
+
+		other := hbtpParams.Copy()
+		other.H++
+		assert.False(t, hbtpParams.Equals(other))
+
+		other = hbtpParams.Copy()
+		other.EphemeralSecretWeight++
+		assert.False(t, hbtpParams.Equals(other))
+
+		other = hbtpParams.Copy()
+		other.CoeffsToSlotsModuli.ScalingFactor[0][0] *= 2
+		assert.False(t, hbtpParams.Equals(other))
+
+		other = hbtpParams.Copy()
+		other.DiffScaleModulus = append(other.DiffScaleModulus, other.DiffScaleModulus[0])
+		_, err = json.Marshal(other)
+		assert.Error(t, err)
+
+		// Identical invalid parameters are equal
+		other = hbtpParams.Copy()
+		other.LogSlots = other.LogN
+		require.Error(t, other.Validate())
+		assert.True(t, other.Equals(other.Copy()))
+		assert.False(t, hbtpParams.Equals(other))
+
+		assert.Error(t, json.Unmarshal([]byte(`{"LogN":3}`), new(HalfBootParameters)))
+	})
+}
+
+func testMarshallerModDownParams(t *testing.T) {
+	modDownParams := append(append([]ModDownParams{}, HeraModDownParams80...), HeraModDownParams128...)
+	modDownParams = append(modDownParams, RubatoModDownParams...)
+
+	t.Run("Marshaller/ModDownParams", func(t *testing.T) {
+		for _, modDown := range modDownParams {
+			b, err := modDown.MarshalBinary()
+			require.NoError(t, err)
+			assert.Len(t, b, 2+len(modDown.CipherModDown)+len(modDown.StCModDown))
+
+			modDownNew := new(ModDownParams)
+			require.NoError(t, modDownNew.UnmarshalBinary(b))
+			assert.True(t, modDown.Equals(*modDownNew))
+			assert.Error(t, modDownNew.UnmarshalBinary(b[:len(b)-1]))
+
+			b, err = json.Marshal(modDown)
+			require.NoError(t, err)
+			modDownNew = new(ModDownParams)
+			require.NoError(t, json.Unmarshal(b, modDownNew))
+			assert.Equal(t, modDown, *modDownNew)
+		}
+
+		assert.False(t, modDownParams[0].Equals(modDownParams[1]))
+		_, err := ModDownParams{CipherModDown: []int{-1}}.MarshalBinary()
+		assert.Error(t, err)
+	})
+}
+
+func testMarshallerElements(t *testing.T, params *Parameters) {
+	prng, err := utils.NewPRNG()
+	require.NoError(t, err)
+
+	assertEqualElement := func(t *testing.T, want, have *Element) {
+		assert.Equal(t, want.Degree(), have.Degree())
+		assert.Equal(t, want.Level(), have.Level())
+		assert.Equal(t, want.Scale(), have.Scale())
+		assert.Equal(t, want.IsNTT(), have.IsNTT())
+		assert.Equal(t, want.IsCKKS(), have.IsCKKS())
+		for i := range want.Value() {
+			assert.Equal(t, want.Value()[i].Coeffs, have.Value()[i].Coeffs)
+		}
+	}
+
+	t.Run(testString("Marshaller/Ciphertext/", params), func(t *testing.T) {
+		for _, ct := range []*Ciphertext{
+			NewCiphertextFVRandom(prng, params, 1),
+			NewCiphertextCKKSRandom(prng, params, 2, params.MaxLevel()-1, params.Scale()),
+			NewCiphertextFVLvl(params, 1, 0),
+		} {
+			b, err := ct.MarshalBinary()
+			require.NoError(t, err)
+			require.Len(t, b, ct.GetDataLen(true))
+
+			ctNew := new(Ciphertext)
+			require.NoError(t, ctNew.UnmarshalBinary(b))
+			assertEqualElement(t, ct.Element, ctNew.Element)
+
+			assert.Error(t, ctNew.UnmarshalBinary(b[:10]))
+			assert.Error(t, ctNew.UnmarshalBinary(b[:len(b)-1]))
+			assert.Error(t, ctNew.UnmarshalBinary(append(b, 0)))
+		}
+
+		// The scheme is set by the constructors and kept with the parameters of the element
+		ctCKKS := NewCiphertextCKKS(params, 1, 0, params.Scale())
+		assert.True(t, ctCKKS.IsCKKS())
+		assert.False(t, NewCiphertextFV(params, 1).IsCKKS())
+		ctFV := NewCiphertextFVLvl(params, 1, 0)
+		ctFV.CopyParams(ctCKKS.Element)
+		assert.True(t, ctFV.IsCKKS())
+
+		b, err := ctCKKS.MarshalBinary()
+		require.NoError(t, err)
+		for _, i := range []int{0, 10, 11} {
+			corrupted := append([]byte{}, b...)
+			corrupted[i] = 2 + ElementVersion
+			assert.Error(t, new(Ciphertext).UnmarshalBinary(corrupted))
+		}
+	})
+
+	t.Run(testString("Marshaller/Plaintext/", params), func(t *testing.T) {
+		ptCKKS := NewPlaintextCKKS(params, params.MaxLevel(), params.Scale())
+		ptFV := NewPlaintextFV(params)
+		for _, pt := range []*Plaintext{ptCKKS, ptFV} {
+			populateElementRandom(prng, params, pt.Element)
+
+			b, err := pt.MarshalBinary()
+			require.NoError(t, err)
+
+			ptNew := new(Plaintext)
+			require.NoError(t, ptNew.UnmarshalBinary(b))
+			assertEqualElement(t, pt.Element, ptNew.Element)
+			assert.True(t, ptNew.value == ptNew.Value()[0])
+		}
+
+		b, err := NewCiphertextFV(params, 1).MarshalBinary()
+		require.NoError(t, err)
+		assert.Error(t, new(Plaintext).UnmarshalBinary(b))
+	})
+
+	t.Run(testString("Marshaller/PlaintextRingT/", params), func(t *testing.T) {
+		pt := NewPlaintextRingT(params)
+		for i := range pt.value.Coeffs[0] {
+			pt.value.Coeffs[0][i] = uint64(i*7919+1) % params.PlainModulus()
+		}
+
+		// The scale of the data of a symmetric ciphertext block is kept as is
+		for _, scale := range []float64{0, float64(params.PlainModulus()) / 512.0} {
+			pt.SetScale(scale)
+
+			b, err := pt.MarshalBinary()
+			require.NoError(t, err)
+
+			ptNew := new(PlaintextRingT)
+			require.NoError(t, ptNew.UnmarshalBinary(b))
+			assertEqualElement(t, pt.Element, ptNew.Element)
+			assert.True(t, ptNew.value == ptNew.Value()[0])
+		}
+
+		// A CKKS plaintext is not a PlaintextRingT
+		b, err := NewPlaintextCKKS(params, 0, params.Scale()).MarshalBinary()
+		require.NoError(t, err)
+		assert.Error(t, new(PlaintextRingT).UnmarshalBinary(b))
+		assert.Error(t, new(PlaintextMul).UnmarshalBinary(b))
+	})
+
+	t.Run(testString("Marshaller/PlaintextMul/", params), func(t *testing.T) {
+		pt := NewPlaintextMul(params)
+		populateElementRandom(prng, params, pt.Element)
+		pt.SetIsNTT(true)
+
+		b, err := pt.MarshalBinary()
+		require.NoError(t, err)
+
+		ptNew := new(PlaintextMul)
+		require.NoError(t, ptNew.UnmarshalBinary(b))
+		assertEqualElement(t, pt.Element, ptNew.Element)
+		assert.True(t, ptNew.value == ptNew.Value()[0])
+	})
+}
+
+func testMarshallerSymmetricCiphertext(t *testing.T, tcParams *TranscipherParameters, params *Parameters) {
+	n := params.FVSlots()
+	key := newTestKey(tcParams.KeySize(), params.PlainModulus())
+	data := make([]float64, 2*n)
+	for i := range data {
+		data[i] = utils.RandFloat64(-1, 1)
+	}
+	symCt := NewRtFEncryptor(tcParams, key).EncryptNew(data, newTestNonces(n), []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	require.NoError(t, symCt.Validate(params))
+
+	t.Run(testString("Marshaller/SymmetricCiphertext/", params), func(t *testing.T) {
+		b, err := symCt.MarshalBinary()
+		require.NoError(t, err)
+		require.Len(t, b, symCt.GetDataLen(true))
+		assert.Equal(t, 20+n*64+8+2*n*coeffByteLen(params.PlainModulus()), len(b))
+
+		symCtNew := new(SymmetricCiphertext)
+		require.NoError(t, symCtNew.UnmarshalBinary(b))
+		require.NoError(t, symCtNew.Validate(params))
+
+		assert.Equal(t, symCt.Cipher, symCtNew.Cipher)
+		assert.Equal(t, symCt.CipherParam, symCtNew.CipherParam)
+		assert.Equal(t, symCt.PlainModulus, symCtNew.PlainModulus)
+		assert.Equal(t, symCt.LogN, symCtNew.LogN)
+		assert.Equal(t, symCt.LogFVSlots, symCtNew.LogFVSlots)
+		assert.Equal(t, symCt.Nonces, symCtNew.Nonces)
+		assert.Equal(t, symCt.Counter, symCtNew.Counter)
+		assert.False(t, symCtNew.Complex)
+		require.Len(t, symCtNew.Blocks, len(symCt.Blocks))
+		for s := range symCt.Blocks {
+			assert.Equal(t, symCt.Blocks[s].Value()[0].Coeffs, symCtNew.Blocks[s].Value()[0].Coeffs)
+		}
+
+		// Complex data
+		symCt.Complex = true
+		b, err = symCt.MarshalBinary()
+		symCt.Complex = false
+		require.NoError(t, err)
+		require.NoError(t, symCtNew.UnmarshalBinary(b))
+		assert.True(t, symCtNew.Complex)
+
+		// Version 1 has no complex flag
+		b = append([]byte{1}, append(b[1:19], b[20:]...)...)
+		require.NoError(t, symCtNew.UnmarshalBinary(b))
+		assert.False(t, symCtNew.Complex)
+		for s := range symCt.Blocks {
+			assert.Equal(t, symCt.Blocks[s].Value()[0].Coeffs, symCtNew.Blocks[s].Value()[0].Coeffs)
+		}
+	})
+
+	t.Run(testString("Marshaller/SymmetricCiphertext/Invalid/", params), func(t *testing.T) {
+		b, err := symCt.MarshalBinary()
+		require.NoError(t, err)
+
+		symCtNew := new(SymmetricCiphertext)
+		assert.Error(t, symCtNew.UnmarshalBinary(b[:18]))
+		assert.Error(t, symCtNew.UnmarshalBinary(b[:len(b)-1]))
+
+		bad := append([]byte{}, b...)
+		bad[0] = SymmetricCiphertextVersion + 1
+		assert.Error(t, symCtNew.UnmarshalBinary(bad))
+
+		bad = append([]byte{}, b...)
+		bad[19] = 2
+		assert.Error(t, symCtNew.UnmarshalBinary(bad))
+
+		// Sets the last coefficient to 2^(8*coeffByteLen)-1 >= t
+		bad = append([]byte{}, b...)
+		for i := len(bad) - coeffByteLen(params.PlainModulus()); i < len(bad); i++ {
+			bad[i] = 0xFF
+		}
+		assert.Error(t, symCtNew.UnmarshalBinary(bad))
+
+		symCtNew.Nonces = symCt.Nonces[1:]
+		_, err = symCtNew.MarshalBinary()
+		assert.Error(t, err)
+	})
+
+	t.Run(testString("Marshaller/SymmetricCiphertext/Validate/", params), func(t *testing.T) {
+		other := params.Copy()
+		other.SetPlainModulus(65537)
+		assert.Error(t, symCt.Validate(other))
+
+		other = params.Copy()
+		other.SetLogFVSlots(params.LogFVSlots() - 1)
+		assert.Error(t, symCt.Validate(other))
+
+		block := symCt.Blocks[0]
+		symCt.Blocks[0] = (*PlaintextRingT)(block.CopyNew().Plaintext())
+		symCt.Blocks[0].Value()[0].Coeffs[0][0] = params.PlainModulus()
+		assert.Error(t, symCt.Validate(params))
+
+		if params.LogFVSlots() < params.LogN() {
+			symCt.Blocks[0] = (*PlaintextRingT)(block.CopyNew().Plaintext())
+			symCt.Blocks[0].Value()[0].Coeffs[0][1] = 1
+			assert.Error(t, symCt.Validate(params))
+		}
+		symCt.Blocks[0] = block
+		assert.NoError(t, symCt.Validate(params))
+	})
+}
@@ -0,0 +1,629 @@
+package ckks_fv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ldsec/lattigo/v2/ring"
+)
+
+// MFVNoise is an analytic estimate of the invariant noise of an MFV ciphertext, i.e. the infinity norm of
+// v such that t/Q * (c0 + c1*s) = m + v + t*a for some integer polynomial a. Decryption is correct as long
+// as the norm of v is smaller than 1/2, and the invariant noise budget is -log2(v) - 1.
+type MFVNoise struct {
+	Level int
+	Worst float64 // log2 of the worst-case bound on the invariant noise
+	Avg   float64 // log2 of the average-case estimate of the invariant noise
+}
+
+// MFVAnalyticNoiseEstimator is an MFVNoiseEstimator which does not need the secret key.
+// It propagates analytic bounds on the invariant noise through the homomorphic operations.
+//
+// The noise of a ciphertext is known to the estimator if it has been produced by an evaluator returned by
+// Evaluator, or registered with SetNoise (the FV ciphers register their fresh encryptions and their copies).
+// The noise is stored with the ciphertext, so that an operation in place replaces the noise of its output, and
+// the estimator does not keep the ciphertexts alive. The noise of other ciphertexts (e.g. copies made with
+// Ciphertext.CopyNew) is unknown: Noise returns an error, and InvariantNoiseBudget and the evaluator assume
+// that they have no budget left.
+type MFVAnalyticNoiseEstimator interface {
+	MFVNoiseEstimator
+
+	// Fresh returns the noise of a fresh public key encryption, as done by MFVEncryptor.EncryptNew.
+	Fresh() MFVNoise
+	// Plaintext returns the noise of a plaintext scaled by Q/t at the given level (as done by MFVEncoder.EncodeUint).
+	Plaintext(level int) MFVNoise
+	// Add returns the noise of the sum (or difference) of two ciphertexts.
+	Add(n0, n1 MFVNoise) MFVNoise
+	// MulScalar returns the noise of the product of a ciphertext by a scalar of Z_t.
+	MulScalar(n MFVNoise, scalar uint64) MFVNoise
+	// MulPlain returns the noise of the product of a ciphertext by a plaintext of R_t (e.g. a PlaintextMul).
+	MulPlain(n MFVNoise) MFVNoise
+	// Mul returns the noise of the tensor product of two ciphertexts, before relinearization.
+	Mul(n0, n1 MFVNoise) MFVNoise
+	// KeySwitch returns the noise after a key switching (relinearization or rotation).
+	KeySwitch(n MFVNoise) MFVNoise
+	// LinearTransform returns the noise after the evaluation of a diagonalized matrix.
+	LinearTransform(n MFVNoise, matrix *PtDiagMatrixT) MFVNoise
+	// ModSwitch returns the noise after dropping nbModSwitch moduli. It panics if the level of the noise is
+	// smaller than nbModSwitch.
+	ModSwitch(n MFVNoise, nbModSwitch int) MFVNoise
+	// Budget returns the invariant noise budget corresponding to the given noise, in bits.
+	// The worst-case bound is used if the estimator is a worst-case estimator, the average estimate otherwise.
+	Budget(n MFVNoise) int
+
+	// Noise returns the noise of a ciphertext, or an error if it is not tracked.
+	Noise(ct *Ciphertext) (MFVNoise, error)
+	// SetNoise registers the noise of a ciphertext.
+	SetNoise(ct *Ciphertext, n MFVNoise)
+	// Reset forgets the noise of all the registered ciphertexts. It should not be called concurrently
+	// with the evaluation of ciphertexts.
+	Reset()
+	// Evaluator returns an MFVEvaluator which evaluates the operations with eval and registers the noise
+	// of their results.
+	Evaluator(eval MFVEvaluator) MFVEvaluator
+}
+
+type mfvAnalyticNoiseEstimator struct {
+	params    *Parameters
+	worstCase bool
+
+	logN    float64 // log2(N)
+	logT    float64 // log2(t)
+	logQ    []float64
+	logR    []float64 // log2(Q mod t) for each level
+	logS    float64   // log2 of the Hamming weight of the secret
+	sigma   float64
+	nbDigit int // number of digits in the key switching decomposition
+
+	gen uint64 // generation of the registered noises, incremented by Reset
+}
+
+// elementNoise is the noise of an element registered by an estimator during the generation gen.
+type elementNoise struct {
+	est *mfvAnalyticNoiseEstimator
+	gen uint64
+	MFVNoise
+}
+
+// NewMFVAnalyticNoiseEstimator creates a new MFVAnalyticNoiseEstimator for secrets of Hamming weight h
+// (h <= 0 stands for a uniform ternary secret). If worstCase is true, InvariantNoiseBudget returns the budget
+// of the worst-case bound, which never overestimates the actual budget. It returns the budget of the
+// average-case estimate otherwise, which is close to the actual budget.
+func NewMFVAnalyticNoiseEstimator(params *Parameters, h int, worstCase bool) MFVAnalyticNoiseEstimator {
+	est := new(mfvAnalyticNoiseEstimator)
+	est.params = params.Copy()
+	est.worstCase = worstCase
+
+	N := params.N()
+	if h <= 0 || h > N {
+		h = 2 * N / 3
+	}
+
+	t := params.PlainModulus()
+	est.logN = float64(params.LogN())
+	est.logT = math.Log2(float64(t))
+	est.logS = math.Log2(float64(h))
+	est.sigma = params.Sigma()
+
+	est.logQ = make([]float64, params.QiCount())
+	est.logR = make([]float64, params.QiCount())
+	Q := big.NewInt(1)
+	for i, qi := range params.Qi() {
+		Q.Mul(Q, ring.NewUint(qi))
+		est.logQ[i] = log2Big(Q)
+		r := new(big.Int).Mod(Q, ring.NewUint(t)).Uint64()
+		est.logR[i] = math.Log2(float64(r) + 1)
+	}
+
+	est.nbDigit = 1
+	if params.PiCount() > 0 {
+		est.nbDigit = (params.QiCount() + params.PiCount() - 1) / params.PiCount()
+	}
+
+	return est
+}
+
+// log2Big returns log2(x) for a positive big integer x.
+func log2Big(x *big.Int) float64 {
+	shift := x.BitLen() - 64
+	if shift < 0 {
+		shift = 0
+	}
+	f, _ := new(big.Float).SetInt(new(big.Int).Rsh(x, uint(shift))).Float64()
+	return math.Log2(f) + float64(shift)
+}
+
+// addLog returns log2(2^a + 2^b).
+func addLog(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	if math.IsInf(b, -1) {
+		return a
+	}
+	return a + math.Log2(1+math.Exp2(b-a))
+}
+
+// addLogSquare returns log2(sqrt(2^(2a) + 2^(2b))).
+func addLogSquare(a, b float64) float64 {
+	return addLog(2*a, 2*b) / 2
+}
+
+// rounding returns log2 of the worst-case and average norm of e0 + e1*s in units of 1/Q at the given level,
+// where e0 and e1 are rounding errors in [-1/2, 1/2], scaled by t.
+func (est *mfvAnalyticNoiseEstimator) rounding(level int) (worst, avg float64) {
+	worst = est.logT + math.Log2(1+math.Exp2(est.logS)) - 1 - est.logQ[level]
+	// The sum has variance (1+h)/12, and its infinity norm is below 6 standard deviations
+	avg = est.logT + math.Log2(6) + (math.Log2(1+math.Exp2(est.logS))-math.Log2(12))/2 - est.logQ[level]
+	return
+}
+
+func (est *mfvAnalyticNoiseEstimator) Fresh() MFVNoise {
+	level := est.params.MaxLevel()
+
+	// The encryption noise u*e + e0 + e1*s is divided by P and rounded
+	worst, avg := est.rounding(level)
+	if est.params.PiCount() > 0 {
+		logP := 0.0
+		for _, pi := range est.params.Pi() {
+			logP += math.Log2(float64(pi))
+		}
+		B := 6 * est.sigma
+		worst = addLog(worst, est.logT+math.Log2(B*(float64(est.params.N())+1+math.Exp2(est.logS)))-logP-est.logQ[level])
+		avg = addLogSquare(avg, est.logT+math.Log2(6*est.sigma*math.Sqrt(2*float64(est.params.N())/3+1+math.Exp2(est.logS)))-logP-est.logQ[level])
+	}
+
+	// Delta*m = (Q - (Q mod t))*m/t adds (Q mod t)*m/Q with m in [0, t)
+	pt := est.Plaintext(level)
+
+	return MFVNoise{Level: level, Worst: addLog(worst, pt.Worst), Avg: addLog(avg, pt.Avg)}
+}
+
+func (est *mfvAnalyticNoiseEstimator) Plaintext(level int) MFVNoise {
+	n := est.logR[level] + est.logT - est.logQ[level]
+	return MFVNoise{Level: level, Worst: n, Avg: n}
+}
+
+func (est *mfvAnalyticNoiseEstimator) Add(n0, n1 MFVNoise) MFVNoise {
+	return MFVNoise{
+		Level: n0.Level,
+		Worst: addLog(n0.Worst, n1.Worst),
+		Avg:   addLogSquare(n0.Avg, n1.Avg),
+	}
+}
+
+func (est *mfvAnalyticNoiseEstimator) MulScalar(n MFVNoise, scalar uint64) MFVNoise {
+	t := est.params.PlainModulus()
+	scalar %= t
+	if scalar > t>>1 {
+		scalar = t - scalar
+	}
+	logC := math.Log2(float64(scalar))
+	return MFVNoise{Level: n.Level, Worst: n.Worst + logC, Avg: n.Avg + logC}
+}
+
+func (est *mfvAnalyticNoiseEstimator) MulPlain(n MFVNoise) MFVNoise {
+	// The plaintext has coefficients in [0, t) of root mean square t/sqrt(3), and the noise has a root mean
+	// square of about its norm over sqrt(3). The average estimate assumes that the plaintext is encoded in
+	// the FV slots, i.e. that it has FVSlots non-zero coefficients.
+	return MFVNoise{
+		Level: n.Level,
+		Worst: n.Worst + est.logN + est.logT,
+		Avg:   n.Avg + float64(est.params.LogFVSlots())/2 + est.logT - math.Log2(3),
+	}
+}
+
+func (est *mfvAnalyticNoiseEstimator) Mul(n0, n1 MFVNoise) MFVNoise {
+	level := n0.Level
+	if n1.Level < level {
+		level = n1.Level
+	}
+
+	// t/Q * ct(s) = m + v + t*a with |m + t*a| <= t*(h+3)/2, and the product is
+	// (m0 + t*a0)*v1 + (m1 + t*a1)*v0 + v0*v1 + t/Q * (e0 + e1*s + e2*s^2)
+	h := math.Exp2(est.logS)
+	logMA := est.logT + math.Log2(h+3) - 1
+
+	worst := addLog(n0.Worst, n1.Worst) + est.logN + logMA
+	worst = addLog(worst, n0.Worst+n1.Worst+est.logN)
+	worst = addLog(worst, est.logT+math.Log2(1+h+h*h)-1-est.logQ[level])
+
+	// m + t*a has standard deviation t*sqrt((h+2)/12)
+	avg := addLogSquare(n0.Avg, n1.Avg) + est.logN/2 + est.logT + (math.Log2(h+2)-math.Log2(12))/2
+	avg = addLogSquare(avg, n0.Avg+n1.Avg+est.logN/2)
+	avg = addLogSquare(avg, est.logT+math.Log2(6)+(math.Log2(1+h+h*h)-math.Log2(12))/2-est.logQ[level])
+
+	return MFVNoise{Level: level, Worst: worst, Avg: avg}
+}
+
+func (est *mfvAnalyticNoiseEstimator) KeySwitch(n MFVNoise) MFVNoise {
+	// The key switching noise sum_j d_j*e_j is divided by P, and each digit d_j is smaller than P
+	worst, avg := est.rounding(n.Level)
+	B := 6 * est.sigma
+	digits := float64(est.nbDigit)
+	worst = addLog(worst, est.logT+math.Log2(digits*B)+est.logN-1-est.logQ[n.Level])
+	avg = addLogSquare(avg, est.logT+math.Log2(6*est.sigma*math.Sqrt(digits/12))+est.logN/2-est.logQ[n.Level])
+
+	return MFVNoise{Level: n.Level, Worst: addLog(n.Worst, worst), Avg: addLogSquare(n.Avg, avg)}
+}
+
+func (est *mfvAnalyticNoiseEstimator) LinearTransform(n MFVNoise, matrix *PtDiagMatrixT) MFVNoise {
+	// Each diagonal is multiplied by a rotation of the input, and the giant-step rotations
+	// add a key switching noise to the partial sums
+	diags := math.Log2(float64(len(matrix.Vec)))
+	rot := est.MulPlain(est.KeySwitch(n))
+	res := MFVNoise{Level: n.Level, Worst: rot.Worst + diags, Avg: rot.Avg + diags/2}
+	if !matrix.naive {
+		res = est.KeySwitch(res)
+	}
+	return res
+}
+
+func (est *mfvAnalyticNoiseEstimator) ModSwitch(n MFVNoise, nbModSwitch int) MFVNoise {
+	if nbModSwitch > n.Level {
+		panic(fmt.Sprintf("cannot ModSwitch: cannot drop %d moduli at level %d", nbModSwitch, n.Level))
+	}
+
+	for i := 0; i < nbModSwitch; i++ {
+		n.Level--
+		worst, avg := est.rounding(n.Level)
+		n.Worst = addLog(n.Worst, worst)
+		n.Avg = addLogSquare(n.Avg, avg)
+	}
+	return n
+}
+
+func (est *mfvAnalyticNoiseEstimator) Budget(n MFVNoise) int {
+	v := n.Avg
+	if est.worstCase {
+		v = n.Worst
+	}
+
+	budget := int(math.Floor(-v)) - 1
+	if budget < 0 {
+		budget = 0
+	}
+	return budget
+}
+
+func (est *mfvAnalyticNoiseEstimator) Noise(ct *Ciphertext) (MFVNoise, error) {
+	for _, n := range ct.noises {
+		if n.est == est && n.gen == est.gen {
+			return n.MFVNoise, nil
+		}
+	}
+	return MFVNoise{}, errors.New("the noise of the ciphertext is not tracked")
+}
+
+// noise returns the noise of a ciphertext, or a pessimistic noise without budget at the level of the
+// ciphertext if it is not tracked.
+func (est *mfvAnalyticNoiseEstimator) noise(ct *Ciphertext) MFVNoise {
+	n, err := est.Noise(ct)
+	if err != nil {
+		return MFVNoise{Level: ct.Level(), Worst: -1, Avg: -1}
+	}
+	return n
+}
+
+func (est *mfvAnalyticNoiseEstimator) SetNoise(ct *Ciphertext, n MFVNoise) {
+	for i := range ct.noises {
+		if ct.noises[i].est == est {
+			ct.noises[i] = elementNoise{est: est, gen: est.gen, MFVNoise: n}
+			return
+		}
+	}
+	ct.noises = append(ct.noises, elementNoise{est: est, gen: est.gen, MFVNoise: n})
+}
+
+func (est *mfvAnalyticNoiseEstimator) Reset() {
+	est.gen++
+}
+
+func (est *mfvAnalyticNoiseEstimator) InvariantNoiseBudget(ct *Ciphertext) int {
+	return est.Budget(est.noise(ct))
+}
+
+// operandNoise returns the noise of an operand: the tracked noise of a ciphertext, or the noise
+// of a plaintext scaled by Q/t.
+func (est *mfvAnalyticNoiseEstimator) operandNoise(op Operand) MFVNoise {
+	switch op := op.(type) {
+	case *Ciphertext:
+		return est.noise(op)
+	case *PlaintextRingT:
+		return est.Plaintext(est.params.MaxLevel())
+	default:
+		return est.Plaintext(op.Level())
+	}
+}
+
+// noiseTracker is implemented by the MFVEvaluators which track the noise of their ciphertexts (see
+// MFVAnalyticNoiseEstimator.Evaluator), so that the copies and the encryptions made along with them are tracked.
+type noiseTracker interface {
+	// CopyNew returns a copy of ct with the noise of ct.
+	CopyNew(ct *Ciphertext) *Ciphertext
+	// EncryptNew encrypts pt with encryptor and registers the noise of a fresh encryption.
+	EncryptNew(encryptor MFVEncryptor, pt *Plaintext) *Ciphertext
+}
+
+// copyNew returns a copy of ct, with the noise of ct if eval tracks the noise of its ciphertexts.
+func copyNew(eval MFVEvaluator, ct *Ciphertext) *Ciphertext {
+	if tracker, ok := eval.(noiseTracker); ok {
+		return tracker.CopyNew(ct)
+	}
+	return ct.CopyNew().Ciphertext()
+}
+
+// encryptNew encrypts pt with encryptor and, if eval tracks the noise of its ciphertexts, registers the
+// noise of a fresh encryption.
+func encryptNew(encryptor MFVEncryptor, eval MFVEvaluator, pt *Plaintext) *Ciphertext {
+	if tracker, ok := eval.(noiseTracker); ok {
+		return tracker.EncryptNew(encryptor, pt)
+	}
+	return encryptor.EncryptNew(pt)
+}
+
+func (est *mfvAnalyticNoiseEstimator) Evaluator(eval MFVEvaluator) MFVEvaluator {
+	return &mfvNoiseTrackingEvaluator{MFVEvaluator: eval, est: est}
+}
+
+// mfvNoiseTrackingEvaluator is an MFVEvaluator which registers the noise of the results of the operations
+// in an mfvAnalyticNoiseEstimator.
+type mfvNoiseTrackingEvaluator struct {
+	MFVEvaluator
+	est *mfvAnalyticNoiseEstimator
+}
+
+// CopyNew returns a copy of ct, with the noise of ct if it is tracked by the estimator (and by the estimators
+// of the wrapped evaluator).
+func (eval *mfvNoiseTrackingEvaluator) CopyNew(ct *Ciphertext) (ctOut *Ciphertext) {
+	ctOut = copyNew(eval.MFVEvaluator, ct)
+	if n, err := eval.est.Noise(ct); err == nil {
+		eval.est.SetNoise(ctOut, n)
+	}
+	return
+}
+
+// EncryptNew encrypts pt with encryptor and registers the noise of a fresh encryption in the estimator (and in
+// the estimators of the wrapped evaluator).
+func (eval *mfvNoiseTrackingEvaluator) EncryptNew(encryptor MFVEncryptor, pt *Plaintext) (ct *Ciphertext) {
+	ct = encryptNew(encryptor, eval.MFVEvaluator, pt)
+	eval.est.SetNoise(ct, eval.est.Fresh())
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) add(op0, op1 Operand) MFVNoise {
+	n := eval.est.Add(eval.est.operandNoise(op0), eval.est.operandNoise(op1))
+	n.Level = op0.Level()
+	if op1.Level() < n.Level {
+		n.Level = op1.Level()
+	}
+	return n
+}
+
+func (eval *mfvNoiseTrackingEvaluator) Add(op0, op1 Operand, ctOut *Ciphertext) {
+	n := eval.add(op0, op1)
+	eval.MFVEvaluator.Add(op0, op1, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) AddNew(op0, op1 Operand) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.AddNew(op0, op1)
+	eval.est.SetNoise(ctOut, eval.add(op0, op1))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) AddNoMod(op0, op1 Operand, ctOut *Ciphertext) {
+	n := eval.add(op0, op1)
+	eval.MFVEvaluator.AddNoMod(op0, op1, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) AddNoModNew(op0, op1 Operand) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.AddNoModNew(op0, op1)
+	eval.est.SetNoise(ctOut, eval.add(op0, op1))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) Sub(op0, op1 Operand, ctOut *Ciphertext) {
+	n := eval.add(op0, op1)
+	eval.MFVEvaluator.Sub(op0, op1, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SubNew(op0, op1 Operand) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.SubNew(op0, op1)
+	eval.est.SetNoise(ctOut, eval.add(op0, op1))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SubNoMod(op0, op1 Operand, ctOut *Ciphertext) {
+	n := eval.add(op0, op1)
+	eval.MFVEvaluator.SubNoMod(op0, op1, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SubNoModNew(op0, op1 Operand) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.SubNoModNew(op0, op1)
+	eval.est.SetNoise(ctOut, eval.add(op0, op1))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) Neg(op Operand, ctOut *Ciphertext) {
+	n := eval.est.operandNoise(op)
+	eval.MFVEvaluator.Neg(op, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) NegNew(op Operand) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.NegNew(op)
+	eval.est.SetNoise(ctOut, eval.est.operandNoise(op))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) Reduce(op Operand, ctOut *Ciphertext) {
+	n := eval.est.operandNoise(op)
+	eval.MFVEvaluator.Reduce(op, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) ReduceNew(op Operand) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.ReduceNew(op)
+	eval.est.SetNoise(ctOut, eval.est.operandNoise(op))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) MulScalar(op Operand, scalar uint64, ctOut *Ciphertext) {
+	n := eval.est.MulScalar(eval.est.operandNoise(op), scalar)
+	eval.MFVEvaluator.MulScalar(op, scalar, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) MulScalarNew(op Operand, scalar uint64) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.MulScalarNew(op, scalar)
+	eval.est.SetNoise(ctOut, eval.est.MulScalar(eval.est.operandNoise(op), scalar))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) mul(op0 *Ciphertext, op1 Operand) MFVNoise {
+	n0 := eval.est.noise(op0)
+	switch op1 := op1.(type) {
+	case *Ciphertext, *Plaintext:
+		return eval.est.Mul(n0, eval.est.operandNoise(op1))
+	default:
+		return eval.est.MulPlain(n0)
+	}
+}
+
+func (eval *mfvNoiseTrackingEvaluator) Mul(op0 *Ciphertext, op1 Operand, ctOut *Ciphertext) {
+	n := eval.mul(op0, op1)
+	eval.MFVEvaluator.Mul(op0, op1, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) MulNew(op0 *Ciphertext, op1 Operand) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.MulNew(op0, op1)
+	eval.est.SetNoise(ctOut, eval.mul(op0, op1))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) Relinearize(ct0 *Ciphertext, ctOut *Ciphertext) {
+	n := eval.est.KeySwitch(eval.est.noise(ct0))
+	eval.MFVEvaluator.Relinearize(ct0, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) RelinearizeNew(ct0 *Ciphertext) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.RelinearizeNew(ct0)
+	eval.est.SetNoise(ctOut, eval.est.KeySwitch(eval.est.noise(ct0)))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SwitchKeys(ct0 *Ciphertext, switchKey *SwitchingKey, ctOut *Ciphertext) {
+	n := eval.est.KeySwitch(eval.est.noise(ct0))
+	eval.MFVEvaluator.SwitchKeys(ct0, switchKey, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SwitchKeysNew(ct0 *Ciphertext, switchKey *SwitchingKey) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.SwitchKeysNew(ct0, switchKey)
+	eval.est.SetNoise(ctOut, eval.est.KeySwitch(eval.est.noise(ct0)))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) RotateColumns(ct0 *Ciphertext, k int, ctOut *Ciphertext) {
+	n := eval.est.KeySwitch(eval.est.noise(ct0))
+	eval.MFVEvaluator.RotateColumns(ct0, k, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) RotateColumnsNew(ct0 *Ciphertext, k int) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.RotateColumnsNew(ct0, k)
+	eval.est.SetNoise(ctOut, eval.est.KeySwitch(eval.est.noise(ct0)))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) RotateRows(ct0 *Ciphertext, ctOut *Ciphertext) {
+	n := eval.est.KeySwitch(eval.est.noise(ct0))
+	eval.MFVEvaluator.RotateRows(ct0, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) RotateRowsNew(ct0 *Ciphertext) (ctOut *Ciphertext) {
+	ctOut = eval.MFVEvaluator.RotateRowsNew(ct0)
+	eval.est.SetNoise(ctOut, eval.est.KeySwitch(eval.est.noise(ct0)))
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) InnerSum(ct0 *Ciphertext, ctOut *Ciphertext) {
+	// Each of the log2(FVSlots/2) steps adds a rotation of the partial sum, and the rows are summed last
+	n := eval.est.noise(ct0)
+	for i := 1; i < eval.est.params.FVSlots(); i <<= 1 {
+		n = eval.est.Add(n, eval.est.KeySwitch(n))
+	}
+	eval.MFVEvaluator.InnerSum(ct0, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) ModSwitch(ct0, ctOut *Ciphertext) {
+	eval.ModSwitchMany(ct0, ctOut, 1)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) ModSwitchMany(ct0, ctOut *Ciphertext, nbModSwitch int) {
+	n := eval.est.ModSwitch(eval.est.noise(ct0), nbModSwitch)
+	eval.MFVEvaluator.ModSwitchMany(ct0, ctOut, nbModSwitch)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) TransformToNTT(ct0, ctOut *Ciphertext) {
+	n := eval.est.noise(ct0)
+	eval.MFVEvaluator.TransformToNTT(ct0, ctOut)
+	eval.est.SetNoise(ctOut, n)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) LinearTransform(vec *Ciphertext, linearTransform interface{}) (res []*Ciphertext) {
+	n := eval.est.noise(vec)
+	res = eval.MFVEvaluator.LinearTransform(vec, linearTransform)
+
+	switch element := linearTransform.(type) {
+	case []*PtDiagMatrixT:
+		for i, matrix := range element {
+			eval.est.SetNoise(res[i], eval.est.LinearTransform(n, matrix))
+		}
+	case *PtDiagMatrixT:
+		eval.est.SetNoise(res[0], eval.est.LinearTransform(n, element))
+	}
+	return
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SlotsToCoeffs(ct *Ciphertext, stcModDown []int) (ctOut *Ciphertext) {
+	return slotsToCoeffs(eval, eval.pDcds(), ct, stcModDown)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SlotsToCoeffsNoModSwitch(ct *Ciphertext) (ctOut *Ciphertext) {
+	return slotsToCoeffsNoModSwitch(eval, eval.pDcds(), ct)
+}
+
+func (eval *mfvNoiseTrackingEvaluator) SlotsToCoeffsAutoModSwitch(ct *Ciphertext, noiseEstimator MFVNoiseEstimator) (ctOut *Ciphertext, stcModDown []int) {
+	return slotsToCoeffsAutoModSwitch(eval, eval.pDcds(), eval.est.params, ct, noiseEstimator)
+}
+
+// pDcds returns the SlotsToCoeffs matrices of the underlying evaluator.
+func (eval *mfvNoiseTrackingEvaluator) pDcds() [][]*PtDiagMatrixT {
+	switch ev := eval.MFVEvaluator.(type) {
+	case *mfvEvaluator:
+		return ev.pDcds
+	case *mfvNoiseTrackingEvaluator:
+		return ev.pDcds()
+	}
+	return nil
+}
+
+func (eval *mfvNoiseTrackingEvaluator) ShallowCopy() MFVEvaluator {
+	return &mfvNoiseTrackingEvaluator{MFVEvaluator: eval.MFVEvaluator.ShallowCopy(), est: eval.est}
+}
+
+func (eval *mfvNoiseTrackingEvaluator) WithKey(evaluationKey EvaluationKey) MFVEvaluator {
+	return &mfvNoiseTrackingEvaluator{MFVEvaluator: eval.MFVEvaluator.WithKey(evaluationKey), est: eval.est}
+}
@@ -0,0 +1,235 @@
+package ckks_fv
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/ldsec/lattigo/v2/ring"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testAnalyticNoiseTolerance is the maximum gap, in bits, between the average-case budget of the analytic
+// noise estimator and the budget measured with the secret key. The average case bounds the infinity norm by
+// six standard deviations of a sum of independent terms, which is within 2 bits of the measured budget for
+// every operation below but the square of a product: the noise of a product is correlated with its message,
+// so that the average case overestimates the budget of its square by 2 to 7 bits, depending on the samples.
+const testAnalyticNoiseTolerance = 8
+
+// testInvariantNoiseBudget returns the budget floor(log2(Q/||v||)) - 1 of the invariant noise v of ct, where
+// Q*v = t*(c0 + c1*s) mod Q is computed exactly with the secret key. MFVNoiseEstimator.InvariantNoiseBudget
+// is not used as it counts the significant bits of Q and of ||v|| heuristically.
+func testInvariantNoiseBudget(params *Parameters, sk *SecretKey, ct *Ciphertext) int {
+	ringQ, err := ring.NewRing(params.N(), params.Qi()[:ct.Level()+1])
+	if err != nil {
+		panic(err)
+	}
+
+	v, tmp := ringQ.NewPoly(), ringQ.NewPoly()
+	ringQ.NTTLazy(ct.Value()[1], v)
+	ringQ.MulCoeffsMontgomery(v, sk.Value, v)
+	ringQ.NTTLazy(ct.Value()[0], tmp)
+	ringQ.Add(v, tmp, v)
+	ringQ.Reduce(v, v)
+	ringQ.InvNTT(v, v)
+	ringQ.MulScalar(v, params.PlainModulus(), v)
+
+	coeffs := make([]*big.Int, params.N())
+	ringQ.PolyToBigint(v, coeffs)
+
+	Q := ringQ.ModulusBigint
+	qHalf := new(big.Int).Rsh(Q, 1)
+	norm := new(big.Int)
+	for _, c := range coeffs {
+		if c.Cmp(qHalf) > 0 {
+			c.Sub(Q, c)
+		}
+		if c.Cmp(norm) > 0 {
+			norm.Set(c)
+		}
+	}
+	if norm.Sign() == 0 {
+		return math.MaxInt32
+	}
+
+	budget := int(math.Floor(log2Big(Q)-log2Big(norm))) - 1
+	if budget < 0 {
+		budget = 0
+	}
+	return budget
+}
+
+func TestMFVAnalyticNoiseEstimator(t *testing.T) {
+	params, _ := genTestParams(genTestHalfBootParams(RtFHeraParams[1]))
+	hbtpParams := genTestHalfBootParams(RtFHeraParams[1])
+
+	encoder := NewMFVEncoder(params)
+	pDcds := encoder.GenSlotToCoeffMatFV(2)
+
+	kgen := NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairSparse(hbtpParams.H)
+	rotations := append(kgen.GenRotationIndexesForSlotsToCoeffsMat(pDcds), 1)
+	rtks := kgen.GenRotationKeysForRotations(rotations, true, sk)
+	rlk := kgen.GenRelinearizationKey(sk)
+
+	encryptor := NewMFVEncryptorFromPk(params, pk)
+	worstEstimator := NewMFVAnalyticNoiseEstimator(params, hbtpParams.H, true)
+	avgEstimator := NewMFVAnalyticNoiseEstimator(params, hbtpParams.H, false)
+
+	// Both estimators track the noise of the same operations
+	eval := worstEstimator.Evaluator(avgEstimator.Evaluator(NewMFVEvaluator(params, EvaluationKey{Rlk: rlk, Rtks: rtks}, pDcds)))
+
+	coeffs := newTestKey(params.FVSlots(), params.PlainModulus())
+	pt := NewPlaintextFV(params)
+	encoder.EncodeUintSmall(coeffs, pt)
+	ptMul := NewPlaintextMul(params)
+	encoder.EncodeUintMulSmall(coeffs, ptMul)
+
+	verify := func(t *testing.T, ct *Ciphertext) {
+		budget := testInvariantNoiseBudget(params, sk, ct)
+		worst := worstEstimator.InvariantNoiseBudget(ct)
+		avg := avgEstimator.InvariantNoiseBudget(ct)
+		if testing.Verbose() {
+			t.Logf("level %d budget %d, worst-case estimate %d, average estimate %d", ct.Level(), budget, worst, avg)
+		}
+		require.LessOrEqual(t, worst, budget)
+		require.InDelta(t, budget, avg, testAnalyticNoiseTolerance)
+	}
+
+	ct := encryptor.EncryptNew(pt)
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/Untracked/", params), func(t *testing.T) {
+		// The noise of a ciphertext which is not registered is unknown, and it has no budget left
+		_, err := worstEstimator.Noise(ct)
+		require.Error(t, err)
+		require.Equal(t, 0, worstEstimator.InvariantNoiseBudget(ct))
+		require.Equal(t, 0, avgEstimator.InvariantNoiseBudget(eval.AddNew(ct, ct)))
+	})
+
+	worstEstimator.SetNoise(ct, worstEstimator.Fresh())
+	avgEstimator.SetNoise(ct, avgEstimator.Fresh())
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/Fresh/", params), func(t *testing.T) {
+		verify(t, ct)
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/Copy/", params), func(t *testing.T) {
+		// The copies and the encryptions made along with the noise-tracking evaluator are tracked by both
+		// estimators, unlike the copies made with Ciphertext.CopyNew
+		verify(t, copyNew(eval, ct))
+		verify(t, encryptNew(encryptor, eval, pt))
+		_, err := worstEstimator.Noise(ct.CopyNew().Ciphertext())
+		require.Error(t, err)
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/Add/", params), func(t *testing.T) {
+		verify(t, eval.AddNew(ct, ct))
+		verify(t, eval.SubNew(ct, eval.MulScalarNew(ct, 2)))
+		verify(t, eval.AddNew(ct, pt))
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/MulScalar/", params), func(t *testing.T) {
+		verify(t, eval.MulScalarNew(ct, params.PlainModulus()/3))
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/MulPlain/", params), func(t *testing.T) {
+		verify(t, eval.MulNew(ct, ptMul))
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/Mul/", params), func(t *testing.T) {
+		res := eval.RelinearizeNew(eval.MulNew(ct, ct))
+		verify(t, res)
+		verify(t, eval.RelinearizeNew(eval.MulNew(res, res)))
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/Rotate/", params), func(t *testing.T) {
+		verify(t, eval.RotateColumnsNew(ct, 1))
+		verify(t, eval.RotateRowsNew(ct))
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/ModSwitch/", params), func(t *testing.T) {
+		res := eval.RelinearizeNew(eval.MulNew(ct, ct))
+		eval.ModSwitch(res, res)
+		verify(t, res)
+		eval.ModSwitchMany(res, res, 2)
+		verify(t, res)
+
+		n, err := worstEstimator.Noise(res)
+		require.NoError(t, err)
+		require.Panics(t, func() { worstEstimator.ModSwitch(n, n.Level+1) })
+	})
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/SlotsToCoeffs/", params), func(t *testing.T) {
+		verify(t, eval.SlotsToCoeffsNoModSwitch(ct))
+	})
+}
+
+// TestMFVAnalyticNoiseEstimatorAutoModSwitch checks that the modulus switching schedule chosen by
+// CryptAutoModSwitch and SlotsToCoeffsAutoModSwitch from the analytic estimator, without the secret key,
+// drops moduli and keeps the keystream decryptable.
+func TestMFVAnalyticNoiseEstimatorAutoModSwitch(t *testing.T) {
+	hbtpParams := genTestHalfBootParams(RtFHeraParams[1])
+	params, _ := genTestParams(hbtpParams)
+	nbInitModDown := HeraModDownParams80[1].CipherModDown[0]
+
+	t.Run(testString("MFVAnalyticNoiseEstimator/AutoModSwitch/", params), func(t *testing.T) {
+		encoder := NewMFVEncoder(params)
+		pDcds := encoder.GenSlotToCoeffMatFV(2)
+
+		kgen := NewKeyGenerator(params)
+		sk, pk := kgen.GenKeyPairSparse(hbtpParams.H)
+		rtks := kgen.GenRotationKeysForRotations(kgen.GenRotationIndexesForSlotsToCoeffsMat(pDcds), true, sk)
+		rlk := kgen.GenRelinearizationKey(sk)
+
+		// The secret key is only used below to check the results
+		estimator := NewMFVAnalyticNoiseEstimator(params, hbtpParams.H, true)
+		eval := estimator.Evaluator(NewMFVEvaluator(params, EvaluationKey{Rlk: rlk, Rtks: rtks}, pDcds))
+		encryptor := NewMFVEncryptorFromPk(params, pk)
+		decryptor := NewMFVDecryptor(params, sk)
+
+		key := newTestKey(16, params.PlainModulus())
+		nonces := newTestNonces(params.FVSlots())
+		keystream := NewHera(4, key, params.PlainModulus()).KeyStream(nonces)
+
+		mfvHera := NewMFVHera(4, params, encoder, encryptor, eval, nbInitModDown)
+		fvKeystreams, heraModDown := mfvHera.CryptAutoModSwitch(nonces, mfvHera.EncKey(key), estimator)
+		require.Len(t, heraModDown, 5)
+		require.Equal(t, nbInitModDown, heraModDown[0])
+
+		dropped := 0
+		for _, nb := range heraModDown {
+			require.GreaterOrEqual(t, nb, 0)
+			dropped += nb
+		}
+		require.Equal(t, params.MaxLevel()-dropped, fvKeystreams[0].Level())
+
+		for s := range fvKeystreams {
+			require.LessOrEqual(t, estimator.InvariantNoiseBudget(fvKeystreams[s]), testInvariantNoiseBudget(params, sk, fvKeystreams[s]))
+			have := encoder.DecodeUintSmallNew(decryptor.DecryptNew(fvKeystreams[s]))
+			for i := 0; i < params.FVSlots(); i++ {
+				require.Equal(t, keystream[i][s], have[i], "state %d, slot %d", s, i)
+			}
+		}
+
+		want := encoder.DecodeUintNew(decryptor.DecryptNew(eval.SlotsToCoeffsNoModSwitch(fvKeystreams[0])))
+		ct, stcModDown := eval.SlotsToCoeffsAutoModSwitch(fvKeystreams[0], estimator)
+		require.Len(t, stcModDown, params.LogFVSlots()/2)
+		for _, nb := range stcModDown {
+			dropped += nb
+		}
+		require.Equal(t, params.MaxLevel()-dropped, ct.Level())
+		require.Greater(t, dropped, nbInitModDown)
+
+		budget := estimator.InvariantNoiseBudget(ct)
+		require.Greater(t, budget, 0)
+		require.LessOrEqual(t, budget, testInvariantNoiseBudget(params, sk, ct))
+		require.Equal(t, want, encoder.DecodeUintNew(decryptor.DecryptNew(ct)))
+
+		// After a reset, the estimator no longer knows the noise of the ciphertexts it tracked
+		estimator.Reset()
+		_, err := estimator.Noise(ct)
+		require.Error(t, err)
+		require.Equal(t, 0, estimator.InvariantNoiseBudget(ct))
+	})
+}
@@ -57,6 +57,7 @@ type MFVEncoder interface {
 
 	EncodeDiagMatrixT(level int, vector map[int][]uint64, maxM1N2Ratio float64, logSlots int) (matrix *PtDiagMatrixT)
 	GenSlotToCoeffMatFV(radix int) (pDcds [][]*PtDiagMatrixT)
+	GenSlotToCoeffMatFVFromPlan(plan *StCPlan) (pDcds [][]*PtDiagMatrixT)
 
 	EncodeUintRingTSmall(coeffs []uint64, pt *PlaintextRingT)
 	EncodeUintMulSmall(coeffs []uint64, pt *PlaintextMul)
@@ -627,6 +628,30 @@ func (encoder *mfvEncoder) GenSlotToCoeffMatFV(radix int) (pDcds [][]*PtDiagMatr
 	return
 }
 
+// GenSlotToCoeffMatFVFromPlan generates the factorized decoding matrix for FV scheme with the split and
+// the maxN1N2Ratio of each matrix given by the plan (see PlanSlotsToCoeffs).
+func (encoder *mfvEncoder) GenSlotToCoeffMatFVFromPlan(plan *StCPlan) (pDcds [][]*PtDiagMatrixT) {
+	params := encoder.params
+
+	if plan.LogFVSlots != params.logFVSlots {
+		panic("cannot GenSlotToCoeffMatFVFromPlan: plan LogFVSlots should be the LogFVSlots of the parameters")
+	}
+
+	modCount := len(params.qi)
+	pDcds = make([][]*PtDiagMatrixT, modCount)
+
+	pVecDcd := genDcdMatsSplit(params.logFVSlots, params.plainModulus, plan.Split)
+	for level := 0; level < modCount; level++ {
+		pDcds[level] = make([]*PtDiagMatrixT, len(pVecDcd))
+
+		for i := 0; i < len(pDcds[level]); i++ {
+			pDcds[level][i] = encoder.EncodeDiagMatrixT(level, pVecDcd[i], plan.Ratios[i], params.logFVSlots)
+		}
+	}
+
+	return
+}
+
 // genDcdMats generates decoding matrix that is factorized into sparse block diagonal matrices with radix 1
 func genDcdMats(logSlots int, plainModulus uint64) (plainVector []map[int][]uint64) {
 	roots := computePrimitiveRoots(1<<(logSlots+1), plainModulus)
@@ -680,6 +705,32 @@ func genDcdMatsInOne(logSlots int, plainModulus uint64) (plainVector []map[int][
 	return
 }
 
+// genDcdMatsSplit generates decoding matrix in which split[i] consecutive sparse block diagonal matrices are
+// merged into the i-th matrix, and the matrices of the last group are merged into the last two matrices
+func genDcdMatsSplit(logSlots int, plainModulus uint64, split []int) (plainVector []map[int][]uint64) {
+	roots := computePrimitiveRoots(1<<(logSlots+1), plainModulus)
+	diabMats := genDcdDiabDecomp(logSlots, roots)
+	depth := len(diabMats) - 1
+
+	plainVector = make([]map[int][]uint64, len(split)+1)
+	start := 0
+	for i, size := range split {
+		tmp := diabMats[start]
+		for j := start + 1; j < start+size; j++ {
+			tmp = multDiabMats(diabMats[j], tmp, plainModulus)
+		}
+		start += size
+
+		if i < len(split)-1 {
+			plainVector[i] = tmp
+		} else {
+			plainVector[i] = multDiabMats(diabMats[depth-1], tmp, plainModulus)
+			plainVector[i+1] = multDiabMats(diabMats[depth], tmp, plainModulus)
+		}
+	}
+	return
+}
+
 func multDiabMats(A map[int][]uint64, B map[int][]uint64, plainModulus uint64) (res map[int][]uint64) {
 	res = make(map[int][]uint64)
 
@@ -691,13 +742,13 @@ func multDiabMats(A map[int][]uint64, B map[int][]uint64, plainModulus uint64) (
 			}
 
 			for i := 0; i < N/2; i++ {
-				res[(rotA+rotB)%(N/2)][i] += A[rotA][i] * B[rotB][(rotA+i)%(N/2)]
+				res[(rotA+rotB)%(N/2)][i] += mulMod(A[rotA][i], B[rotB][(rotA+i)%(N/2)], plainModulus)
 				res[(rotA+rotB)%(N/2)][i] %= plainModulus
 
 			}
 
 			for i := N / 2; i < N; i++ {
-				res[(rotA+rotB)%(N/2)][i] += A[rotA][i] * B[rotB][N/2+(rotA+i)%(N/2)]
+				res[(rotA+rotB)%(N/2)][i] += mulMod(A[rotA][i], B[rotB][N/2+(rotA+i)%(N/2)], plainModulus)
 				res[(rotA+rotB)%(N/2)][i] %= plainModulus
 			}
 		}
@@ -810,7 +861,7 @@ func computePrimitiveRoots(M int, plainModulus uint64) (roots []uint64) {
 	roots = make([]uint64, M)
 	roots[0] = 1
 	for i := 1; i < M; i++ {
-		roots[i] = (roots[i-1] * w) % plainModulus
+		roots[i] = mulMod(roots[i-1], w, plainModulus)
 	}
 	return
 }
@@ -255,6 +255,7 @@ func (eval *mfvEvaluator) ShallowCopy() MFVEvaluator {
 		baseconverterQ1P:    eval.baseconverterQ1P.ShallowCopy(),
 		rlk:                 eval.rlk,
 		rtks:                eval.rtks,
+		permuteNTTIndex:     eval.permuteNTTIndex,
 		pDcds:               eval.pDcds,
 	}
 }
@@ -1167,8 +1168,7 @@ func (eval *mfvEvaluator) permuteNTTHoistedNoModDown(level int, c2QiQDecomp, c2Q
 
 	rtk, generated := eval.rtks.Keys[galEl]
 	if !generated {
-		fmt.Println(k)
-		panic("switching key not available")
+		panic(fmt.Sprintf("switching key not available for rotation %d", k))
 	}
 	index := eval.permuteNTTIndex[galEl]
 
@@ -1349,10 +1349,13 @@ func (eval *mfvEvaluator) ModSwitchMany(ct0, ctOut *Ciphertext, nbModSwitch int)
 		panic("cannot ModSwitchMany: input and output should have the same degree")
 	}
 
+	// The moduli are dropped one at a time in place, as the buffer of ringQ is shared by the shallow copies of the evaluator
 	ringQ := eval.ringQ
 	for i := range ct0.value {
-		ringQ.DivRoundByLastModulusMany(ct0.value[i], ctOut.value[i], nbModSwitch)
-		ctOut.value[i].Coeffs = ctOut.value[i].Coeffs[:level+1-nbModSwitch]
+		ringQ.DivRoundByLastModulus(ct0.value[i], ctOut.value[i])
+		for j := 1; j < nbModSwitch; j++ {
+			ringQ.DivRoundByLastModulus(ctOut.value[i], ctOut.value[i])
+		}
 	}
 }
 
@@ -1377,28 +1380,33 @@ func (eval *mfvEvaluator) TransformToNTT(ct0 *Ciphertext, ctOut *Ciphertext) {
 // SlotsToCoeffs returns ctOut whose coefficients are data stored in slots of ct
 // with dropping modulus as given in stcModDown
 func (eval *mfvEvaluator) SlotsToCoeffs(ct *Ciphertext, stcModDown []int) (ctOut *Ciphertext) {
-	if eval.pDcds == nil {
+	return slotsToCoeffs(eval, eval.pDcds, ct, stcModDown)
+}
+
+// slotsToCoeffs evaluates SlotsToCoeffs with the given evaluator and StC matrices.
+func slotsToCoeffs(eval MFVEvaluator, pDcds [][]*PtDiagMatrixT, ct *Ciphertext, stcModDown []int) (ctOut *Ciphertext) {
+	if pDcds == nil {
 		panic("cannot SlotsToCoeffs: evaluator does not have StC matrices")
 	}
 
-	ctOut = ct.CopyNew().Ciphertext()
+	ctOut = copyNew(eval, ct)
 
 	level := ctOut.Level()
-	depth := len(eval.pDcds[level]) - 1
+	depth := len(pDcds[level]) - 1
 	for i := 0; i < depth-1; i++ {
 		if stcModDown[i] > 0 {
 			eval.ModSwitchMany(ctOut, ctOut, stcModDown[i])
 		}
 		level = ctOut.Level()
-		ctOut = eval.LinearTransform(ctOut, eval.pDcds[level][i])[0]
+		ctOut = eval.LinearTransform(ctOut, pDcds[level][i])[0]
 	}
 	if stcModDown[depth-1] > 0 {
 		eval.ModSwitchMany(ctOut, ctOut, stcModDown[depth-1])
 	}
 	level = ctOut.Level()
 	tmp := eval.RotateRowsNew(ctOut)
-	ctOut = eval.LinearTransform(ctOut, eval.pDcds[level][depth-1])[0]
-	tmp = eval.LinearTransform(tmp, eval.pDcds[level][depth])[0]
+	ctOut = eval.LinearTransform(ctOut, pDcds[level][depth-1])[0]
+	tmp = eval.LinearTransform(tmp, pDcds[level][depth])[0]
 
 	ctOut = eval.AddNew(tmp, ctOut)
 	return
@@ -1407,99 +1415,145 @@ func (eval *mfvEvaluator) SlotsToCoeffs(ct *Ciphertext, stcModDown []int) (ctOut
 // SlotsToCoeffsNoModSwitch returns ctOut whose coefficients are data stored in slots of ct
 // without modulus switching
 func (eval *mfvEvaluator) SlotsToCoeffsNoModSwitch(ct *Ciphertext) (ctOut *Ciphertext) {
-	if eval.pDcds == nil {
+	return slotsToCoeffsNoModSwitch(eval, eval.pDcds, ct)
+}
+
+// slotsToCoeffsNoModSwitch evaluates SlotsToCoeffsNoModSwitch with the given evaluator and StC matrices.
+func slotsToCoeffsNoModSwitch(eval MFVEvaluator, pDcds [][]*PtDiagMatrixT, ct *Ciphertext) (ctOut *Ciphertext) {
+	if pDcds == nil {
 		panic("cannot SlotsToCoeffs: evaluator does not have StC matrices")
 	}
 
-	ctOut = ct.CopyNew().Ciphertext()
+	ctOut = copyNew(eval, ct)
 
 	level := ct.Level()
-	depth := len(eval.pDcds[level]) - 1
+	depth := len(pDcds[level]) - 1
 	for i := 0; i < depth-1; i++ {
-		ctOut = eval.LinearTransform(ctOut, eval.pDcds[level][i])[0]
+		ctOut = eval.LinearTransform(ctOut, pDcds[level][i])[0]
 	}
 
 	tmp := eval.RotateRowsNew(ctOut)
-	ctOut = eval.LinearTransform(ctOut, eval.pDcds[level][depth-1])[0]
-	tmp = eval.LinearTransform(tmp, eval.pDcds[level][depth])[0]
+	ctOut = eval.LinearTransform(ctOut, pDcds[level][depth-1])[0]
+	tmp = eval.LinearTransform(tmp, pDcds[level][depth])[0]
 
 	ctOut = eval.AddNew(tmp, ctOut)
 	return
 }
 
-func (eval *mfvEvaluator) findBudgetInfo(ct *Ciphertext, noiseEstimator MFVNoiseEstimator) (invBudget, errorBits int) {
-	plainModulus := ring.NewUint(eval.params.PlainModulus())
-	invBudget = noiseEstimator.InvariantNoiseBudget(ct)
-	errorBits = eval.params.LogQLvl(ct.Level()) - plainModulus.BitLen() - invBudget
+// findBudgetInfo returns the largest invariant noise budget of the ciphertexts cts, and the number of bits of
+// the error of the ciphertext with this budget.
+func findBudgetInfo(params *Parameters, cts []*Ciphertext, noiseEstimator MFVNoiseEstimator) (maxInvBudget, minErrorBits int) {
+	plainModulus := ring.NewUint(params.PlainModulus())
+	for _, ct := range cts {
+		invBudget := noiseEstimator.InvariantNoiseBudget(ct)
+		errorBits := params.LogQLvl(ct.Level()) - plainModulus.BitLen() - invBudget
+
+		if invBudget > maxInvBudget {
+			maxInvBudget = invBudget
+			minErrorBits = errorBits
+		}
+	}
 	return
 }
 
-func (eval *mfvEvaluator) modSwitchAuto(ct *Ciphertext, noiseEstimator MFVNoiseEstimator, depth int, stcModDown []int) {
-	lvl := ct.Level()
+// modSwitchRule is a rule of findModSwitchAuto: the moduli are dropped while the error exceeds their total size,
+// measured by logQi, by more than margin bits. If stepBack is set, one modulus less is dropped when the budget
+// then decreases by more than 3 bits.
+type modSwitchRule struct {
+	logQi    func(qi uint64) int
+	margin   int
+	stepBack bool
+}
+
+// cipherModSwitchRule is the rule of the ciphers.
+var cipherModSwitchRule = modSwitchRule{logQi: bitLenQi, margin: 0, stepBack: true}
 
-	QiLvl := eval.params.Qi()[:lvl+1]
+// stcModSwitchRule is the rule of SlotsToCoeffs. It never steps back, as the original SlotsToCoeffs measured
+// the budget on the ciphertext before the modulus switching.
+var stcModSwitchRule = modSwitchRule{logQi: roundLog2Qi, margin: 1, stepBack: false}
+
+// findModSwitchAuto returns the number of moduli which can be dropped from the ciphertexts cts, which are at the
+// same level, following the rule.
+// The budget after the modulus switching is measured on a copy of cts[0], so that cts are left unchanged.
+func findModSwitchAuto(eval MFVEvaluator, params *Parameters, cts []*Ciphertext, noiseEstimator MFVNoiseEstimator, rule modSwitchRule) (nbModSwitch int) {
+	lvl := cts[0].Level()
+
+	QiLvl := params.Qi()[:lvl+1]
 	LogQiLvl := make([]int, lvl+1)
 	for i := 0; i < lvl+1; i++ {
-		LogQiLvl[i] = int(math.Round(math.Log2(float64(QiLvl[i]))))
+		LogQiLvl[i] = rule.logQi(QiLvl[i])
 	}
 
-	invBudgetOld, errorBitsOld := eval.findBudgetInfo(ct, noiseEstimator)
-	nbModSwitch, targetErrorBits := 0, errorBitsOld
-	for {
+	invBudgetOld, targetErrorBits := findBudgetInfo(params, cts, noiseEstimator)
+	for nbModSwitch < lvl {
 		targetErrorBits -= LogQiLvl[lvl-nbModSwitch]
-		if targetErrorBits > 1 {
+		if targetErrorBits > rule.margin {
 			nbModSwitch++
 		} else {
 			break
 		}
 	}
 
-	if nbModSwitch != 0 {
-		tmp := ct.CopyNew().Ciphertext()
-		eval.ModSwitchMany(ct, ct, nbModSwitch)
-		invBudgetNew, _ := eval.findBudgetInfo(tmp, noiseEstimator)
+	if rule.stepBack && nbModSwitch != 0 {
+		tmp := copyNew(eval, cts[0])
+		eval.ModSwitchMany(tmp, tmp, nbModSwitch)
+		trial := append([]*Ciphertext{tmp}, cts[1:]...)
+		invBudgetNew, _ := findBudgetInfo(params, trial, noiseEstimator)
 
 		if invBudgetOld-invBudgetNew > 3 {
 			nbModSwitch--
 		}
-		ct = tmp
 	}
+	return
+}
 
-	if nbModSwitch != 0 {
+// bitLenQi returns the bit length of the modulus qi.
+func bitLenQi(qi uint64) int {
+	return ring.NewUint(qi).BitLen()
+}
+
+// roundLog2Qi returns the rounded log2 of the modulus qi.
+func roundLog2Qi(qi uint64) int {
+	return int(math.Round(math.Log2(float64(qi))))
+}
+
+// modSwitchAuto drops the moduli found by findModSwitchAuto with the SlotsToCoeffs rule from ct and records their
+// number in stcModDown[depth].
+func modSwitchAuto(eval MFVEvaluator, params *Parameters, ct *Ciphertext, noiseEstimator MFVNoiseEstimator, depth int, stcModDown []int) {
+	if nbModSwitch := findModSwitchAuto(eval, params, []*Ciphertext{ct}, noiseEstimator, stcModSwitchRule); nbModSwitch > 0 {
 		stcModDown[depth] = nbModSwitch
 		eval.ModSwitchMany(ct, ct, nbModSwitch)
-		invBudgetNew, errorBitsNew := eval.findBudgetInfo(ct, noiseEstimator)
-		fmt.Printf("StC Depth %d [Budget | Error] : [%v | %v] -> [%v | %v]\n", depth, invBudgetOld, errorBitsOld, invBudgetNew, errorBitsNew)
-		fmt.Printf("StC modDown : %v\n\n", stcModDown)
 	}
 }
 
 // SlotsToCoeffs returns ctOut whose coefficients are data stored in slots of ct
 // with automatic modulus switching as written in stcModDown
 func (eval *mfvEvaluator) SlotsToCoeffsAutoModSwitch(ct *Ciphertext, noiseEstimator MFVNoiseEstimator) (ctOut *Ciphertext, stcModDown []int) {
-	if eval.pDcds == nil {
+	return slotsToCoeffsAutoModSwitch(eval, eval.pDcds, eval.params, ct, noiseEstimator)
+}
+
+// slotsToCoeffsAutoModSwitch evaluates SlotsToCoeffsAutoModSwitch with the given evaluator and StC matrices.
+func slotsToCoeffsAutoModSwitch(eval MFVEvaluator, pDcds [][]*PtDiagMatrixT, params *Parameters, ct *Ciphertext, noiseEstimator MFVNoiseEstimator) (ctOut *Ciphertext, stcModDown []int) {
+	if pDcds == nil {
 		panic("cannot SlotsToCoeffs: evaluator does not have StC matrices")
 	}
 
-	ctOut = ct.CopyNew().Ciphertext()
+	ctOut = copyNew(eval, ct)
 	level := ct.Level()
-	depth := len(eval.pDcds[level]) - 1
+	depth := len(pDcds[level]) - 1
 
 	stcModDown = make([]int, depth)
 	for i := 0; i < depth-1; i++ {
-		eval.modSwitchAuto(ctOut, noiseEstimator, i, stcModDown)
+		modSwitchAuto(eval, params, ctOut, noiseEstimator, i, stcModDown)
 		level = ctOut.Level()
-		ctOut = eval.LinearTransform(ctOut, eval.pDcds[level][i])[0]
+		ctOut = eval.LinearTransform(ctOut, pDcds[level][i])[0]
 	}
-	eval.modSwitchAuto(ctOut, noiseEstimator, depth-1, stcModDown)
+	modSwitchAuto(eval, params, ctOut, noiseEstimator, depth-1, stcModDown)
 	level = ctOut.Level()
 	tmp := eval.RotateRowsNew(ctOut)
-	ctOut = eval.LinearTransform(ctOut, eval.pDcds[level][depth-1])[0]
-	tmp = eval.LinearTransform(tmp, eval.pDcds[level][depth])[0]
+	ctOut = eval.LinearTransform(ctOut, pDcds[level][depth-1])[0]
+	tmp = eval.LinearTransform(tmp, pDcds[level][depth])[0]
 
 	ctOut = eval.AddNew(tmp, ctOut)
-	invBudget, errorBits := eval.findBudgetInfo(ctOut, noiseEstimator)
-	fmt.Printf("StC Final [Budget | Error] : [%v | %v]\n", invBudget, errorBits)
-	fmt.Printf("StC modDown : %v\n\n", stcModDown)
 	return
 }
@@ -17,9 +17,12 @@ type Operand interface {
 
 // Element is a generic type for ciphertext and plaintexts
 type Element struct {
-	value []*ring.Poly
-	scale float64
-	isNTT bool
+	value  []*ring.Poly
+	scale  float64
+	isNTT  bool
+	isCKKS bool
+
+	noises []elementNoise // noise registered by the analytic noise estimators (see MFVAnalyticNoiseEstimator)
 }
 
 // NewElement returns a new Element with zero values.
@@ -87,6 +90,16 @@ func (el *Element) IsNTT() bool {
 	return el.isNTT
 }
 
+// IsCKKS returns true if the target element is a CKKS element, and false if it is an FV element.
+func (el *Element) IsCKKS() bool {
+	return el.isCKKS
+}
+
+// SetIsCKKS sets the scheme of the target element to CKKS if value is true, and to FV otherwise.
+func (el *Element) SetIsCKKS(value bool) {
+	el.isCKKS = value
+}
+
 // SetIsNTT sets the value of the NTT flag of the target element with the input value.
 func (el *Element) SetIsNTT(value bool) {
 	el.isNTT = value
@@ -149,6 +162,7 @@ func (el *Element) Copy(ctxCopy *Element) {
 func (el *Element) CopyParams(Element *Element) {
 	el.SetScale(Element.Scale())
 	el.SetIsNTT(Element.IsNTT())
+	el.SetIsCKKS(Element.IsCKKS())
 }
 
 // El sets the target element type to Element.
@@ -0,0 +1,217 @@
+package ckks_fv
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Pasta is an interface for the client-side (plaintext) PASTA stream cipher.
+// The keystream it produces is bit-identical to the one evaluated homomorphically by MFVPasta.
+type Pasta interface {
+	Crypt(nonce []byte, counter []byte) (keystream []uint64)
+	KeyStream(nonces [][]byte, counter []byte) (keystream [][]uint64)
+	Blocksize() int
+	KeySize() int
+	NumRound() int
+	PlainModulus() uint64
+}
+
+type plainPasta struct {
+	pastaParam   int
+	blocksize    int
+	numRound     int
+	plainModulus uint64
+	key          []uint64
+
+	state []uint64
+	buf   []uint64
+	layer *pastaAffineLayer
+}
+
+// NewPasta creates a new client-side PASTA cipher with the parameters PastaParams[pastaParam].
+// The key should consist of 2*Blocksize elements of Z_PlainModulus.
+func NewPasta(pastaParam int, key []uint64) Pasta {
+	if pastaParam < 0 || pastaParam >= len(PastaParams) {
+		panic(fmt.Sprintf("cannot NewPasta: invalid pastaParam %d", pastaParam))
+	}
+	if err := PastaParams[pastaParam].Validate(); err != nil {
+		panic(fmt.Sprintf("cannot NewPasta: %v", err))
+	}
+
+	pasta := new(plainPasta)
+	pasta.pastaParam = pastaParam
+	pasta.blocksize = PastaParams[pastaParam].Blocksize
+	pasta.numRound = PastaParams[pastaParam].NumRound
+	pasta.plainModulus = PastaParams[pastaParam].PlainModulus
+
+	if len(key) != 2*pasta.blocksize {
+		panic(fmt.Sprintf("cannot NewPasta: key should have %d elements but %d given", 2*pasta.blocksize, len(key)))
+	}
+
+	pasta.key = make([]uint64, 2*pasta.blocksize)
+	for i := range key {
+		if key[i] >= pasta.plainModulus {
+			panic("cannot NewPasta: key elements should be smaller than PlainModulus")
+		}
+		pasta.key[i] = key[i]
+	}
+
+	pasta.state = make([]uint64, 2*pasta.blocksize)
+	pasta.buf = make([]uint64, pasta.blocksize)
+	pasta.layer = newPastaAffineLayer(pasta.blocksize)
+	return pasta
+}
+
+// Blocksize returns the size of each half of the internal state, i.e. the number of keystream elements produced per nonce.
+func (pasta *plainPasta) Blocksize() int {
+	return pasta.blocksize
+}
+
+// KeySize returns the number of elements of the key, i.e. 2*Blocksize.
+func (pasta *plainPasta) KeySize() int {
+	return 2 * pasta.blocksize
+}
+
+// NumRound returns the number of rounds of the cipher.
+func (pasta *plainPasta) NumRound() int {
+	return pasta.numRound
+}
+
+// PlainModulus returns the modulus of the keystream elements.
+func (pasta *plainPasta) PlainModulus() uint64 {
+	return pasta.plainModulus
+}
+
+// KeyStream returns one keystream block of Blocksize elements per nonce.
+func (pasta *plainPasta) KeyStream(nonces [][]byte, counter []byte) (keystream [][]uint64) {
+	keystream = make([][]uint64, len(nonces))
+	for i := range nonces {
+		keystream[i] = pasta.Crypt(nonces[i], counter)
+	}
+	return
+}
+
+// Crypt returns the keystream block of Blocksize elements for the given nonce and counter.
+func (pasta *plainPasta) Crypt(nonce []byte, counter []byte) (keystream []uint64) {
+	t := pasta.plainModulus
+	state := pasta.state
+	copy(state, pasta.key)
+
+	xof := newPastaXOF(nonce, counter)
+
+	pasta.affineLayer(xof)
+	for r := 1; r <= pasta.numRound; r++ {
+		if r < pasta.numRound {
+			pasta.feistel(state[:pasta.blocksize])
+			pasta.feistel(state[pasta.blocksize:])
+		} else {
+			for i := range state {
+				state[i] = mulMod(mulMod(state[i], state[i], t), state[i], t)
+			}
+		}
+		pasta.affineLayer(xof)
+	}
+
+	keystream = make([]uint64, pasta.blocksize)
+	copy(keystream, state[:pasta.blocksize])
+	return
+}
+
+// affineLayer multiplies each half of the state by its random matrix, adds the round constants,
+// and mixes the two halves (x_L, x_R) -> (2x_L + x_R, x_L + 2x_R).
+func (pasta *plainPasta) affineLayer(xof sha3.ShakeHash) {
+	t := pasta.plainModulus
+	n := pasta.blocksize
+	layer := pasta.layer
+	layer.sample(xof, t)
+
+	for h := 0; h < 2; h++ {
+		half := pasta.state[h*n : (h+1)*n]
+		row := layer.row[h]
+		for i := 0; i < n; i++ {
+			layer.nextRow(h, i, t)
+			sum := layer.rc[h][i]
+			for j := 0; j < n; j++ {
+				sum = (sum + mulMod(row[j], half[j], t)) % t
+			}
+			pasta.buf[i] = sum
+		}
+		copy(half, pasta.buf)
+	}
+
+	for i := 0; i < n; i++ {
+		sum := (pasta.state[i] + pasta.state[i+n]) % t
+		pasta.state[i] = (pasta.state[i] + sum) % t
+		pasta.state[i+n] = (pasta.state[i+n] + sum) % t
+	}
+}
+
+// feistel computes x_i = x_i + x_{i-1}^2 for i > 0.
+func (pasta *plainPasta) feistel(half []uint64) {
+	t := pasta.plainModulus
+	for i := len(half) - 1; i > 0; i-- {
+		half[i] = (half[i] + mulMod(half[i-1], half[i-1], t)) % t
+	}
+}
+
+// newPastaXOF returns the extendable output function from which the matrices and round constants are sampled.
+func newPastaXOF(nonce []byte, counter []byte) sha3.ShakeHash {
+	xof := sha3.NewShake128()
+	xof.Write(nonce)
+	xof.Write(counter)
+	return xof
+}
+
+// pastaAffineLayer holds the randomness of an affine layer of PASTA for one nonce: the first rows of the
+// invertible matrices of the left and right halves, and their round constants.
+// The i-th row of a matrix is computed from the (i-1)-th row as row_i[j] = first[j]*row_{i-1}[n-1] + row_{i-1}[j-1],
+// so that the matrices never need to be stored.
+type pastaAffineLayer struct {
+	first [2][]uint64
+	row   [2][]uint64
+	rc    [2][]uint64
+}
+
+func newPastaAffineLayer(blocksize int) (layer *pastaAffineLayer) {
+	layer = new(pastaAffineLayer)
+	for h := 0; h < 2; h++ {
+		layer.first[h] = make([]uint64, blocksize)
+		layer.row[h] = make([]uint64, blocksize)
+		layer.rc[h] = make([]uint64, blocksize)
+	}
+	return
+}
+
+// sample reads the first rows (non-zero elements) then the round constants of the next affine layer from the xof.
+func (layer *pastaAffineLayer) sample(xof sha3.ShakeHash, t uint64) {
+	for h := 0; h < 2; h++ {
+		for j := range layer.first[h] {
+			layer.first[h][j] = SampleZqx(xof, t)
+			for layer.first[h][j] == 0 {
+				layer.first[h][j] = SampleZqx(xof, t)
+			}
+		}
+	}
+	for h := 0; h < 2; h++ {
+		for j := range layer.rc[h] {
+			layer.rc[h][j] = SampleZqx(xof, t)
+		}
+	}
+}
+
+// nextRow sets row[h] to the i-th row of the matrix of the half h, assuming that it holds the (i-1)-th row.
+func (layer *pastaAffineLayer) nextRow(h, i int, t uint64) {
+	first, row := layer.first[h], layer.row[h]
+	if i == 0 {
+		copy(row, first)
+		return
+	}
+
+	n := len(row)
+	last := row[n-1]
+	for j := n - 1; j > 0; j-- {
+		row[j] = (mulMod(first[j], last, t) + row[j-1]) % t
+	}
+	row[0] = mulMod(first[0], last, t)
+}
@@ -0,0 +1,189 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ldsec/lattigo/v2/ring"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPastaModDown is a modulus switching schedule of PASTA4 for RtFHeraParams[1] scaled down by
+// genTestHalfBootParams, as found by SearchModDownParams.
+var testPastaModDown = ModDownParams{CipherModDown: []int{14, 0, 2, 1, 2}, StCModDown: []int{1}}
+
+// testPastaLModDown is a modulus switching schedule of PASTA4L found in the same way.
+var testPastaLModDown = ModDownParams{CipherModDown: []int{12, 1, 1, 1, 3}, StCModDown: []int{1}}
+
+func TestPasta(t *testing.T) {
+	t.Run("Pasta/NewPasta/InvalidInputs/", func(t *testing.T) {
+		assert.Panics(t, func() { NewPasta(-1, make([]uint64, 64)) })
+		assert.Panics(t, func() { NewPasta(len(PastaParams), make([]uint64, 64)) })
+		assert.Panics(t, func() { NewPasta(PASTA4, make([]uint64, 32)) })
+		key := make([]uint64, 64)
+		key[3] = PastaParams[PASTA4].PlainModulus
+		assert.Panics(t, func() { NewPasta(PASTA4, key) })
+		assert.Panics(t, func() { NewMFVPasta(len(PastaParams), nil, nil, nil, nil, 0) })
+	})
+
+	t.Run("Pasta/PastaParam/Validate/", func(t *testing.T) {
+		for pastaParam := range PastaParams {
+			require.NoError(t, PastaParams[pastaParam].Validate())
+		}
+		valid := PastaParams[PASTA4]
+		for _, invalid := range []PastaParam{
+			{Blocksize: 0, PlainModulus: valid.PlainModulus, NumRound: valid.NumRound},
+			{Blocksize: valid.Blocksize, PlainModulus: valid.PlainModulus, NumRound: 0},
+			{Blocksize: valid.Blocksize, PlainModulus: 7681, NumRound: valid.NumRound},                   // 7680 is divisible by 3
+			{Blocksize: valid.Blocksize, PlainModulus: valid.PlainModulus + 2, NumRound: valid.NumRound}, // not a prime
+			{Blocksize: valid.Blocksize, PlainModulus: 0xffffffffffffffc5, NumRound: valid.NumRound},     // 2^64 - 59 >= 2^63
+		} {
+			assert.Error(t, invalid.Validate(), "%v", invalid)
+		}
+	})
+
+	for pastaParam := range PastaParams {
+		t.Run(fmt.Sprintf("Pasta/Crypt/Deterministic/Param=%d/", pastaParam), func(t *testing.T) {
+			blocksize := PastaParams[pastaParam].Blocksize
+			key := newTestKey(2*blocksize, PastaParams[pastaParam].PlainModulus)
+			nonces := newTestNonces(2)
+			counter := newTestNonces(2)
+
+			pasta := NewPasta(pastaParam, key)
+			require.Equal(t, blocksize, pasta.Blocksize())
+			require.Equal(t, 2*blocksize, pasta.KeySize())
+			require.Equal(t, PastaParams[pastaParam].NumRound, pasta.NumRound())
+			require.Equal(t, PastaParams[pastaParam].PlainModulus, pasta.PlainModulus())
+
+			ks := pasta.Crypt(nonces[0], counter[0])
+			require.Len(t, ks, blocksize)
+			for _, k := range ks {
+				require.Less(t, k, pasta.PlainModulus())
+			}
+			assert.Equal(t, ks, pasta.Crypt(nonces[0], counter[0]))
+			assert.NotEqual(t, ks, pasta.Crypt(nonces[1], counter[0]))
+			assert.NotEqual(t, ks, pasta.Crypt(nonces[0], counter[1]))
+			assert.Equal(t, [][]uint64{ks, pasta.Crypt(nonces[1], counter[0])}, pasta.KeyStream(nonces, counter[0]))
+
+			key[0] = (key[0] + 1) % pasta.PlainModulus()
+			assert.NotEqual(t, ks, NewPasta(pastaParam, key).Crypt(nonces[0], counter[0]))
+		})
+	}
+
+	testPastaAgainstMFVPasta(t, PASTA4, testPastaModDown.CipherModDown)
+
+	// The 33-bit plaintext modulus overflows products of two elements computed on 64 bits
+	testPastaAgainstMFVPasta(t, PASTA4L, testPastaLModDown.CipherModDown)
+}
+
+// TestDcdMatsLargePlainModulus checks the decoding matrices of SlotsToCoeffs against a big integer
+// computation for a plaintext modulus above 32 bits (PASTA4L and HERA), whose products do not fit in 64 bits.
+func TestDcdMatsLargePlainModulus(t *testing.T) {
+	logSlots := 4
+	plainModulus := ring.GenerateNTTPrimes(60, 1<<(logSlots+1), 1)[0]
+	bigT := new(big.Int).SetUint64(plainModulus)
+
+	roots := computePrimitiveRoots(1<<(logSlots+1), plainModulus)
+	for i := range roots {
+		require.Equal(t, ring.ModExp(roots[1], i, plainModulus), roots[i])
+	}
+
+	multRef := func(A, B map[int][]uint64) map[int][]uint64 {
+		res := make(map[int][]*big.Int)
+		for rotA, a := range A {
+			for rotB, b := range B {
+				N := len(a)
+				rot := (rotA + rotB) % (N / 2)
+				if res[rot] == nil {
+					res[rot] = make([]*big.Int, N)
+					for i := range res[rot] {
+						res[rot][i] = new(big.Int)
+					}
+				}
+				for i := 0; i < N; i++ {
+					j := (rotA + i) % (N / 2)
+					if i >= N/2 {
+						j += N / 2
+					}
+					prod := new(big.Int).Mul(new(big.Int).SetUint64(a[i]), new(big.Int).SetUint64(b[j]))
+					res[rot][i].Mod(res[rot][i].Add(res[rot][i], prod), bigT)
+				}
+			}
+		}
+		out := make(map[int][]uint64)
+		for rot, v := range res {
+			out[rot] = make([]uint64, len(v))
+			for i := range v {
+				out[rot][i] = v[i].Uint64()
+			}
+		}
+		return out
+	}
+
+	diabMats := genDcdDiabDecomp(logSlots, roots)
+	tmp := multRef(diabMats[1], diabMats[0])
+	want := []map[int][]uint64{multRef(diabMats[2], tmp), multRef(diabMats[3], tmp)}
+	require.Equal(t, want, genDcdMatsInOne(logSlots, plainModulus))
+}
+
+// testPastaAgainstMFVPasta checks that the keystream evaluated homomorphically by MFVPasta
+// decrypts exactly to the keystream of the client-side Pasta, slot by slot, with and without
+// modulus switching.
+func testPastaAgainstMFVPasta(t *testing.T, pastaParam int, modDown []int) {
+	blocksize := PastaParams[pastaParam].Blocksize
+
+	hbtpParams := genTestHalfBootParams(RtFHeraParams[1])
+	hbtpParams.PlainModulus = PastaParams[pastaParam].PlainModulus
+	params, _ := genTestParams(hbtpParams)
+
+	kgen := NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairSparse(hbtpParams.H)
+	rlk := kgen.GenRelinearizationKey(sk)
+
+	fvEncoder := NewMFVEncoder(params)
+	fvEncryptor := NewMFVEncryptorFromPk(params, pk)
+	fvDecryptor := NewMFVDecryptor(params, sk)
+	fvEvaluator := NewMFVEvaluator(params, EvaluationKey{Rlk: rlk}, nil)
+	fvNoiseEstimator := NewMFVNoiseEstimator(params, sk)
+
+	key := newTestKey(2*blocksize, params.PlainModulus())
+	nonces := newTestNonces(params.FVSlots())
+	counter := newTestNonces(1)[0][:8]
+	keystream := NewPasta(pastaParam, key).KeyStream(nonces, counter)
+
+	verify := func(t *testing.T, fvKeystreams []*Ciphertext) {
+		require.Len(t, fvKeystreams, 2*blocksize)
+		for s := 0; s < blocksize; s++ {
+			require.Greater(t, fvNoiseEstimator.InvariantNoiseBudget(fvKeystreams[s]), 0)
+			have := fvEncoder.DecodeUintSmallNew(fvDecryptor.DecryptNew(fvKeystreams[s]))
+			for i := 0; i < params.FVSlots(); i++ {
+				require.Equal(t, keystream[i][s], have[i], "state %d, slot %d", s, i)
+			}
+		}
+	}
+
+	nbInitModDown := modDown[0]
+	fvPasta := NewMFVPasta(pastaParam, params, fvEncoder, fvEncryptor, fvEvaluator, nbInitModDown)
+	kCt := fvPasta.EncKey(key)
+
+	t.Run(testString(fmt.Sprintf("Pasta/MFVPasta/CryptAutoModSwitch/Param=%d/", pastaParam), params), func(t *testing.T) {
+		fvKeystreams, pastaModDown := fvPasta.CryptAutoModSwitch(nonces, counter, kCt, fvNoiseEstimator)
+		require.Len(t, pastaModDown, PastaParams[pastaParam].NumRound+1)
+		require.Equal(t, nbInitModDown, pastaModDown[0])
+		verify(t, fvKeystreams)
+	})
+
+	t.Run(testString(fmt.Sprintf("Pasta/MFVPasta/Crypt/Param=%d/", pastaParam), params), func(t *testing.T) {
+		fvPasta.Reset(nbInitModDown)
+		verify(t, fvPasta.Crypt(nonces, counter, kCt, modDown))
+
+		// The key is the initial state, and is neither copied nor switched apart from it
+		for _, ct := range fvPasta.(*mfvPasta).cipher.mkCt {
+			require.Nil(t, ct)
+		}
+		assert.Panics(t, func() { fvPasta.Crypt(nonces, counter, kCt, make([]int, PastaParams[pastaParam].NumRound+1)) })
+	})
+}
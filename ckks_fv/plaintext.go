@@ -29,6 +29,7 @@ func NewPlaintextCKKS(params *Parameters, level int, scale float64) *Plaintext {
 
 	plaintext.scale = scale
 	plaintext.isNTT = true
+	plaintext.isCKKS = true
 
 	return plaintext
 }
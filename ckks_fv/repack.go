@@ -0,0 +1,117 @@
+package ckks_fv
+
+import (
+	"fmt"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+// SlotRepacker merges sparsely packed CKKS ciphertexts, such as the outputs of HalfBoot (and Transcipher) with
+// LogSlots < LogN-1, into fully packed CKKS ciphertexts with N/2 slots, so that the following CKKS computation
+// uses fewer ciphertexts.
+//
+// A ciphertext with LogSlots < LogN-1 replicates its Slots slots over the N/2 slots of the ring, so that the i-th
+// block of Slots slots of an output is obtained by masking the i-th input with the indicator vector of this block,
+// and the masked inputs are added together. The masking consumes one level and no rotation key is needed.
+type SlotRepacker struct {
+	params  *Parameters
+	encoder CKKSEncoder
+	eval    CKKSEvaluator
+
+	masks map[int][]*Plaintext // masks[level][i] is the indicator vector of the i-th block of slots at the given level
+}
+
+// NewSlotRepacker creates a new SlotRepacker. It returns an error if the parameters are fully packed.
+func NewSlotRepacker(params *Parameters) (rp *SlotRepacker, err error) {
+	if params.LogSlots() >= params.MaxLogSlots() {
+		return nil, fmt.Errorf("LogSlots %d should be smaller than %d", params.LogSlots(), params.MaxLogSlots())
+	}
+
+	rp = new(SlotRepacker)
+	rp.params = params.Copy()
+	rp.encoder = NewCKKSEncoder(params)
+	rp.eval = NewCKKSEvaluator(params, EvaluationKey{})
+	rp.masks = make(map[int][]*Plaintext)
+	return rp, nil
+}
+
+// Ratio returns the number of input ciphertexts merged into one output ciphertext, i.e. N/(2*Slots).
+func (rp *SlotRepacker) Ratio() int {
+	return 1 << (rp.params.MaxLogSlots() - rp.params.LogSlots())
+}
+
+// Repack merges the input ciphertexts, whose Slots slots are given by HalfBoot, into ceil(len(cts)/Ratio)
+// fully packed ciphertexts, which are decoded with LogN-1 slots: the j-th slot of cts[k*Ratio+i] is the
+// (i*Slots+j)-th slot of the k-th output, and the slots of the last output without input are zero.
+// The inputs should have the same scale and are brought to the smallest of their levels, which should be
+// at least one; the outputs have the same scale one level below. The inputs are not modified.
+func (rp *SlotRepacker) Repack(cts []*Ciphertext) (ctsOut []*Ciphertext, err error) {
+	if len(cts) == 0 {
+		return nil, fmt.Errorf("no ciphertext to repack")
+	}
+
+	level := cts[0].Level()
+	for _, ct := range cts {
+		if ct.Scale() != cts[0].Scale() {
+			return nil, fmt.Errorf("ciphertexts should have the same scale")
+		}
+
+		if ct.Level() < level {
+			level = ct.Level()
+		}
+	}
+
+	if level == 0 {
+		return nil, fmt.Errorf("ciphertexts should be at least at level 1")
+	}
+
+	masks := rp.getMasks(level)
+	ratio := rp.Ratio()
+
+	ctsOut = make([]*Ciphertext, (len(cts)+ratio-1)/ratio)
+	tmp := NewCiphertextCKKS(rp.params, 1, level, 0)
+	for k := range ctsOut {
+		ctsOut[k] = NewCiphertextCKKS(rp.params, 1, level, 0)
+		for i, ct := range cts[k*ratio : utils.MinInt((k+1)*ratio, len(cts))] {
+			rp.eval.Mul(ct, masks[i], tmp)
+			if i == 0 {
+				ctsOut[k].Copy(tmp.El())
+			} else {
+				rp.eval.Add(ctsOut[k], tmp, ctsOut[k])
+			}
+		}
+
+		if err = rp.eval.Rescale(ctsOut[k], cts[0].Scale(), ctsOut[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	return ctsOut, nil
+}
+
+// getMasks returns the indicator vectors of the blocks of Slots slots at the given level, with the scale
+// Q_level so that the rescaling gives back the scale of the inputs.
+func (rp *SlotRepacker) getMasks(level int) []*Plaintext {
+	if masks, ok := rp.masks[level]; ok {
+		return masks
+	}
+
+	params := rp.params
+	slots := params.Slots()
+	masks := make([]*Plaintext, rp.Ratio())
+	values := make([]complex128, 1<<params.MaxLogSlots())
+	for i := range masks {
+		for j := range values {
+			values[j] = 0
+			if j/slots == i {
+				values[j] = 1
+			}
+		}
+
+		masks[i] = NewPlaintextCKKS(params, level, float64(params.qi[level]))
+		rp.encoder.EncodeComplexNTT(masks[i], values, params.MaxLogSlots())
+	}
+
+	rp.masks[level] = masks
+	return masks
+}
@@ -0,0 +1,104 @@
+package ckks_fv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestSlotRepacker(t *testing.T) {
+	tcParams := genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1])
+	testctx, err := genTestTranscipherContext(tcParams)
+	require.NoError(t, err)
+	params := testctx.params
+
+	t.Run(testString("SlotRepacker/Repack/", params), func(t *testing.T) {
+		tc := testctx.tc
+		n := params.FVSlots()
+		nonces := newTestNonces(n)
+		counter := newTestNonces(1)[0][:8]
+		enc := NewRtFEncryptor(tcParams, newTestKey(tcParams.KeySize(), params.PlainModulus()))
+
+		rp, err := NewSlotRepacker(params)
+		require.NoError(t, err)
+		ratio := rp.Ratio()
+		require.Equal(t, params.N()/(2*n), ratio)
+
+		// The HalfBoot outputs of the transciphered blocks, followed by fresh encryptions of Slots values
+		// over more than one output ciphertext.
+		numCts := ratio + 3
+		want := make([]float64, numCts*n)
+		for i := range want {
+			want[i] = utils.RandFloat64(-1, 1)
+		}
+
+		kCt, err := tc.LoadKey(enc.EncryptKeyNew(testctx.pk))
+		require.NoError(t, err)
+		symCt := enc.EncryptNew(want[:tcParams.BlockSize()*n], nonces, counter)
+		ks := tc.KeyStream(kCt, nonces, counter)
+
+		cts := make([]*Ciphertext, numCts)
+		blockCts, err := tc.Transcipher(symCt, ks)
+		require.NoError(t, err)
+		copy(cts, blockCts)
+
+		encryptor := NewCKKSEncryptorFromPk(params, testctx.pk)
+		for i := len(symCt.Blocks); i < numCts; i++ {
+			values := make([]complex128, n)
+			for j := range values {
+				values[j] = complex(want[i*n+j], 0)
+			}
+			cts[i] = encryptor.EncryptNew(testctx.ckksEncoder.EncodeComplexNTTNew(values, params.LogSlots()))
+		}
+
+		ctsOut, err := rp.Repack(cts)
+		require.NoError(t, err)
+		require.Len(t, ctsOut, 2)
+
+		slots := params.N() / 2
+		for k, ct := range ctsOut {
+			require.Equal(t, cts[0].Level()-1, ct.Level())
+			require.Equal(t, cts[0].Scale(), ct.Scale())
+
+			wantOut := make([]complex128, slots)
+			for i := range wantOut {
+				if k*slots+i < len(want) {
+					wantOut[i] = complex(want[k*slots+i], 0)
+				}
+			}
+
+			precStats := GetPrecisionStats(params, testctx.ckksEncoder, testctx.ckksDecryptor, wantOut, ct, params.MaxLogSlots(), 0)
+			if testing.Verbose() {
+				t.Log(precStats.String())
+			}
+			require.GreaterOrEqual(t, real(precStats.MinPrecision), float64(testTranscipherMinPrecision))
+		}
+	})
+
+	t.Run(testString("SlotRepacker/Errors/", params), func(t *testing.T) {
+		rp, err := NewSlotRepacker(params)
+		require.NoError(t, err)
+
+		encryptor := NewCKKSEncryptorFromPk(params, testctx.pk)
+		ct := encryptor.EncryptNew(testctx.ckksEncoder.EncodeComplexNTTNew(make([]complex128, params.Slots()), params.LogSlots()))
+
+		_, err = rp.Repack(nil)
+		assert.Error(t, err)
+
+		ctLow := ct.CopyNew().Ciphertext()
+		ctLow.SetScale(2 * ct.Scale())
+		_, err = rp.Repack([]*Ciphertext{ct, ctLow})
+		assert.Error(t, err)
+
+		ctLow = NewCKKSEvaluator(params, EvaluationKey{}).DropLevelNew(ct, ct.Level())
+		_, err = rp.Repack([]*Ciphertext{ct, ctLow})
+		assert.Error(t, err)
+
+		fullParams, _ := genTestParams(genTestHalfBootParams(RtFHeraParams[0]))
+		_, err = NewSlotRepacker(fullParams)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,321 @@
+package ckks_fv
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+// SymmetricCiphertext is a ciphertext of the RtF framework produced by the client.
+// Each block holds FVSlots real values scaled by the message scaling and encoded in the coefficients
+// of a plaintext in R_t, masked with one keystream element per FV slot. Blocks[s] is masked with the
+// s-th keystream element generated from the nonces (one per FV slot) and the counter.
+// If Complex is set, each block holds FVSlots/2 complex values instead, with the real parts in the first
+// FVSlots/2 FV slots and the imaginary parts in the last FVSlots/2 FV slots (see RtFEncryptor.EncryptComplexNew).
+type SymmetricCiphertext struct {
+	Cipher       CipherType
+	CipherParam  int
+	PlainModulus uint64
+	LogN         int
+	LogFVSlots   int
+	Complex      bool
+
+	Nonces  [][]byte
+	Counter []byte
+	Blocks  []*PlaintextRingT
+}
+
+// FVSlots returns the number of FV slots of the symmetric ciphertext, i.e. the number of nonces.
+func (symCt *SymmetricCiphertext) FVSlots() int {
+	return 1 << symCt.LogFVSlots
+}
+
+// Validate checks that the symmetric ciphertext is consistent with the given Parameters:
+// same ring degree, plaintext modulus and number of FV slots, one nonce per FV slot, and blocks
+// of reduced coefficients that are zero outside of the FV slots.
+func (symCt *SymmetricCiphertext) Validate(params *Parameters) error {
+	if symCt.LogN != params.LogN() {
+		return fmt.Errorf("LogN %d does not match the parameters LogN %d", symCt.LogN, params.LogN())
+	}
+
+	if symCt.PlainModulus != params.PlainModulus() {
+		return fmt.Errorf("plaintext modulus %d does not match the parameters plaintext modulus %d", symCt.PlainModulus, params.PlainModulus())
+	}
+
+	if symCt.LogFVSlots != params.LogFVSlots() {
+		return fmt.Errorf("LogFVSlots %d does not match the parameters LogFVSlots %d", symCt.LogFVSlots, params.LogFVSlots())
+	}
+
+	if len(symCt.Nonces) != symCt.FVSlots() {
+		return fmt.Errorf("%d nonces are expected but %d given", symCt.FVSlots(), len(symCt.Nonces))
+	}
+
+	gap := params.N() / symCt.FVSlots()
+	for s, block := range symCt.Blocks {
+		if block == nil || len(block.Value()) != 1 || len(block.Value()[0].Coeffs) != 1 || block.Value()[0].Degree() != params.N() {
+			return fmt.Errorf("block %d is not a plaintext of R_t", s)
+		}
+
+		for i, c := range block.Value()[0].Coeffs[0] {
+			if c >= symCt.PlainModulus {
+				return fmt.Errorf("block %d has a coefficient larger than the plaintext modulus", s)
+			}
+			if i%gap != 0 && c != 0 {
+				return fmt.Errorf("block %d has a non-zero coefficient outside of the FV slots", s)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SymmetricKeyBundle is the symmetric key of a client encrypted under the FV public key of the server, as produced
+// by RtFEncryptor.EncryptKeyNew. Ciphertexts[i] encrypts the i-th element of the key replicated in all the FV slots,
+// at the level of the initial states of the cipher, so that the server never needs the plaintext key.
+type SymmetricKeyBundle struct {
+	Cipher       CipherType
+	CipherParam  int
+	PlainModulus uint64
+	LogN         int
+	LogFVSlots   int
+
+	Slots       []int // Group of slots in which the key is encrypted (see EncryptKeySlotsNew), or nil for all the slots
+	Ciphertexts []*Ciphertext
+}
+
+// Validate checks that the symmetric key bundle is consistent with the given Parameters: same ring degree,
+// plaintext modulus and number of FV slots, and ciphertexts of degree 1 at the same level.
+func (bundle *SymmetricKeyBundle) Validate(params *Parameters) error {
+	if bundle.LogN != params.LogN() {
+		return fmt.Errorf("LogN %d does not match the parameters LogN %d", bundle.LogN, params.LogN())
+	}
+
+	if bundle.PlainModulus != params.PlainModulus() {
+		return fmt.Errorf("plaintext modulus %d does not match the parameters plaintext modulus %d", bundle.PlainModulus, params.PlainModulus())
+	}
+
+	if bundle.LogFVSlots != params.LogFVSlots() {
+		return fmt.Errorf("LogFVSlots %d does not match the parameters LogFVSlots %d", bundle.LogFVSlots, params.LogFVSlots())
+	}
+
+	if len(bundle.Ciphertexts) == 0 {
+		return errors.New("no key ciphertext given")
+	}
+
+	level := bundle.Ciphertexts[0].Level()
+	for i, ct := range bundle.Ciphertexts {
+		if ct == nil || ct.Degree() != 1 || ct.Value()[0].Degree() != params.N() {
+			return fmt.Errorf("key ciphertext %d is not a ciphertext of degree 1", i)
+		}
+		if ct.Level() != level || level > params.MaxLevel() {
+			return fmt.Errorf("key ciphertext %d is at level %d instead of %d", i, ct.Level(), level)
+		}
+	}
+
+	return nil
+}
+
+// RtFEncryptor is an interface for the client-side encryptor of the RtF framework.
+type RtFEncryptor interface {
+	// EncryptNew encrypts up to BlockSize*FVSlots real values with the stored symmetric key,
+	// the nonces (one per FV slot) and the counter (ignored by HERA), and returns the result
+	// on a newly created symmetric ciphertext. The values data[s*FVSlots:(s+1)*FVSlots] are
+	// encrypted in the s-th block, and the last block is padded with zeros.
+	EncryptNew(data []float64, nonces [][]byte, counter []byte) *SymmetricCiphertext
+
+	// EncryptComplexNew encrypts up to BlockSize*FVSlots/2 complex values as EncryptNew, with the complex values
+	// data[s*FVSlots/2:(s+1)*FVSlots/2] in the s-th block: the real part of the k-th value of a block is encrypted
+	// in the k-th FV slot and its imaginary part in the (FVSlots/2+k)-th FV slot, so that Transcipherer.TranscipherComplex
+	// returns the k-th value in the k-th slot of a CKKS ciphertext.
+	EncryptComplexNew(data []complex128, nonces [][]byte, counter []byte) *SymmetricCiphertext
+
+	// EncryptStreamNew encrypts an arbitrary number of real values in a stream of symmetric ciphertexts,
+	// with the nonces derived from the base nonce (see SymmetricStream) and the counter (ignored by HERA).
+	EncryptStreamNew(data []float64, baseNonce []byte, counter []byte) *SymmetricStream
+
+	// EncryptIntNew encrypts up to BlockSize*FVSlots integers of Z_t, which are transciphered exactly to
+	// an FV ciphertext by Transcipherer.TranscipherInt (see SymmetricIntCiphertext).
+	EncryptIntNew(data []uint64, nonces [][]byte, counter []byte) *SymmetricIntCiphertext
+
+	// EncryptKeyNew encrypts the stored symmetric key under the FV public key of the server, and returns
+	// the result on a newly created key bundle, which the server gives to Transcipherer.LoadKey.
+	EncryptKeyNew(pk *PublicKey) *SymmetricKeyBundle
+
+	// EncryptSlotsNew and EncryptKeySlotsNew are the counterparts of EncryptNew and EncryptKeyNew for a client
+	// which owns a group of slots of a batch shared by many clients (see SymmetricSlotCiphertext).
+	EncryptSlotsNew(data []float64, slots []int, nonces [][]byte, counter []byte) *SymmetricSlotCiphertext
+	EncryptKeySlotsNew(pk *PublicKey, slots []int) *SymmetricKeyBundle
+}
+
+type rtfEncryptor struct {
+	tcParams *TranscipherParameters
+	params   *Parameters
+
+	key    []uint64
+	hera   Hera
+	rubato Rubato
+	pasta  Pasta
+}
+
+// NewRtFEncryptor creates a new client-side RtF encryptor from the transciphering parameters and the symmetric key.
+// Data are encoded in the coefficients of the FVSlots FV slots, which are the full coefficients if LogFVSlots = LogN.
+func NewRtFEncryptor(tcParams *TranscipherParameters, key []uint64) RtFEncryptor {
+	var err error
+	if err = tcParams.Validate(); err != nil {
+		panic(fmt.Errorf("cannot NewRtFEncryptor: %w", err))
+	}
+
+	enc := new(rtfEncryptor)
+	enc.tcParams = tcParams.Copy()
+	if enc.params, err = tcParams.Params(); err != nil {
+		panic(err)
+	}
+
+	if len(key) != tcParams.KeySize() {
+		panic(fmt.Sprintf("cannot NewRtFEncryptor: key should have %d elements but %d given", tcParams.KeySize(), len(key)))
+	}
+	enc.key = make([]uint64, len(key))
+	copy(enc.key, key)
+
+	switch tcParams.Cipher {
+	case CipherHera:
+		enc.hera = NewHera(tcParams.NumRound(), key, tcParams.PlainModulus())
+	case CipherRubato:
+		enc.rubato = NewRubato(tcParams.CipherParam, key)
+	case CipherPasta:
+		enc.pasta = NewPasta(tcParams.CipherParam, key)
+	}
+	return enc
+}
+
+func (enc *rtfEncryptor) EncryptNew(data []float64, nonces [][]byte, counter []byte) (symCt *SymmetricCiphertext) {
+	params := enc.params
+	slots := params.FVSlots()
+
+	if len(nonces) != slots {
+		panic(fmt.Sprintf("cannot EncryptNew: %d nonces are expected but %d given", slots, len(nonces)))
+	}
+
+	if len(data) > enc.tcParams.BlockSize()*slots {
+		panic(fmt.Sprintf("cannot EncryptNew: too many values (maximum is %d)", enc.tcParams.BlockSize()*slots))
+	}
+
+	var keystream [][]uint64
+	switch enc.tcParams.Cipher {
+	case CipherHera:
+		keystream = enc.hera.KeyStream(nonces)
+	case CipherRubato:
+		keystream = enc.rubato.KeyStream(nonces, counter)
+	default:
+		keystream = enc.pasta.KeyStream(nonces, counter)
+	}
+
+	symCt = new(SymmetricCiphertext)
+	symCt.Cipher = enc.tcParams.Cipher
+	symCt.CipherParam = enc.tcParams.CipherParam
+	symCt.PlainModulus = params.PlainModulus()
+	symCt.LogN = params.LogN()
+	symCt.LogFVSlots = params.LogFVSlots()
+
+	symCt.Nonces = make([][]byte, slots)
+	for i := range nonces {
+		symCt.Nonces[i] = make([]byte, len(nonces[i]))
+		copy(symCt.Nonces[i], nonces[i])
+	}
+	symCt.Counter = make([]byte, len(counter))
+	copy(symCt.Counter, counter)
+
+	nbBlocks := (len(data) + slots - 1) / slots
+	symCt.Blocks = make([]*PlaintextRingT, nbBlocks)
+
+	values := make([]float64, slots)
+	for s := 0; s < nbBlocks; s++ {
+		for i := range values {
+			values[i] = 0
+		}
+		copy(values, data[s*slots:utils.MinInt((s+1)*slots, len(data))])
+
+		symCt.Blocks[s] = enc.encryptBlock(values, keystream, s)
+	}
+	return
+}
+
+func (enc *rtfEncryptor) EncryptComplexNew(data []complex128, nonces [][]byte, counter []byte) (symCt *SymmetricCiphertext) {
+	half := enc.params.FVSlots() / 2
+
+	if len(data) > enc.tcParams.BlockSize()*half {
+		panic(fmt.Sprintf("cannot EncryptComplexNew: too many values (maximum is %d)", enc.tcParams.BlockSize()*half))
+	}
+
+	// Splits each block of complex values into the real values of the FV slots
+	nbBlocks := (len(data) + half - 1) / half
+	values := make([]float64, nbBlocks*2*half)
+	for i, z := range data {
+		s, k := i/half, i%half
+		values[2*s*half+k] = real(z)
+		values[(2*s+1)*half+k] = imag(z)
+	}
+
+	symCt = enc.EncryptNew(values, nonces, counter)
+	symCt.Complex = true
+	return
+}
+
+func (enc *rtfEncryptor) EncryptKeyNew(pk *PublicKey) (bundle *SymmetricKeyBundle) {
+	return enc.encryptKey(pk, nil)
+}
+
+// encryptKey encrypts the symmetric key in the FV slots of the given group of slots, or in all the FV slots if slots is nil.
+func (enc *rtfEncryptor) encryptKey(pk *PublicKey, slots []int) (bundle *SymmetricKeyBundle) {
+	params := enc.params
+
+	bundle = new(SymmetricKeyBundle)
+	bundle.Cipher = enc.tcParams.Cipher
+	bundle.CipherParam = enc.tcParams.CipherParam
+	bundle.PlainModulus = params.PlainModulus()
+	bundle.LogN = params.LogN()
+	bundle.LogFVSlots = params.LogFVSlots()
+
+	// The evaluator is only used to drop the moduli of the initial states
+	encoder := NewMFVEncoder(params)
+	encryptor := NewMFVEncryptorFromPk(params, pk)
+	evaluator := NewMFVEvaluator(params, EvaluationKey{}, nil)
+	var fvSlots []int
+	if slots != nil {
+		fvSlots = make([]int, len(slots))
+		for j, k := range slots {
+			fvSlots[j] = fvSlotIndex(params, k)
+		}
+	}
+	bundle.Ciphertexts = encryptKey(params, encoder, encryptor, evaluator, enc.key, fvSlots, enc.tcParams.CipherModDown[0])
+	return
+}
+
+// encryptBlock encodes the values in the coefficients of a plaintext in R_t, in bit-reversed order,
+// and adds the s-th element of the keystream of each slot.
+func (enc *rtfEncryptor) encryptBlock(values []float64, keystream [][]uint64, s int) (ptRt *PlaintextRingT) {
+	params := enc.params
+	slots := params.FVSlots()
+	t := params.PlainModulus()
+	logN := uint64(params.LogN())
+	gap := uint64(params.N() / 2)
+
+	coeffs := make([]float64, params.N())
+	for i := 0; i < slots/2; i++ {
+		j := utils.BitReverse64(uint64(i), logN-1)
+		coeffs[j] = values[i]
+		coeffs[j+gap] = values[i+slots/2]
+	}
+
+	ptRt = NewPlaintextRingT(params)
+	ptRt.SetScale(enc.tcParams.MessageScaling())
+	scaleUpVecExact(coeffs, ptRt.Scale(), []uint64{t}, ptRt.value.Coeffs)
+
+	poly := ptRt.value.Coeffs[0]
+	for i := 0; i < slots; i++ {
+		j := utils.BitReverse64(uint64(i), logN)
+		poly[j] = (poly[j] + keystream[i][s]) % t
+	}
+	return
+}
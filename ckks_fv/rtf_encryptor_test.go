@@ -0,0 +1,118 @@
+package ckks_fv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestRtFEncryptor(t *testing.T) {
+	testCases := []*TranscipherParameters{
+		genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1]),
+		genTestTranscipherParams(RtFHeraParams[0], CipherHera, 5, 2, HeraModDownParams128[0]),
+	}
+
+	for _, tcParams := range testCases {
+		params, err := tcParams.Params()
+		require.NoError(t, err)
+
+		t.Run(testString("RtFEncryptor/EncryptNew/", params), func(t *testing.T) {
+			n := params.FVSlots()
+			t0 := params.PlainModulus()
+			key := newTestKey(tcParams.KeySize(), t0)
+			nonces := newTestNonces(n)
+			enc := NewRtFEncryptor(tcParams, key)
+
+			assert.Panics(t, func() { enc.EncryptNew(make([]float64, n), nonces[1:], nil) })
+			assert.Panics(t, func() { enc.EncryptNew(make([]float64, tcParams.BlockSize()*n+1), nonces, nil) })
+
+			data := make([]float64, n+1)
+			for i := range data {
+				data[i] = utils.RandFloat64(-1, 1)
+			}
+
+			symCt := enc.EncryptNew(data, nonces, nil)
+			require.Len(t, symCt.Blocks, 2)
+			require.Equal(t, nonces, symCt.Nonces)
+
+			// Removes the keystream and compares with the bit-reversed encoding of the data
+			keystream := NewHera(tcParams.NumRound(), key, t0).KeyStream(nonces)
+			encoder := NewCKKSEncoder(params)
+			for s := range symCt.Blocks {
+				values := make([]float64, n)
+				copy(values, data[s*n:utils.MinInt((s+1)*n, len(data))])
+
+				coeffs := make([]float64, params.N())
+				for i := 0; i < n/2; i++ {
+					j := utils.BitReverse64(uint64(i), uint64(params.LogN()-1))
+					coeffs[j] = values[i]
+					coeffs[j+uint64(params.N()/2)] = values[i+n/2]
+				}
+				want := encoder.EncodeCoeffsRingTNew(coeffs, tcParams.MessageScaling()).Value()[0].Coeffs[0]
+
+				have := symCt.Blocks[s].Value()[0].Coeffs[0]
+				for i := 0; i < n; i++ {
+					j := utils.BitReverse64(uint64(i), uint64(params.LogN()))
+					have[j] = (have[j] + t0 - keystream[i][s]) % t0
+				}
+				require.Equal(t, want, have)
+			}
+		})
+
+		t.Run(testString("RtFEncryptor/EncryptComplexNew/", params), func(t *testing.T) {
+			n := params.FVSlots()
+			t0 := params.PlainModulus()
+			key := newTestKey(tcParams.KeySize(), t0)
+			nonces := newTestNonces(n)
+			enc := NewRtFEncryptor(tcParams, key)
+
+			assert.Panics(t, func() { enc.EncryptComplexNew(make([]complex128, tcParams.BlockSize()*n/2+1), nonces, nil) })
+
+			data := make([]complex128, n/2+1)
+			for i := range data {
+				data[i] = complex(utils.RandFloat64(-1, 1), utils.RandFloat64(-1, 1))
+			}
+
+			symCt := enc.EncryptComplexNew(data, nonces, nil)
+			require.True(t, symCt.Complex)
+			require.Len(t, symCt.Blocks, 2)
+
+			// Removes the keystream and compares with the bit-reversed encoding of the complex coefficients
+			keystream := NewHera(tcParams.NumRound(), key, t0).KeyStream(nonces)
+			encoder := NewCKKSEncoder(params)
+			for s := range symCt.Blocks {
+				values := make([]complex128, n/2)
+				copy(values, data[s*n/2:utils.MinInt((s+1)*n/2, len(data))])
+
+				coeffs := make([]complex128, params.N()/2)
+				for i := range values {
+					coeffs[utils.BitReverse64(uint64(i), uint64(params.LogN()-1))] = values[i]
+				}
+				want := testEncodeComplexCoeffsRingTNew(encoder, coeffs, tcParams.MessageScaling()).Value()[0].Coeffs[0]
+
+				have := symCt.Blocks[s].Value()[0].Coeffs[0]
+				for i := 0; i < n; i++ {
+					j := utils.BitReverse64(uint64(i), uint64(params.LogN()))
+					have[j] = (have[j] + t0 - keystream[i][s]) % t0
+				}
+				require.Equal(t, want, have)
+			}
+		})
+	}
+}
+
+// testEncodeComplexCoeffsRingTNew encodes the complex coefficients of a polynomial of degree < N/2 on a new plaintext
+// of RingT, with the real parts in the coefficients of degree < N/2 and the imaginary parts in the coefficients of
+// degree >= N/2. As X^(N/2) is mapped to i by the canonical embedding, this is the encoding of the complex polynomial.
+func testEncodeComplexCoeffsRingTNew(encoder CKKSEncoder, values []complex128, scale float64) *PlaintextRingT {
+	n := len(values)
+	coeffs := make([]float64, 2*n)
+	for i, v := range values {
+		coeffs[i] = real(v)
+		coeffs[i+n] = imag(v)
+	}
+	return encoder.EncodeCoeffsRingTNew(coeffs, scale)
+}
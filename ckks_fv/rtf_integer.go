@@ -0,0 +1,265 @@
+package ckks_fv
+
+import (
+	"fmt"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+// SymmetricIntCiphertext is a ciphertext of the integer mode of the RtF framework produced by the client.
+// Each block holds FVSlots integers of Z_t, where t is the plaintext modulus, masked with one keystream
+// element per FV slot: Blocks[s][i] is the i-th integer of the s-th block added modulo t with the s-th
+// keystream element generated from the i-th nonce and the counter.
+// It is transciphered to an FV ciphertext which holds the integers in its FV slots, without half-bootstrapping.
+type SymmetricIntCiphertext struct {
+	Cipher       CipherType
+	CipherParam  int
+	PlainModulus uint64
+	LogN         int
+	LogFVSlots   int
+
+	Nonces  [][]byte
+	Counter []byte
+	Blocks  [][]uint64
+}
+
+// FVSlots returns the number of FV slots of the symmetric ciphertext, i.e. the number of nonces.
+func (symCt *SymmetricIntCiphertext) FVSlots() int {
+	return 1 << symCt.LogFVSlots
+}
+
+// Validate checks that the symmetric ciphertext is consistent with the given Parameters:
+// same ring degree, plaintext modulus and number of FV slots, one nonce per FV slot, and blocks
+// of FVSlots elements of Z_t.
+func (symCt *SymmetricIntCiphertext) Validate(params *Parameters) error {
+	if symCt.LogN != params.LogN() {
+		return fmt.Errorf("LogN %d does not match the parameters LogN %d", symCt.LogN, params.LogN())
+	}
+
+	if symCt.PlainModulus != params.PlainModulus() {
+		return fmt.Errorf("plaintext modulus %d does not match the parameters plaintext modulus %d", symCt.PlainModulus, params.PlainModulus())
+	}
+
+	if symCt.LogFVSlots != params.LogFVSlots() {
+		return fmt.Errorf("LogFVSlots %d does not match the parameters LogFVSlots %d", symCt.LogFVSlots, params.LogFVSlots())
+	}
+
+	if len(symCt.Nonces) != symCt.FVSlots() {
+		return fmt.Errorf("%d nonces are expected but %d given", symCt.FVSlots(), len(symCt.Nonces))
+	}
+
+	for s, block := range symCt.Blocks {
+		if len(block) != symCt.FVSlots() {
+			return fmt.Errorf("block %d should have %d elements but has %d", s, symCt.FVSlots(), len(block))
+		}
+
+		for _, c := range block {
+			if c >= symCt.PlainModulus {
+				return fmt.Errorf("block %d has an element larger than the plaintext modulus", s)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkIntCipher returns an error if the integer mode is not supported by the cipher.
+// The keystream of Rubato carries a Gaussian noise, which would be added to the integers.
+func checkIntCipher(cipher CipherType) error {
+	if cipher == CipherRubato {
+		return fmt.Errorf("the integer mode is not supported by %v, whose keystream is noisy", cipher)
+	}
+	return nil
+}
+
+// EncryptIntNew encrypts up to BlockSize*FVSlots integers of Z_t with the stored symmetric key, the nonces
+// (one per FV slot) and the counter (ignored by HERA). The integers data[s*FVSlots:(s+1)*FVSlots] are encrypted
+// in the s-th block, and the last block is padded with zeros. Signed integers should be given by their
+// representative modulo t, i.e. x < 0 as t+x, and are decoded by MFVEncoder.DecodeInt if |x| < t/2.
+// It panics with Rubato, whose keystream is noisy.
+func (enc *rtfEncryptor) EncryptIntNew(data []uint64, nonces [][]byte, counter []byte) (symCt *SymmetricIntCiphertext) {
+	params := enc.params
+	slots := params.FVSlots()
+	t := params.PlainModulus()
+
+	if err := checkIntCipher(enc.tcParams.Cipher); err != nil {
+		panic(fmt.Errorf("cannot EncryptIntNew: %w", err))
+	}
+
+	if len(nonces) != slots {
+		panic(fmt.Sprintf("cannot EncryptIntNew: %d nonces are expected but %d given", slots, len(nonces)))
+	}
+
+	if len(data) > enc.tcParams.BlockSize()*slots {
+		panic(fmt.Sprintf("cannot EncryptIntNew: too many values (maximum is %d)", enc.tcParams.BlockSize()*slots))
+	}
+
+	for _, v := range data {
+		if v >= t {
+			panic(fmt.Sprintf("cannot EncryptIntNew: %d is larger than the plaintext modulus %d", v, t))
+		}
+	}
+
+	var keystream [][]uint64
+	if enc.tcParams.Cipher == CipherHera {
+		keystream = enc.hera.KeyStream(nonces)
+	} else {
+		keystream = enc.pasta.KeyStream(nonces, counter)
+	}
+
+	symCt = new(SymmetricIntCiphertext)
+	symCt.Cipher = enc.tcParams.Cipher
+	symCt.CipherParam = enc.tcParams.CipherParam
+	symCt.PlainModulus = t
+	symCt.LogN = params.LogN()
+	symCt.LogFVSlots = params.LogFVSlots()
+
+	symCt.Nonces = make([][]byte, slots)
+	for i := range nonces {
+		symCt.Nonces[i] = make([]byte, len(nonces[i]))
+		copy(symCt.Nonces[i], nonces[i])
+	}
+	symCt.Counter = make([]byte, len(counter))
+	copy(symCt.Counter, counter)
+
+	symCt.Blocks = make([][]uint64, (len(data)+slots-1)/slots)
+	for s := range symCt.Blocks {
+		symCt.Blocks[s] = make([]uint64, slots)
+		copy(symCt.Blocks[s], data[s*slots:utils.MinInt((s+1)*slots, len(data))])
+		for i := range symCt.Blocks[s] {
+			symCt.Blocks[s][i] = (symCt.Blocks[s][i] + keystream[i][s]) % t
+		}
+	}
+	return
+}
+
+// NewIntTranscipherer creates a new Transcipherer for the integer transciphering only (see KeyStreamInt and
+// TranscipherInt), which needs neither the half-bootstrapping nor SlotsToCoeffs. Only the cipher, the FV slots
+// and CipherModDown of the parameters are checked, so that the CKKS bootstrapping settings and StCModDown can be
+// left unset, and the relinearization key is the only evaluation key. The public key is used to encrypt the
+// initial states of the cipher. The methods of the real-valued transciphering (KeyStream, Transcipher, ...)
+// are not supported by the returned Transcipherer.
+func NewIntTranscipherer(tcParams *TranscipherParameters, pk *PublicKey, rlk *RelinearizationKey) (tc *Transcipherer, err error) {
+	if err = tcParams.validateInt(); err != nil {
+		return nil, fmt.Errorf("invalid transcipher parameters: %w", err)
+	}
+
+	if tc, err = newTranscipherer(tcParams); err != nil {
+		return nil, err
+	}
+
+	tc.setCipher(pk, EvaluationKey{Rlk: rlk}, nil)
+	return tc, nil
+}
+
+// validateInt checks the parameters used by the integer transciphering: the cipher, the FV slots and CipherModDown.
+func (tcParams *TranscipherParameters) validateInt() error {
+	if err := tcParams.validateCipher(); err != nil {
+		return err
+	}
+
+	if err := checkIntCipher(tcParams.Cipher); err != nil {
+		return err
+	}
+
+	if err := tcParams.validateFVSlots(); err != nil {
+		return err
+	}
+
+	if len(tcParams.CipherModDown) != tcParams.NumRound()+1 {
+		return fmt.Errorf("CipherModDown should have %d elements but has %d", tcParams.NumRound()+1, len(tcParams.CipherModDown))
+	}
+
+	return tcParams.validateModDown(tcParams.CipherModDown)
+}
+
+// CheckIntCiphertext checks that the integer symmetric ciphertext has been produced with the cipher and the
+// parameters of the Transcipherer, and that it has at most BlockSize blocks.
+func (tc *Transcipherer) CheckIntCiphertext(symCt *SymmetricIntCiphertext) error {
+	if symCt.Cipher != tc.Cipher || symCt.CipherParam != tc.CipherParam {
+		return fmt.Errorf("cipher %v (%d) does not match the transcipherer cipher %v (%d)", symCt.Cipher, symCt.CipherParam, tc.Cipher, tc.CipherParam)
+	}
+
+	if err := checkIntCipher(tc.Cipher); err != nil {
+		return err
+	}
+
+	if len(symCt.Blocks) > tc.BlockSize() {
+		return fmt.Errorf("%d blocks given but at most %d are supported", len(symCt.Blocks), tc.BlockSize())
+	}
+
+	return symCt.Validate(tc.params)
+}
+
+// IntLevel returns the level of the FV ciphertexts returned by KeyStreamInt and TranscipherInt,
+// i.e. the level of the output of the cipher after its modulus switching schedule.
+func (tc *Transcipherer) IntLevel() int {
+	level := tc.params.MaxLevel()
+	for _, nbModDown := range tc.CipherModDown {
+		level -= nbModDown
+	}
+	return level
+}
+
+// KeyStreamInt is the offline phase of the integer transciphering. It evaluates the keystream of the cipher for
+// the encrypted symmetric key kCt, with one nonce per FV slot and the counter (ignored by HERA), and returns
+// BlockSize FV ciphertexts at level IntLevel whose FV slots hold the keystream, along with the nonces and the counter.
+// Unlike KeyStream, the keystream is not brought to the coefficients, so that SlotsToCoeffs and its rotation keys
+// are not needed (see NewIntTranscipherer).
+func (tc *Transcipherer) KeyStreamInt(kCt []*Ciphertext, nonces [][]byte, counter []byte) (ks *KeyStreamBatch) {
+	if err := checkIntCipher(tc.Cipher); err != nil {
+		panic(fmt.Errorf("cannot KeyStreamInt: %w", err))
+	}
+
+	if len(nonces) != tc.params.FVSlots() {
+		panic(fmt.Sprintf("cannot KeyStreamInt: %d nonces are expected but %d given", tc.params.FVSlots(), len(nonces)))
+	}
+
+	stCt := tc.cipher.Crypt(nonces, counter, kCt, tc.CipherModDown)
+
+	fvKeystreams := make([]*Ciphertext, tc.BlockSize())
+	copy(fvKeystreams, stCt)
+
+	// Resets the initial states for the next evaluation of the cipher
+	tc.cipher.Reset(tc.CipherModDown[0])
+	return newKeyStreamBatch(nonces, counter, fvKeystreams)
+}
+
+// TranscipherInt is the online phase of the integer transciphering. After checking the symmetric ciphertext with
+// CheckIntCiphertext, it subtracts from each block the FV keystream of the same index, as returned by KeyStreamInt
+// for the nonces and the counter of the symmetric ciphertext, and returns one FV ciphertext per block, at level
+// IntLevel, whose FV slots hold the integers of the block. The results can be evaluated with an MFVEvaluator, and
+// are decrypted with an MFVDecryptor and decoded with MFVEncoder.DecodeUintSmall.
+//
+// A block is encoded in the FV slots and scaled by Delta = floor(Q_l/t) without any error, so that the invariant
+// noise of the result is the invariant noise v of the keystream plus at most t*(Q_l mod t)/Q_l from the encoding
+// (cf. MFVAnalyticNoiseEstimator.Plaintext). The result decrypts exactly to the integers modulo t if and only if
+// this noise is below 1/2, i.e. if its invariant noise budget (cf. MFVNoiseEstimator) is positive: no precision is
+// lost as with Transcipher, but the budget left by the cipher bounds the further homomorphic operations.
+func (tc *Transcipherer) TranscipherInt(symCt *SymmetricIntCiphertext, ks *KeyStreamBatch) (cts []*Ciphertext, err error) {
+	if err = tc.CheckIntCiphertext(symCt); err != nil {
+		return nil, fmt.Errorf("cannot TranscipherInt: invalid symmetric ciphertext: %w", err)
+	}
+
+	if err = tc.checkKeyStream(ks, symCt.Nonces, symCt.Counter, len(symCt.Blocks), tc.IntLevel()); err != nil {
+		return nil, fmt.Errorf("cannot TranscipherInt: %w", err)
+	}
+
+	cts = make([]*Ciphertext, len(symCt.Blocks))
+	for s, block := range symCt.Blocks {
+		cts[s] = tc.transcipherIntBlock(block, ks.Cts[s])
+	}
+	return cts, nil
+}
+
+// transcipherIntBlock subtracts the FV keystream from a block of integers of Z_t encoded in the FV slots.
+func (tc *Transcipherer) transcipherIntBlock(block []uint64, fvKeystream *Ciphertext) (ct *Ciphertext) {
+	level := fvKeystream.Level()
+	pt := NewPlaintextFVLvl(tc.params, level)
+	tc.fvEncoder.EncodeUintSmall(block, pt)
+
+	ct = NewCiphertextFVLvl(tc.params, 1, level)
+	ct.Value()[0].Copy(pt.Value()[0])
+	tc.fvEvaluator.Sub(ct, fvKeystream, ct)
+	return
+}
@@ -0,0 +1,141 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestTranscipherInt(t *testing.T) {
+	testCases := []*TranscipherParameters{
+		genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1]),
+		genTestTranscipherParams(RtFHeraParams[1], CipherPasta, PASTA4, 0, testPastaModDown),
+	}
+	testCases[1].HalfBootParameters.PlainModulus = 0 // set by the cipher
+
+	for _, tcParams := range testCases {
+		testctx, err := genTestIntTranscipherContext(tcParams)
+		require.NoError(t, err)
+		testTranscipherInt(testctx, t)
+	}
+
+	t.Run("TranscipherInt/IntOnly/", func(t *testing.T) {
+		// Neither SlotsToCoeffs nor the half-bootstrapping are used, so that their parameters are not checked
+		tcParams := testCases[0].Copy()
+		tcParams.StCModDown = nil
+		tcParams.Radix = -1
+		testctx, err := genTestIntTranscipherContext(tcParams)
+		require.NoError(t, err)
+		tc := testctx.tc
+		n := testctx.params.FVSlots()
+
+		assert.Panics(t, func() { tc.KeyStream(nil, newTestNonces(n), nil) })
+		_, err = tc.Transcipher(&SymmetricCiphertext{}, &KeyStreamBatch{})
+		assert.Error(t, err)
+		assert.Error(t, tc.SetEncapsulationKeys(nil, nil))
+
+		tcParams.CipherModDown = tcParams.CipherModDown[1:]
+		_, err = NewIntTranscipherer(tcParams, testctx.pk, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("TranscipherInt/Rubato/", func(t *testing.T) {
+		tcParams := genTestTranscipherParams(RtFRubatoParams[0], CipherRubato, RUBATO80S, 2, RubatoModDownParams[RUBATO80S])
+		enc := NewRtFEncryptor(tcParams, newTestKey(tcParams.KeySize(), tcParams.PlainModulus()))
+		assert.Panics(t, func() { enc.EncryptIntNew([]uint64{1}, newTestNonces(1<<tcParams.LogFVSlots()), nil) })
+	})
+}
+
+// genTestIntTranscipherContext returns a context whose Transcipherer is created by NewIntTranscipherer,
+// with a relinearization key alone.
+func genTestIntTranscipherContext(tcParams *TranscipherParameters) (testctx *testTranscipherContext, err error) {
+	testctx = new(testTranscipherContext)
+	testctx.tcParams = tcParams
+
+	if testctx.params, err = tcParams.Params(); err != nil {
+		return nil, err
+	}
+
+	kgen := NewKeyGenerator(testctx.params)
+	testctx.sk, testctx.pk = kgen.GenKeyPair()
+	testctx.tc, err = NewIntTranscipherer(tcParams, testctx.pk, kgen.GenRelinearizationKey(testctx.sk))
+	return
+}
+
+func testTranscipherInt(testctx *testTranscipherContext, t *testing.T) {
+	tcParams := testctx.tcParams
+	params := testctx.params
+
+	name := fmt.Sprintf("TranscipherInt/%v/NumRound=%d/", tcParams.Cipher, tcParams.NumRound())
+	t.Run(testString(name, params), func(t *testing.T) {
+		tc := testctx.tc
+		n := params.FVSlots()
+		t0 := params.PlainModulus()
+		key := newTestKey(tcParams.KeySize(), t0)
+		nonces := newTestNonces(n)
+		counter := newTestNonces(1)[0][:8]
+		enc := NewRtFEncryptor(tcParams, key)
+
+		// Integers of Z_t, including the extreme values, over two blocks
+		data := make([]uint64, n+3)
+		for i := range data {
+			data[i] = utils.RandUint64() % t0
+		}
+		data[0], data[1] = 0, t0-1
+
+		assert.Panics(t, func() { enc.EncryptIntNew([]uint64{t0}, nonces, counter) })
+		assert.Panics(t, func() { enc.EncryptIntNew(data, nonces[1:], counter) })
+
+		symCt := enc.EncryptIntNew(data, nonces, counter)
+		require.Len(t, symCt.Blocks, 2)
+		require.NoError(t, tc.CheckIntCiphertext(symCt))
+
+		c := symCt.Blocks[1][0]
+		symCt.Blocks[1][0] = t0
+		assert.Error(t, tc.CheckIntCiphertext(symCt))
+		symCt.Blocks[1][0] = c
+
+		kCt, err := tc.LoadKey(enc.EncryptKeyNew(testctx.pk))
+		require.NoError(t, err)
+		ks := tc.KeyStreamInt(kCt, symCt.Nonces, symCt.Counter)
+		require.Len(t, ks.Cts, tcParams.BlockSize())
+
+		cts, err := tc.TranscipherInt(symCt, ks)
+		require.NoError(t, err)
+		require.Len(t, cts, 2)
+
+		want := make([]uint64, 2*n)
+		copy(want, data)
+		decryptor := NewMFVDecryptor(params, testctx.sk)
+		encoder := NewMFVEncoder(params)
+		noiseEstimator := NewMFVNoiseEstimator(params, testctx.sk)
+		for s, ct := range cts {
+			require.Equal(t, tc.IntLevel(), ct.Level())
+			require.Greater(t, noiseEstimator.InvariantNoiseBudget(ct), 0)
+			require.Equal(t, want[s*n:(s+1)*n], encoder.DecodeUintSmallNew(decryptor.DecryptNew(ct)))
+		}
+
+		// The symmetric ciphertext and the keystream are checked: the keystream of another batch of nonces is
+		// rejected, and so is the keystream of another counter if the cipher uses it
+		_, err = tc.TranscipherInt(symCt, &KeyStreamBatch{Nonces: ks.Nonces, Counter: ks.Counter, Cts: ks.Cts[:1]})
+		assert.Error(t, err)
+		_, err = tc.TranscipherInt(symCt, &KeyStreamBatch{Nonces: newTestNonces(n), Counter: ks.Counter, Cts: ks.Cts})
+		assert.Error(t, err)
+		if tcParams.usesCounter() {
+			_, err = tc.TranscipherInt(symCt, &KeyStreamBatch{Nonces: ks.Nonces, Counter: newTestNonces(1)[0][:8], Cts: ks.Cts})
+			assert.Error(t, err)
+		}
+		symCt.Blocks[1][0] = t0
+		_, err = tc.TranscipherInt(symCt, ks)
+		assert.Error(t, err)
+		symCt.Blocks[1][0] = c
+
+		ks.Cts[1] = NewCiphertextFVLvl(params, 1, 0)
+		_, err = tc.TranscipherInt(symCt, ks)
+		assert.Error(t, err)
+	})
+}
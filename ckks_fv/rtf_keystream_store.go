@@ -0,0 +1,289 @@
+package ckks_fv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NonceRange is the range of Count nonces starting at index Start in the stream of nonces derived from
+// BaseNonce (see StreamNonces), used with the given counter. The counter should be nil for the ciphers which
+// ignore it (HERA), as done by Transcipherer.StoreKeyStream and Transcipherer.TranscipherStreamFromStore.
+type NonceRange struct {
+	BaseNonce []byte
+	Counter   []byte
+	Start     uint64
+	Count     int
+}
+
+// Nonces returns the nonces of the range, i.e. the base nonce followed by the index of each nonce
+// in the stream, encoded as a big-endian uint64.
+func (rng NonceRange) Nonces() (nonces [][]byte) {
+	nonces = make([][]byte, rng.Count)
+	for i := range nonces {
+		nonces[i] = make([]byte, len(rng.BaseNonce)+8)
+		copy(nonces[i], rng.BaseNonce)
+		binary.BigEndian.PutUint64(nonces[i][len(rng.BaseNonce):], rng.Start+uint64(i))
+	}
+	return
+}
+
+// NonceRange returns the range of nonces of the b-th batch of the stream.
+func (stream *SymmetricStream) NonceRange(b int) NonceRange {
+	slots := stream.Batches[b].FVSlots()
+	return NonceRange{BaseNonce: stream.BaseNonce, Counter: stream.Counter, Start: uint64(b * slots), Count: slots}
+}
+
+// KeyStreamStore is an interface for a store of FV keystreams precomputed in the offline phase of the RtF
+// framework, keyed by nonce range. The keystream of a range is loaded and marked as used by Take, so that
+// a nonce is never used twice.
+type KeyStreamStore interface {
+	// Put saves the FV keystream of the nonce range, i.e. the ciphertexts returned by Transcipherer.KeyStream.
+	// It returns an error if a nonce of the range has already been stored or used.
+	Put(rng NonceRange, fvKeystreams []*Ciphertext) error
+
+	// Take loads the FV keystream of the nonce range, which should have been saved by Put with the
+	// same range, and marks it as used.
+	Take(rng NonceRange) (fvKeystreams []*Ciphertext, err error)
+}
+
+// KeyStreamStoreVersion is the version of the binary encoding of the entries of the keystream file store.
+const KeyStreamStoreVersion = 1
+
+const (
+	keyStreamExt = ".ks"   // Extension of the stored entries
+	usedExt      = ".used" // Extension of the used entries, whose keystream has been erased
+)
+
+type keyStreamFileStore struct {
+	dir string
+
+	mu    sync.Mutex
+	index map[string][]keyStreamSpan // Stored and used entries, by prefix of their file names
+}
+
+// keyStreamSpan is the span [start, start+count) of the nonces of an entry of the keystream file store.
+type keyStreamSpan struct {
+	start, count uint64
+	used         bool
+}
+
+// NewKeyStreamFileStore creates a KeyStreamStore which persists each nonce range in a file of the directory dir,
+// which is created if needed. An entry is marked as used by renaming its file, so that the store can be shared
+// by the offline batch jobs and the online phase, and its keystream is erased.
+// The entries of the directory are indexed when the store is created, and Put checks the overlaps against this
+// index, so that the directory should not be filled by several stores at the same time.
+func NewKeyStreamFileStore(dir string) (KeyStreamStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &keyStreamFileStore{dir: dir, index: make(map[string][]keyStreamSpan)}
+	for _, file := range files {
+		if prefix, span, ok := parseKeyStreamFileName(file.Name()); ok {
+			store.index[prefix] = append(store.index[prefix], span)
+		}
+	}
+	return store, nil
+}
+
+// parseKeyStreamFileName returns the prefix and the span of the entry of the given file name, and false
+// if the file is not an entry of the store.
+func parseKeyStreamFileName(name string) (prefix string, span keyStreamSpan, ok bool) {
+	ext := filepath.Ext(name)
+	if ext != keyStreamExt && ext != usedExt {
+		return
+	}
+
+	fields := strings.Split(strings.TrimSuffix(name, ext), "-")
+	if len(fields) != 3 {
+		return
+	}
+	start, err0 := strconv.ParseUint(fields[1], 16, 64)
+	count, err1 := strconv.ParseUint(fields[2], 10, 64)
+	if err0 != nil || err1 != nil {
+		return
+	}
+	return fields[0], keyStreamSpan{start: start, count: count, used: ext == usedExt}, true
+}
+
+// prefix returns the prefix of the file names of the ranges with the base nonce and the counter of rng.
+func (store *keyStreamFileStore) prefix(rng NonceRange) string {
+	h := sha256.New()
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, uint64(len(rng.BaseNonce)))
+	h.Write(length)
+	h.Write(rng.BaseNonce)
+	h.Write(rng.Counter)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (store *keyStreamFileStore) path(rng NonceRange, ext string) string {
+	return filepath.Join(store.dir, fmt.Sprintf("%s-%016x-%d%s", store.prefix(rng), rng.Start, rng.Count, ext))
+}
+
+// overlap returns an error if a stored or used entry of the index shares a nonce with rng.
+func (store *keyStreamFileStore) overlap(rng NonceRange) error {
+	for _, span := range store.index[store.prefix(rng)] {
+		if span.start < rng.Start+uint64(rng.Count) && rng.Start < span.start+span.count {
+			if span.used {
+				return fmt.Errorf("nonces [%d, %d) have already been used", span.start, span.start+span.count)
+			}
+			return fmt.Errorf("nonces [%d, %d) have already been stored", span.start, span.start+span.count)
+		}
+	}
+	return nil
+}
+
+// markUsed records in the index that the entry of rng has been used.
+func (store *keyStreamFileStore) markUsed(rng NonceRange) {
+	prefix := store.prefix(rng)
+	spans := store.index[prefix]
+	for i := range spans {
+		if spans[i].start == rng.Start && spans[i].count == uint64(rng.Count) {
+			spans[i].used = true
+			return
+		}
+	}
+	store.index[prefix] = append(spans, keyStreamSpan{start: rng.Start, count: uint64(rng.Count), used: true})
+}
+
+func (store *keyStreamFileStore) Put(rng NonceRange, fvKeystreams []*Ciphertext) (err error) {
+	if rng.Count <= 0 {
+		return fmt.Errorf("cannot Put: invalid nonce range of %d nonces", rng.Count)
+	}
+
+	data, err := marshalKeyStreamEntry(rng, fvKeystreams)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err = store.overlap(rng); err != nil {
+		return fmt.Errorf("cannot Put: %w", err)
+	}
+
+	// The entry is written to a temporary file first, so that a partially written entry is never loaded
+	path := store.path(rng, keyStreamExt)
+	if err = ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	prefix := store.prefix(rng)
+	store.index[prefix] = append(store.index[prefix], keyStreamSpan{start: rng.Start, count: uint64(rng.Count)})
+	return nil
+}
+
+func (store *keyStreamFileStore) Take(rng NonceRange) (fvKeystreams []*Ciphertext, err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	path, used := store.path(rng, keyStreamExt), store.path(rng, usedExt)
+	if _, err = os.Stat(used); err == nil {
+		return nil, fmt.Errorf("cannot Take: nonces [%d, %d) have already been used", rng.Start, rng.Start+uint64(rng.Count))
+	}
+
+	// The entry is marked as used before being read, so that it cannot be taken twice
+	if err = os.Rename(path, used); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot Take: no keystream stored for nonces [%d, %d)", rng.Start, rng.Start+uint64(rng.Count))
+		}
+		return nil, err
+	}
+	store.markUsed(rng)
+
+	data, err := ioutil.ReadFile(used)
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(used, nil, 0600); err != nil {
+		return nil, err
+	}
+
+	if fvKeystreams, err = unmarshalKeyStreamEntry(rng, data); err != nil {
+		return nil, fmt.Errorf("cannot Take: %w", err)
+	}
+	return
+}
+
+// marshalKeyStreamEntry encodes the nonce range and its FV keystream on a byte slice.
+func marshalKeyStreamEntry(rng NonceRange, fvKeystreams []*Ciphertext) (data []byte, err error) {
+	// 1 byte : version
+	// 4 byte : #elements
+	// then 8 byte : length + base nonce, 8 byte : length + counter, 8 byte : length + (8 byte : start, 8 byte : count)
+	// then for each ciphertext, 8 byte : length + ciphertext
+	data = []byte{KeyStreamStoreVersion}
+	count := make([]byte, 4)
+	binary.LittleEndian.PutUint32(count, uint32(3+len(fvKeystreams)))
+	data = append(data, count...)
+
+	bounds := make([]byte, 16)
+	binary.LittleEndian.PutUint64(bounds[:8], rng.Start)
+	binary.LittleEndian.PutUint64(bounds[8:], uint64(rng.Count))
+
+	data = appendWithLength(data, rng.BaseNonce)
+	data = appendWithLength(data, rng.Counter)
+	data = appendWithLength(data, bounds)
+
+	var b []byte
+	for _, ct := range fvKeystreams {
+		if b, err = ct.MarshalBinary(); err != nil {
+			return nil, err
+		}
+		data = appendWithLength(data, b)
+	}
+	return
+}
+
+// unmarshalKeyStreamEntry decodes an entry encoded by marshalKeyStreamEntry, and checks that it holds the keystream of rng.
+func unmarshalKeyStreamEntry(rng NonceRange, data []byte) (fvKeystreams []*Ciphertext, err error) {
+	if len(data) < 1 {
+		return nil, errors.New("too small bytearray")
+	}
+
+	if data[0] != KeyStreamStoreVersion {
+		return nil, fmt.Errorf("unsupported version %d (expected %d)", data[0], KeyStreamStoreVersion)
+	}
+
+	var b [][]byte
+	if b, err = splitWithLength(data[1:]); err != nil {
+		return nil, err
+	}
+
+	if len(b) < 3 || len(b[2]) != 16 {
+		return nil, errors.New("invalid keystream entry")
+	}
+
+	if !bytes.Equal(b[0], rng.BaseNonce) || !bytes.Equal(b[1], rng.Counter) ||
+		binary.LittleEndian.Uint64(b[2][:8]) != rng.Start || binary.LittleEndian.Uint64(b[2][8:]) != uint64(rng.Count) {
+		return nil, errors.New("the stored nonce range does not match")
+	}
+
+	fvKeystreams = make([]*Ciphertext, len(b)-3)
+	for i := range fvKeystreams {
+		fvKeystreams[i] = new(Ciphertext)
+		if err = fvKeystreams[i].UnmarshalBinary(b[3+i]); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
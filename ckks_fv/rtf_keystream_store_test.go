@@ -0,0 +1,107 @@
+package ckks_fv
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestKeyStreamStore(t *testing.T) {
+	tcParams := genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1])
+	testctx, err := genTestTranscipherContext(tcParams)
+	require.NoError(t, err)
+	params := testctx.params
+
+	dir, err := ioutil.TempDir("", "keystream")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	baseNonce := newTestNonces(1)[0]
+	counter := newTestNonces(1)[0][:8]
+
+	t.Run(testString("KeyStreamStore/PutTake/", params), func(t *testing.T) {
+		store, err := NewKeyStreamFileStore(dir)
+		require.NoError(t, err)
+
+		prng, err := utils.NewPRNG()
+		require.NoError(t, err)
+		cts := make([]*Ciphertext, 3)
+		for i := range cts {
+			cts[i] = NewCiphertextCKKSRandom(prng, params, 1, 0, params.Scale())
+		}
+		rng := NonceRange{BaseNonce: baseNonce, Counter: counter, Start: 1 << 40, Count: 8}
+
+		require.NoError(t, store.Put(rng, cts))
+		assert.Error(t, store.Put(NonceRange{BaseNonce: baseNonce, Counter: counter, Start: rng.Start + 7, Count: 8}, cts))
+		require.NoError(t, store.Put(NonceRange{BaseNonce: baseNonce, Counter: counter, Start: rng.Start + 8, Count: 8}, cts))
+		require.NoError(t, store.Put(NonceRange{BaseNonce: baseNonce, Counter: counter[1:], Start: rng.Start, Count: 8}, cts))
+
+		// Only the exact range can be taken
+		_, err = store.Take(NonceRange{BaseNonce: baseNonce, Counter: counter, Start: rng.Start, Count: 4})
+		assert.Error(t, err)
+
+		have, err := store.Take(rng)
+		require.NoError(t, err)
+		require.Len(t, have, len(cts))
+		for i := range cts {
+			require.Equal(t, cts[i].Level(), have[i].Level())
+			for j := range cts[i].Value() {
+				require.Equal(t, cts[i].Value()[j].Coeffs, have[i].Value()[j].Coeffs)
+			}
+		}
+
+		// The used range is persisted and cannot be taken nor stored again
+		store, err = NewKeyStreamFileStore(dir)
+		require.NoError(t, err)
+		_, err = store.Take(rng)
+		assert.Error(t, err)
+		assert.Error(t, store.Put(rng, cts))
+		assert.Error(t, store.Put(NonceRange{BaseNonce: baseNonce, Counter: counter, Start: rng.Start - 4, Count: 8}, cts))
+	})
+
+	t.Run(testString("KeyStreamStore/TranscipherStreamFromStore/", params), func(t *testing.T) {
+		store, err := NewKeyStreamFileStore(dir)
+		require.NoError(t, err)
+
+		tc := testctx.tc
+		n := params.FVSlots()
+		key := newTestKey(tcParams.KeySize(), params.PlainModulus())
+		kCt := tc.EncKey(key)
+
+		data := make([]float64, n+3)
+		for i := range data {
+			data[i] = utils.RandFloat64(-1, 1)
+		}
+		stream := NewRtFEncryptor(tcParams, key).EncryptStreamNew(data, baseNonce, counter)
+
+		// Offline phase
+		assert.Error(t, tc.StoreKeyStream(store, kCt, NonceRange{BaseNonce: baseNonce, Counter: counter, Count: n - 1}))
+		for b := range stream.Batches {
+			require.NoError(t, tc.StoreKeyStream(store, kCt, stream.NonceRange(b)))
+		}
+
+		// HERA ignores the counter, so that a range with another counter has the same keystream
+		otherCounter := stream.NonceRange(0)
+		otherCounter.Counter = counter[1:]
+		assert.Error(t, tc.StoreKeyStream(store, kCt, otherCounter))
+
+		// Online phase
+		cts, err := tc.TranscipherStreamFromStore(store, stream)
+		require.NoError(t, err)
+		require.Len(t, cts, 2)
+
+		want := make([]float64, 2*n)
+		copy(want, data)
+		for k, ct := range cts {
+			testctx.verify(t, want[k*n:(k+1)*n], ct)
+		}
+
+		_, err = tc.TranscipherStreamFromStore(store, stream)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,199 @@
+package ckks_fv
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+)
+
+// SearchModDownParams searches a modulus switching schedule of the RtF framework for the given half-bootstrapping
+// parameters, cipher (cipherParam is the number of rounds for CipherHera, the index of RubatoParams for CipherRubato
+// and the index of PastaParams for CipherPasta), radix of the SlotsToCoeffs matrices and log of the number of FV slots
+// (0 for the default of TranscipherParameters, see TranscipherParameters.LogBlockSlots).
+//
+// The cipher and SlotsToCoeffs are evaluated on a random symmetric key under a temporary secret key, and moduli are
+// dropped as early as the noise allows. The returned schedule leaves at least minBudget bits of invariant noise budget
+// in every keystream ciphertext once switched down to level 0, as done by Transcipherer.KeyStream.
+// As the search evaluates the cipher homomorphically, it is as expensive as an offline phase of the transciphering.
+func SearchModDownParams(hbtpParams *HalfBootParameters, cipher CipherType, cipherParam, radix, logBlockSlots, minBudget int) (modDown ModDownParams, err error) {
+	tcParams := &TranscipherParameters{HalfBootParameters: *hbtpParams.Copy(), Cipher: cipher, CipherParam: cipherParam, Radix: radix, LogBlockSlots: logBlockSlots}
+	return searchModDownParams(tcParams, minBudget)
+}
+
+// SearchModDownParamsWithPlan is as SearchModDownParams, for the SlotsToCoeffs matrices of the given plan (see
+// PlanSlotsToCoeffs and TranscipherParameters.StCPlan) with plan.LogFVSlots FV slots.
+func SearchModDownParamsWithPlan(hbtpParams *HalfBootParameters, cipher CipherType, cipherParam int, plan *StCPlan, minBudget int) (modDown ModDownParams, err error) {
+	tcParams := &TranscipherParameters{HalfBootParameters: *hbtpParams.Copy(), Cipher: cipher, CipherParam: cipherParam, LogBlockSlots: plan.LogFVSlots, StCPlan: plan}
+	return searchModDownParams(tcParams, minBudget)
+}
+
+func searchModDownParams(tcParams *TranscipherParameters, minBudget int) (modDown ModDownParams, err error) {
+	if err = tcParams.HalfBootParameters.Validate(); err != nil {
+		return modDown, err
+	}
+	if err = tcParams.validateCipher(); err != nil {
+		return modDown, err
+	}
+	if err = tcParams.validateSlots(); err != nil {
+		return modDown, err
+	}
+	tcParams.HalfBootParameters.PlainModulus = tcParams.PlainModulus()
+
+	var params *Parameters
+	if params, err = tcParams.Params(); err != nil {
+		return modDown, err
+	}
+
+	s := newModDownSearcher(tcParams, params)
+
+	// Without modulus switching, the keystream reaches level 0 with the largest budget
+	stCt := s.cryptNoModSwitch()
+	if budget := s.budgetLevelZero(stCt, make([]int, s.stcDepth)); budget < minBudget {
+		return modDown, fmt.Errorf("invariant noise budget of %d bits at level 0 without modulus switching is below %d bits", budget, minBudget)
+	}
+
+	// The moduli which are not needed by the cipher without modulus switching are dropped before the first round,
+	// keeping minBudget bits of margin
+	budget := s.budgetNoModSwitch(stCt)
+
+	logQi := make([]int, params.QiCount())
+	for i, qi := range params.Qi() {
+		logQi[i] = int(math.Round(math.Log2(float64(qi))))
+	}
+
+	nbInitModDown := 0
+	cutBits := logQi[params.MaxLevel()]
+	for cutBits+minBudget < budget && nbInitModDown < params.MaxLevel()-1 {
+		nbInitModDown++
+		cutBits += logQi[params.MaxLevel()-nbInitModDown]
+	}
+
+	stCt, modDown.CipherModDown = s.cryptAutoModSwitch(nbInitModDown)
+	_, modDown.StCModDown = s.fvEvaluator.SlotsToCoeffsAutoModSwitch(stCt[0], s.noiseEstimator)
+
+	// The automatic schedule targets the smallest modulus allowed by the noise, so that the moduli dropped
+	// last are given back until the margin is reached
+	for s.budgetLevelZero(stCt, modDown.StCModDown) < minBudget {
+		if i := lastNonZero(modDown.StCModDown); i >= 0 {
+			modDown.StCModDown[i]--
+			continue
+		}
+		if i := lastNonZero(modDown.CipherModDown); i >= 0 {
+			modDown.CipherModDown[i]--
+			stCt = s.crypt(modDown.CipherModDown)
+			continue
+		}
+		return modDown, fmt.Errorf("invariant noise budget is below %d bits", minBudget)
+	}
+
+	return modDown, nil
+}
+
+// lastNonZero returns the index of the last non-zero element of v, or -1 if all the elements are zero.
+func lastNonZero(v []int) int {
+	for i := len(v) - 1; i >= 0; i-- {
+		if v[i] != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// modDownSearcher evaluates the cipher and SlotsToCoeffs under a temporary secret key for SearchModDownParams.
+type modDownSearcher struct {
+	tcParams *TranscipherParameters
+	params   *Parameters
+
+	fvEncoder      MFVEncoder
+	fvEncryptor    MFVEncryptor
+	fvEvaluator    MFVEvaluator
+	noiseEstimator MFVNoiseEstimator
+
+	cipher MFVCipher
+
+	stcDepth int // number of SlotsToCoeffs levels
+
+	kCt     []*Ciphertext
+	nonces  [][]byte
+	counter []byte
+}
+
+func newModDownSearcher(tcParams *TranscipherParameters, params *Parameters) (s *modDownSearcher) {
+	s = new(modDownSearcher)
+	s.tcParams = tcParams
+	s.params = params
+
+	kgen := NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairForHalfBoot(&tcParams.HalfBootParameters)
+
+	s.fvEncoder = NewMFVEncoder(params)
+	s.fvEncryptor = NewMFVEncryptorFromPk(params, pk)
+	s.noiseEstimator = NewMFVNoiseEstimator(params, sk)
+
+	pDcds := tcParams.genSlotToCoeffMat(s.fvEncoder)
+	s.stcDepth = len(pDcds[0]) - 1
+	rotkeys := kgen.GenRotationKeysForRotations(kgen.GenRotationIndexesForSlotsToCoeffsMat(pDcds), true, sk)
+	rlk := kgen.GenRelinearizationKey(sk)
+	s.fvEvaluator = NewMFVEvaluator(params, EvaluationKey{Rlk: rlk, Rtks: rotkeys}, pDcds)
+
+	key := make([]uint64, tcParams.KeySize())
+	for i := range key {
+		key[i] = SampleZqx(rand.Reader, params.PlainModulus())
+	}
+
+	s.nonces = make([][]byte, params.FVSlots())
+	for i := range s.nonces {
+		s.nonces[i] = make([]byte, 64)
+		rand.Read(s.nonces[i])
+	}
+	s.counter = make([]byte, 64)
+	rand.Read(s.counter)
+
+	s.cipher = NewMFVCipher(tcParams.Cipher, tcParams.CipherParam, params, s.fvEncoder, s.fvEncryptor, s.fvEvaluator, 0)
+	s.kCt = s.cipher.EncKey(key)
+	return
+}
+
+func (s *modDownSearcher) cryptNoModSwitch() []*Ciphertext {
+	s.cipher.Reset(0)
+	return s.cipher.CryptNoModSwitch(s.nonces, s.counter, s.kCt)
+}
+
+func (s *modDownSearcher) cryptAutoModSwitch(nbInitModDown int) ([]*Ciphertext, []int) {
+	s.cipher.Reset(nbInitModDown)
+	return s.cipher.CryptAutoModSwitch(s.nonces, s.counter, s.kCt, s.noiseEstimator)
+}
+
+func (s *modDownSearcher) crypt(cipherModDown []int) []*Ciphertext {
+	s.cipher.Reset(cipherModDown[0])
+	return s.cipher.Crypt(s.nonces, s.counter, s.kCt, cipherModDown)
+}
+
+// budgetNoModSwitch returns the minimum invariant noise budget of the keystream ciphertexts after SlotsToCoeffs
+// without modulus switching.
+func (s *modDownSearcher) budgetNoModSwitch(stCt []*Ciphertext) (budget int) {
+	budget = math.MaxInt32
+	for i := 0; i < s.tcParams.BlockSize(); i++ {
+		ct := s.fvEvaluator.SlotsToCoeffsNoModSwitch(stCt[i])
+		if b := s.noiseEstimator.InvariantNoiseBudget(ct); b < budget {
+			budget = b
+		}
+	}
+	return
+}
+
+// budgetLevelZero returns the minimum invariant noise budget of the keystream ciphertexts after SlotsToCoeffs
+// with the schedule stcModDown, once switched down to level 0.
+func (s *modDownSearcher) budgetLevelZero(stCt []*Ciphertext, stcModDown []int) (budget int) {
+	budget = math.MaxInt32
+	for i := 0; i < s.tcParams.BlockSize(); i++ {
+		ct := s.fvEvaluator.SlotsToCoeffs(stCt[i], stcModDown)
+		if ct.Level() > 0 {
+			s.fvEvaluator.ModSwitchMany(ct, ct, ct.Level())
+		}
+		if b := s.noiseEstimator.InvariantNoiseBudget(ct); b < budget {
+			budget = b
+		}
+	}
+	return
+}
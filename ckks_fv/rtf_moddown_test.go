@@ -0,0 +1,66 @@
+package ckks_fv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testModDownMinBudget is the invariant noise budget which is kept by the modulus switching schedules searched in the tests.
+const testModDownMinBudget = 12
+
+func TestSearchModDownParams(t *testing.T) {
+	hbtpParams := genTestHalfBootParams(RtFHeraParams[1])
+
+	t.Run("SearchModDownParams/InvalidParameters/", func(t *testing.T) {
+		_, err := SearchModDownParams(hbtpParams, CipherHera, 0, 0, 0, testModDownMinBudget)
+		require.Error(t, err)
+
+		_, err = SearchModDownParams(hbtpParams, CipherRubato, len(RubatoParams), 0, 0, testModDownMinBudget)
+		require.Error(t, err)
+
+		_, err = SearchModDownParams(hbtpParams, CipherHera, 4, 0, hbtpParams.LogSlots+1, testModDownMinBudget)
+		require.Error(t, err)
+
+		_, err = SearchModDownParams(hbtpParams, CipherHera, 4, 0, MinLogN-1, testModDownMinBudget)
+		require.Error(t, err)
+
+		_, err = SearchModDownParams(hbtpParams, CipherHera, 4, 0, 0, 1<<20)
+		require.Error(t, err)
+	})
+
+	// The searched schedule is used to transcipher
+	tcParams := &TranscipherParameters{HalfBootParameters: *hbtpParams, Cipher: CipherHera, CipherParam: 4, Radix: 0}
+
+	var err error
+	tcParams.ModDownParams, err = SearchModDownParams(hbtpParams, tcParams.Cipher, tcParams.CipherParam, tcParams.Radix, tcParams.LogBlockSlots, testModDownMinBudget)
+	require.NoError(t, err)
+	require.Len(t, tcParams.CipherModDown, tcParams.NumRound()+1)
+	require.Len(t, tcParams.StCModDown, 1)
+	require.NoError(t, tcParams.Validate())
+
+	testctx, err := genTestTranscipherContext(tcParams)
+	require.NoError(t, err)
+	testTranscipher(testctx, t)
+
+	// Schedule for the SlotsToCoeffs matrices of a plan
+	plan, err := PlanSlotsToCoeffs(testctx.params, StCBudget{})
+	require.NoError(t, err)
+	modDown, err := SearchModDownParamsWithPlan(hbtpParams, CipherHera, 4, plan, testModDownMinBudget)
+	require.NoError(t, err)
+	require.Len(t, modDown.StCModDown, plan.Depth)
+
+	_, err = SearchModDownParamsWithPlan(hbtpParams, CipherHera, 4, &StCPlan{LogFVSlots: plan.LogFVSlots}, testModDownMinBudget)
+	require.Error(t, err)
+
+	// Schedule for FV slots set by LogBlockSlots
+	tcParams = &TranscipherParameters{HalfBootParameters: *genTestHalfBootParams(RtFHeraParams[0]), Cipher: CipherHera, CipherParam: 5, Radix: 0, LogBlockSlots: 4}
+	tcParams.ModDownParams, err = SearchModDownParams(&tcParams.HalfBootParameters, tcParams.Cipher, tcParams.CipherParam, tcParams.Radix, tcParams.LogBlockSlots, testModDownMinBudget)
+	require.NoError(t, err)
+	require.NoError(t, tcParams.Validate())
+
+	testctx, err = genTestTranscipherContext(tcParams)
+	require.NoError(t, err)
+	require.Equal(t, tcParams.LogBlockSlots, testctx.params.LogFVSlots())
+	testTranscipher(testctx, t)
+}
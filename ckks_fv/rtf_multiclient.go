@@ -0,0 +1,309 @@
+package ckks_fv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+// SymmetricSlotCiphertext is the part of a multi-client symmetric ciphertext produced by a client which owns
+// a group of slots, i.e. the values of index Slots[j] in each block, which the transciphering outputs in the
+// CKKS slots of the same index. Each slot has its own nonce, and Blocks[s][j] is the value of the slot Slots[j]
+// in the s-th block, scaled and masked with the s-th keystream element of its nonce and the counter.
+// The parts of many clients are merged by Transcipherer.MergeSlotCiphertexts into a single SymmetricCiphertext.
+type SymmetricSlotCiphertext struct {
+	Cipher       CipherType
+	CipherParam  int
+	PlainModulus uint64
+	LogN         int
+	LogFVSlots   int
+
+	Slots   []int
+	Nonces  [][]byte
+	Counter []byte
+	Blocks  [][]uint64
+}
+
+// coeffIndex returns the index of the coefficient of a plaintext in R_t which holds the k-th value of a block
+// (cf. rtfEncryptor.encryptBlock).
+func coeffIndex(params *Parameters, k int) int {
+	slots := params.FVSlots()
+	logN := uint64(params.LogN())
+	if k < slots/2 {
+		return int(utils.BitReverse64(uint64(k), logN-1))
+	}
+	return int(utils.BitReverse64(uint64(k-slots/2), logN-1)) + params.N()/2
+}
+
+// fvSlotIndex returns the index of the FV slot whose keystream masks the k-th value of a block.
+func fvSlotIndex(params *Parameters, k int) int {
+	return int(utils.BitReverse64(uint64(coeffIndex(params, k)), uint64(params.LogN())))
+}
+
+// checkSlotGroup checks that the slots are distinct indexes of FV slots.
+func checkSlotGroup(params *Parameters, slots []int) error {
+	if len(slots) == 0 {
+		return errors.New("empty slot group")
+	}
+
+	used := make([]bool, params.FVSlots())
+	for _, k := range slots {
+		if k < 0 || k >= params.FVSlots() {
+			return fmt.Errorf("invalid slot %d", k)
+		}
+		if used[k] {
+			return fmt.Errorf("slot %d is given twice", k)
+		}
+		used[k] = true
+	}
+	return nil
+}
+
+// EncryptSlotsNew encrypts up to BlockSize*len(slots) real values in the group of slots of the client, with one
+// nonce per slot and the counter (ignored by HERA). The values data[s*len(slots):(s+1)*len(slots)] are encrypted
+// in the s-th block, and the last block is padded with zeros. Only the keystream of the given nonces is evaluated.
+func (enc *rtfEncryptor) EncryptSlotsNew(data []float64, slots []int, nonces [][]byte, counter []byte) (symCt *SymmetricSlotCiphertext) {
+	params := enc.params
+	t := params.PlainModulus()
+
+	if err := checkSlotGroup(params, slots); err != nil {
+		panic(fmt.Errorf("cannot EncryptSlotsNew: %w", err))
+	}
+
+	if len(nonces) != len(slots) {
+		panic(fmt.Sprintf("cannot EncryptSlotsNew: %d nonces are expected but %d given", len(slots), len(nonces)))
+	}
+
+	if len(data) > enc.tcParams.BlockSize()*len(slots) {
+		panic(fmt.Sprintf("cannot EncryptSlotsNew: too many values (maximum is %d)", enc.tcParams.BlockSize()*len(slots)))
+	}
+
+	var keystream [][]uint64
+	switch enc.tcParams.Cipher {
+	case CipherHera:
+		keystream = enc.hera.KeyStream(nonces)
+	case CipherRubato:
+		keystream = enc.rubato.KeyStream(nonces, counter)
+	default:
+		keystream = enc.pasta.KeyStream(nonces, counter)
+	}
+
+	symCt = new(SymmetricSlotCiphertext)
+	symCt.Cipher = enc.tcParams.Cipher
+	symCt.CipherParam = enc.tcParams.CipherParam
+	symCt.PlainModulus = t
+	symCt.LogN = params.LogN()
+	symCt.LogFVSlots = params.LogFVSlots()
+
+	symCt.Slots = make([]int, len(slots))
+	copy(symCt.Slots, slots)
+	symCt.Nonces = make([][]byte, len(nonces))
+	for j := range nonces {
+		symCt.Nonces[j] = make([]byte, len(nonces[j]))
+		copy(symCt.Nonces[j], nonces[j])
+	}
+	symCt.Counter = make([]byte, len(counter))
+	copy(symCt.Counter, counter)
+
+	nbBlocks := (len(data) + len(slots) - 1) / len(slots)
+	symCt.Blocks = make([][]uint64, nbBlocks)
+
+	values := make([]float64, len(slots))
+	scaled := make([][]uint64, 1)
+	scaled[0] = make([]uint64, len(slots))
+	for s := range symCt.Blocks {
+		for j := range values {
+			values[j] = 0
+		}
+		copy(values, data[s*len(slots):utils.MinInt((s+1)*len(slots), len(data))])
+
+		scaleUpVecExact(values, enc.tcParams.MessageScaling(), []uint64{t}, scaled)
+
+		symCt.Blocks[s] = make([]uint64, len(slots))
+		for j := range slots {
+			symCt.Blocks[s][j] = (scaled[0][j] + keystream[j][s]) % t
+		}
+	}
+	return
+}
+
+// EncryptKeySlotsNew is as EncryptKeyNew, but the key is only encrypted in the FV slots of the group of slots of
+// the client, and is zero elsewhere, so that the bundles of many clients can be merged by Transcipherer.MergeKeyBundles.
+func (enc *rtfEncryptor) EncryptKeySlotsNew(pk *PublicKey, slots []int) (bundle *SymmetricKeyBundle) {
+	if err := checkSlotGroup(enc.params, slots); err != nil {
+		panic(fmt.Errorf("cannot EncryptKeySlotsNew: %w", err))
+	}
+
+	bundle = enc.encryptKey(pk, slots)
+	bundle.Slots = make([]int, len(slots))
+	copy(bundle.Slots, slots)
+	return
+}
+
+// MergeSlotCiphertexts merges the symmetric ciphertexts of many clients, whose groups of slots should be disjoint,
+// into a single symmetric ciphertext that can be transciphered with the keystream of the merged key bundle
+// (see MergeKeyBundles). All the clients should use the same counter and nonces of the same length.
+// The slots which are not owned by a client hold no data, and are transciphered to meaningless values.
+func (tc *Transcipherer) MergeSlotCiphertexts(parts []*SymmetricSlotCiphertext) (symCt *SymmetricCiphertext, err error) {
+	params := tc.params
+	slots := params.FVSlots()
+	t := params.PlainModulus()
+
+	if len(parts) == 0 {
+		return nil, errors.New("cannot MergeSlotCiphertexts: no symmetric ciphertext given")
+	}
+
+	owned := make([]bool, slots)
+	nonceLen := -1
+	nbBlocks := 0
+	for c, part := range parts {
+		if part.Cipher != tc.Cipher || part.CipherParam != tc.CipherParam {
+			return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: cipher %v (%d) does not match the transcipherer cipher %v (%d)", c, part.Cipher, part.CipherParam, tc.Cipher, tc.CipherParam)
+		}
+
+		if part.LogN != params.LogN() || part.LogFVSlots != params.LogFVSlots() || part.PlainModulus != t {
+			return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: LogN, LogFVSlots or plaintext modulus does not match the parameters", c)
+		}
+
+		if err = checkSlotGroup(params, part.Slots); err != nil {
+			return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: %w", c, err)
+		}
+
+		if len(part.Nonces) != len(part.Slots) {
+			return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: %d nonces are expected but %d given", c, len(part.Slots), len(part.Nonces))
+		}
+
+		if !bytes.Equal(part.Counter, parts[0].Counter) {
+			return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: counter does not match the counter of client 0", c)
+		}
+
+		for j, k := range part.Slots {
+			if owned[k] {
+				return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: slot %d is owned by another client", c, k)
+			}
+			owned[k] = true
+
+			if nonceLen == -1 {
+				nonceLen = len(part.Nonces[j])
+			}
+			if len(part.Nonces[j]) != nonceLen {
+				return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: nonces should have the same length", c)
+			}
+		}
+
+		for s, block := range part.Blocks {
+			if len(block) != len(part.Slots) {
+				return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: block %d should have %d values", c, s, len(part.Slots))
+			}
+			for _, v := range block {
+				if v >= t {
+					return nil, fmt.Errorf("cannot MergeSlotCiphertexts: client %d: block %d has a value larger than the plaintext modulus", c, s)
+				}
+			}
+		}
+
+		nbBlocks = utils.MaxInt(nbBlocks, len(part.Blocks))
+	}
+
+	if nbBlocks > tc.BlockSize() {
+		return nil, fmt.Errorf("cannot MergeSlotCiphertexts: %d blocks given but at most %d are supported", nbBlocks, tc.BlockSize())
+	}
+
+	symCt = new(SymmetricCiphertext)
+	symCt.Cipher = tc.Cipher
+	symCt.CipherParam = tc.CipherParam
+	symCt.PlainModulus = t
+	symCt.LogN = params.LogN()
+	symCt.LogFVSlots = params.LogFVSlots()
+
+	symCt.Counter = make([]byte, len(parts[0].Counter))
+	copy(symCt.Counter, parts[0].Counter)
+
+	symCt.Nonces = make([][]byte, slots)
+	for i := range symCt.Nonces {
+		symCt.Nonces[i] = make([]byte, nonceLen)
+	}
+
+	symCt.Blocks = make([]*PlaintextRingT, nbBlocks)
+	for s := range symCt.Blocks {
+		symCt.Blocks[s] = NewPlaintextRingT(params)
+		symCt.Blocks[s].SetScale(tc.MessageScaling())
+	}
+
+	for _, part := range parts {
+		for j, k := range part.Slots {
+			copy(symCt.Nonces[fvSlotIndex(params, k)], part.Nonces[j])
+			for s, block := range part.Blocks {
+				symCt.Blocks[s].Value()[0].Coeffs[0][coeffIndex(params, k)] = block[j]
+			}
+		}
+	}
+
+	return symCt, nil
+}
+
+// MergeKeyBundles adds the key bundles of many clients, produced by RtFEncryptor.EncryptKeySlotsNew with disjoint
+// groups of slots, into a single key bundle whose FV slots hold the key of the client owning them.
+// The noise of the merged key is the sum of the noises of the bundles, so that merging c bundles costs up to
+// log2(c) bits of invariant noise budget compared to the key of a single client. The modulus switching schedule
+// should leave this margin, e.g. by adding MergeBudgetLoss(c) to the minimum budget given to SearchModDownParams.
+func (tc *Transcipherer) MergeKeyBundles(bundles []*SymmetricKeyBundle) (merged *SymmetricKeyBundle, err error) {
+	if len(bundles) == 0 {
+		return nil, errors.New("cannot MergeKeyBundles: no key bundle given")
+	}
+
+	owned := make([]bool, tc.params.FVSlots())
+	var kCts [][]*Ciphertext
+	for c, bundle := range bundles {
+		if bundle.Slots == nil {
+			return nil, fmt.Errorf("cannot MergeKeyBundles: client %d: the key is not encrypted in a group of slots", c)
+		}
+
+		if err = checkSlotGroup(tc.params, bundle.Slots); err != nil {
+			return nil, fmt.Errorf("cannot MergeKeyBundles: client %d: %w", c, err)
+		}
+
+		for _, k := range bundle.Slots {
+			if owned[k] {
+				return nil, fmt.Errorf("cannot MergeKeyBundles: client %d: slot %d is owned by another client", c, k)
+			}
+			owned[k] = true
+		}
+
+		var kCt []*Ciphertext
+		if kCt, err = tc.LoadKey(bundle); err != nil {
+			return nil, fmt.Errorf("cannot MergeKeyBundles: client %d: %w", c, err)
+		}
+		kCts = append(kCts, kCt)
+	}
+
+	merged = new(SymmetricKeyBundle)
+	*merged = *bundles[0]
+	merged.Slots = nil
+	for k := range owned {
+		if owned[k] {
+			merged.Slots = append(merged.Slots, k)
+		}
+	}
+
+	merged.Ciphertexts = make([]*Ciphertext, len(kCts[0]))
+	for i := range merged.Ciphertexts {
+		merged.Ciphertexts[i] = kCts[0][i].CopyNew().Ciphertext()
+		for _, kCt := range kCts[1:] {
+			tc.fvEvaluator.Add(merged.Ciphertexts[i], kCt[i], merged.Ciphertexts[i])
+		}
+	}
+	return merged, nil
+}
+
+// MergeBudgetLoss returns the number of bits of invariant noise budget, i.e. ceil(log2(clients)), which can be lost
+// by merging the key bundles of the given number of clients with MergeKeyBundles.
+func MergeBudgetLoss(clients int) int {
+	if clients <= 1 {
+		return 0
+	}
+	return bits.Len(uint(clients - 1))
+}
@@ -0,0 +1,98 @@
+package ckks_fv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestMultiClientTranscipher(t *testing.T) {
+	// Three clients sharing the slots of a batch of two blocks
+	groups := [][]int{{0, 1, 2, 3, 4}, {5, 9, 7, 6, 8}, {10, 11, 12, 13, 14, 15}}
+
+	// The schedule leaves the budget lost by merging the keys of the clients
+	tcParams := &TranscipherParameters{HalfBootParameters: *genTestHalfBootParams(RtFHeraParams[1]), Cipher: CipherHera, CipherParam: 4, Radix: 0}
+	var err error
+	tcParams.ModDownParams, err = SearchModDownParams(&tcParams.HalfBootParameters, tcParams.Cipher, tcParams.CipherParam, tcParams.Radix, 0, testModDownMinBudget+MergeBudgetLoss(len(groups)))
+	require.NoError(t, err)
+
+	testctx, err := genTestTranscipherContext(tcParams)
+	require.NoError(t, err)
+	params := testctx.params
+
+	t.Run(testString("MultiClientTranscipher/", params), func(t *testing.T) {
+		tc := testctx.tc
+		n := params.FVSlots()
+		counter := newTestNonces(1)[0][:8]
+
+		want := make([]float64, 2*n)
+		bundles := make([]*SymmetricKeyBundle, len(groups))
+		parts := make([]*SymmetricSlotCiphertext, len(groups))
+		for c, slots := range groups {
+			enc := NewRtFEncryptor(tcParams, newTestKey(tcParams.KeySize(), params.PlainModulus()))
+
+			data := make([]float64, 2*len(slots)-1)
+			for i := range data {
+				data[i] = utils.RandFloat64(-1, 1)
+				want[(i/len(slots))*n+slots[i%len(slots)]] = data[i]
+			}
+
+			bundles[c] = enc.EncryptKeySlotsNew(testctx.pk, slots)
+			parts[c] = enc.EncryptSlotsNew(data, slots, newTestNonces(len(slots)), counter)
+		}
+
+		// The slot group goes through the serialization of the bundle
+		data, err := bundles[1].MarshalBinary()
+		require.NoError(t, err)
+		bundles[1] = new(SymmetricKeyBundle)
+		require.NoError(t, bundles[1].UnmarshalBinary(data))
+		require.Equal(t, groups[1], bundles[1].Slots)
+
+		merged, err := tc.MergeKeyBundles(bundles)
+		require.NoError(t, err)
+		require.Len(t, merged.Slots, n)
+		kCt, err := tc.LoadKey(merged)
+		require.NoError(t, err)
+
+		symCt, err := tc.MergeSlotCiphertexts(parts)
+		require.NoError(t, err)
+		require.NoError(t, tc.CheckCiphertext(symCt))
+		require.Len(t, symCt.Blocks, 2)
+
+		cts, err := tc.Transcipher(symCt, tc.KeyStream(kCt, symCt.Nonces, symCt.Counter))
+		require.NoError(t, err)
+		for s, ct := range cts {
+			testctx.verify(t, want[s*n:(s+1)*n], ct)
+		}
+	})
+
+	t.Run("MultiClientTranscipher/MergeBudgetLoss/", func(t *testing.T) {
+		assert.Equal(t, []int{0, 0, 1, 2, 2, 3}, []int{MergeBudgetLoss(0), MergeBudgetLoss(1), MergeBudgetLoss(2), MergeBudgetLoss(3), MergeBudgetLoss(4), MergeBudgetLoss(5)})
+	})
+
+	t.Run(testString("MultiClientTranscipher/Errors/", params), func(t *testing.T) {
+		tc := testctx.tc
+		enc := NewRtFEncryptor(tcParams, newTestKey(tcParams.KeySize(), params.PlainModulus()))
+		counter := newTestNonces(1)[0][:8]
+
+		assert.Panics(t, func() { enc.EncryptKeySlotsNew(testctx.pk, []int{0, 0}) })
+		assert.Panics(t, func() { enc.EncryptSlotsNew([]float64{0}, []int{params.FVSlots()}, newTestNonces(1), counter) })
+
+		part0 := enc.EncryptSlotsNew([]float64{0.5}, []int{0, 1}, newTestNonces(2), counter)
+		part1 := enc.EncryptSlotsNew([]float64{0.5}, []int{1, 2}, newTestNonces(2), counter)
+		_, err := tc.MergeSlotCiphertexts([]*SymmetricSlotCiphertext{part0, part1})
+		assert.Error(t, err)
+
+		part1 = enc.EncryptSlotsNew([]float64{0.5}, []int{2, 3}, newTestNonces(2), counter[1:])
+		_, err = tc.MergeSlotCiphertexts([]*SymmetricSlotCiphertext{part0, part1})
+		assert.Error(t, err)
+
+		_, err = tc.MergeKeyBundles([]*SymmetricKeyBundle{enc.EncryptKeySlotsNew(testctx.pk, []int{0, 1}), enc.EncryptKeySlotsNew(testctx.pk, []int{1})})
+		assert.Error(t, err)
+		_, err = tc.MergeKeyBundles([]*SymmetricKeyBundle{enc.EncryptKeyNew(testctx.pk)})
+		assert.Error(t, err)
+	})
+}
@@ -1,8 +1,15 @@
 package ckks_fv
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
 /* ModDown Parameters*/
 // ModDownParams denotes optimized modulus switching indices for given RtF parameters
-// (See github.com/smilecjf/lattigo/v2/examples/ckks_fv/main for an example)
+// (See SearchModDownParams, and github.com/smilecjf/lattigo/v2/examples/ckks_fv/main for an example)
 // StcModDownParams assumes that the decoding matrix is factorized with radix 2.
 
 type ModDownParams struct {
@@ -10,6 +17,57 @@ type ModDownParams struct {
 	StCModDown    []int
 }
 
+// Equals compares two ModDownParams for equality.
+func (m ModDownParams) Equals(other ModDownParams) bool {
+	return utils.EqualSliceInt(m.CipherModDown, other.CipherModDown) && utils.EqualSliceInt(m.StCModDown, other.StCModDown)
+}
+
+// MarshalBinary returns a []byte representation of the ModDownParams.
+// Each mod down index is stored on one byte.
+func (m ModDownParams) MarshalBinary() ([]byte, error) {
+	if len(m.CipherModDown) > 0xFF || len(m.StCModDown) > 0xFF {
+		return nil, errors.New("cannot MarshalBinary: too many mod down indices")
+	}
+
+	// Data 2 byte + #indices byte:
+	// 1 byte : #CipherModDown
+	// 1 byte : #StCModDown
+	b := utils.NewBuffer(make([]byte, 0, 2+len(m.CipherModDown)+len(m.StCModDown)))
+
+	b.WriteUint8(uint8(len(m.CipherModDown)))
+	b.WriteUint8(uint8(len(m.StCModDown)))
+	for _, indices := range [][]int{m.CipherModDown, m.StCModDown} {
+		for _, idx := range indices {
+			if idx < 0 || idx > 0xFF {
+				return nil, fmt.Errorf("cannot MarshalBinary: invalid mod down index %d", idx)
+			}
+			b.WriteUint8(uint8(idx))
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a []byte into a ModDownParams struct.
+func (m *ModDownParams) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 2 || len(data) != 2+int(data[0])+int(data[1]) {
+		return errors.New("invalid mod down parameters encoding")
+	}
+
+	m.CipherModDown = make([]int, data[0])
+	m.StCModDown = make([]int, data[1])
+
+	pointer := 2
+	for _, indices := range [][]int{m.CipherModDown, m.StCModDown} {
+		for i := range indices {
+			indices[i] = int(data[pointer])
+			pointer++
+		}
+	}
+
+	return nil
+}
+
 // HERA mod down indices for 80-bit security parameter
 var HeraModDownParams80 = []ModDownParams{
 	{
@@ -610,3 +668,25 @@ var RtFRubatoParams = []*HalfBootParameters{
 		MaxN1N2Ratio: 16.0,
 	},
 }
+
+// RtFHeraDenseParams are the parameters of RtFHeraParams for a uniform ternary secret key, which use the
+// sparse-secret encapsulation of the half-bootstrapping with an ephemeral secret key of Hamming weight 192,
+// so that the sine evaluation is unchanged.
+var RtFHeraDenseParams = withEphemeralSecret(RtFHeraParams)
+
+// RtFRubatoDenseParams are the parameters of RtFRubatoParams for a uniform ternary secret key, which use the
+// sparse-secret encapsulation of the half-bootstrapping with an ephemeral secret key of Hamming weight 192,
+// so that the sine evaluation is unchanged.
+var RtFRubatoDenseParams = withEphemeralSecret(RtFRubatoParams)
+
+// withEphemeralSecret returns copies of the HalfBootParameters with a uniform ternary secret key (H = 0)
+// and an ephemeral secret key of Hamming weight H.
+func withEphemeralSecret(hbtpParams []*HalfBootParameters) (denseParams []*HalfBootParameters) {
+	denseParams = make([]*HalfBootParameters, len(hbtpParams))
+	for i, hb := range hbtpParams {
+		denseParams[i] = hb.Copy()
+		denseParams[i].EphemeralSecretWeight = hb.H
+		denseParams[i].H = 0
+	}
+	return
+}
@@ -0,0 +1,176 @@
+package ckks_fv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+// SymmetricStream is a stream of an arbitrary number of real values encrypted in the RtF framework.
+// The values are cut into batches of BlockSize*FVSlots values, each encrypted in a SymmetricCiphertext.
+// The nonce of the i-th FV slot of the b-th batch is derived from the base nonce and the index b*FVSlots+i
+// of the FV slot in the stream (see StreamNonces), and all the batches share the same counter.
+// The last batch may have fewer blocks, and its last block is padded with zeros which are masked with the
+// keystream as the data.
+type SymmetricStream struct {
+	BaseNonce []byte
+	Counter   []byte
+	Length    int // Number of values in the stream
+	Batches   []*SymmetricCiphertext
+}
+
+// StreamNonces returns the nonces of the b-th batch of a stream with fvSlots FV slots per batch, i.e. the base nonce
+// followed by the index b*fvSlots+i of the i-th FV slot in the stream, encoded as a big-endian uint64.
+func StreamNonces(baseNonce []byte, batch, fvSlots int) (nonces [][]byte) {
+	return NonceRange{BaseNonce: baseNonce, Start: uint64(batch * fvSlots), Count: fvSlots}.Nonces()
+}
+
+// EncryptStreamNew encrypts an arbitrary number of real values in batches of BlockSize*FVSlots values, with the
+// nonces derived from the base nonce by StreamNonces and the counter (ignored by HERA).
+func (enc *rtfEncryptor) EncryptStreamNew(data []float64, baseNonce []byte, counter []byte) (stream *SymmetricStream) {
+	slots := enc.params.FVSlots()
+	batchSize := enc.tcParams.BlockSize() * slots
+
+	stream = new(SymmetricStream)
+	stream.BaseNonce = make([]byte, len(baseNonce))
+	copy(stream.BaseNonce, baseNonce)
+	stream.Counter = make([]byte, len(counter))
+	copy(stream.Counter, counter)
+	stream.Length = len(data)
+
+	stream.Batches = make([]*SymmetricCiphertext, (len(data)+batchSize-1)/batchSize)
+	for b := range stream.Batches {
+		batch := data[b*batchSize : utils.MinInt((b+1)*batchSize, len(data))]
+		stream.Batches[b] = enc.EncryptNew(batch, StreamNonces(baseNonce, b, slots), counter)
+	}
+	return
+}
+
+// CheckStream checks that the symmetric stream has been produced with the cipher and the parameters of the
+// Transcipherer, that the nonces of each batch are derived from the base nonce, and that the number of blocks
+// of the batches matches the length of the stream. Streams hold real values, so that complex batches
+// (see RtFEncryptor.EncryptComplexNew) are rejected.
+func (tc *Transcipherer) CheckStream(stream *SymmetricStream) error {
+	slots := tc.params.FVSlots()
+	batchSize := tc.BlockSize() * slots
+
+	if stream.Length < 0 {
+		return fmt.Errorf("invalid stream length %d", stream.Length)
+	}
+
+	if nbBatches := (stream.Length + batchSize - 1) / batchSize; len(stream.Batches) != nbBatches {
+		return fmt.Errorf("%d batches are expected for %d values but %d given", nbBatches, stream.Length, len(stream.Batches))
+	}
+
+	for b, symCt := range stream.Batches {
+		if err := tc.CheckCiphertext(symCt); err != nil {
+			return fmt.Errorf("batch %d: %w", b, err)
+		}
+
+		if symCt.Complex {
+			return fmt.Errorf("batch %d: complex ciphertexts are not supported in streams", b)
+		}
+
+		nbValues := utils.MinInt(stream.Length-b*batchSize, batchSize)
+		if nbBlocks := (nbValues + slots - 1) / slots; len(symCt.Blocks) != nbBlocks {
+			return fmt.Errorf("batch %d: %d blocks are expected but %d given", b, nbBlocks, len(symCt.Blocks))
+		}
+
+		if !bytes.Equal(symCt.Counter, stream.Counter) {
+			return fmt.Errorf("batch %d: counter does not match the stream counter", b)
+		}
+
+		for i, nonce := range StreamNonces(stream.BaseNonce, b, slots) {
+			if !bytes.Equal(symCt.Nonces[i], nonce) {
+				return fmt.Errorf("batch %d: nonce %d is not derived from the base nonce", b, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// TranscipherStream transciphers a symmetric stream to an ordered sequence of CKKS ciphertexts, evaluating the
+// keystream of each batch with KeyStream and transciphering its blocks with Transcipher.
+// The k-th ciphertext holds the values [k*m, (k+1)*m) of the stream, where m is FVSlots/2 if data are encoded in
+// full coefficients and FVSlots otherwise. The values of the last ciphertext after the end of the stream are zeros.
+func (tc *Transcipherer) TranscipherStream(kCt []*Ciphertext, stream *SymmetricStream) (cts []*Ciphertext, err error) {
+	return tc.transcipherStream(stream, func(b int) (*KeyStreamBatch, error) {
+		return tc.KeyStream(kCt, stream.Batches[b].Nonces, stream.Counter), nil
+	})
+}
+
+// TranscipherStreamFromStore is as TranscipherStream, but takes the keystream of each batch from a store
+// filled in the offline phase by StoreKeyStream, which marks it as used.
+func (tc *Transcipherer) TranscipherStreamFromStore(store KeyStreamStore, stream *SymmetricStream) (cts []*Ciphertext, err error) {
+	return tc.transcipherStream(stream, func(b int) (*KeyStreamBatch, error) {
+		rng := tc.keyStreamRange(stream.NonceRange(b))
+		fvKeystreams, err := store.Take(rng)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fvKeystreams) != tc.BlockSize() {
+			return nil, fmt.Errorf("%d keystream ciphertexts are expected but %d stored", tc.BlockSize(), len(fvKeystreams))
+		}
+		for _, ct := range fvKeystreams {
+			if ct.Degree() != 1 || ct.Level() != 0 || ct.Value()[0].Degree() != tc.params.N() {
+				return nil, errors.New("stored keystream ciphertexts should be of degree 1 at level 0")
+			}
+		}
+		return newKeyStreamBatch(rng.Nonces(), stream.Counter, fvKeystreams), nil
+	})
+}
+
+// transcipherStream transciphers the stream with the FV keystream of the b-th batch given by keyStream(b).
+func (tc *Transcipherer) transcipherStream(stream *SymmetricStream, keyStream func(b int) (*KeyStreamBatch, error)) (cts []*Ciphertext, err error) {
+	if err = tc.CheckStream(stream); err != nil {
+		return nil, fmt.Errorf("invalid symmetric stream: %w", err)
+	}
+
+	valuesPerCt := tc.params.FVSlots()
+	if tc.FullCoeffs() {
+		valuesPerCt /= 2
+	}
+
+	numCts := (stream.Length + valuesPerCt - 1) / valuesPerCt
+	cts = make([]*Ciphertext, 0, numCts+1)
+	for b, symCt := range stream.Batches {
+		var ks *KeyStreamBatch
+		if ks, err = keyStream(b); err != nil {
+			return nil, fmt.Errorf("batch %d: %w", b, err)
+		}
+
+		var batchCts []*Ciphertext
+		if batchCts, err = tc.Transcipher(symCt, ks); err != nil {
+			return nil, fmt.Errorf("batch %d: %w", b, err)
+		}
+		cts = append(cts, batchCts...)
+	}
+
+	// The second half of the last block is dropped if it holds no value of the stream
+	return cts[:numCts], nil
+}
+
+// StoreKeyStream is the offline phase of the transciphering of a stream. It evaluates the FV keystream of the
+// nonce range with KeyStream, for the encrypted symmetric key kCt, and saves it in the store.
+// The range should have FVSlots nonces, e.g. the range of a batch of a stream (see SymmetricStream.NonceRange).
+// The counter of the range is not part of the key of the entry if the cipher ignores it (HERA).
+func (tc *Transcipherer) StoreKeyStream(store KeyStreamStore, kCt []*Ciphertext, rng NonceRange) error {
+	if rng.Count != tc.params.FVSlots() {
+		return fmt.Errorf("cannot StoreKeyStream: the nonce range should have %d nonces but has %d", tc.params.FVSlots(), rng.Count)
+	}
+	rng = tc.keyStreamRange(rng)
+	return store.Put(rng, tc.KeyStream(kCt, rng.Nonces(), rng.Counter).Cts)
+}
+
+// keyStreamRange returns rng without its counter if the cipher ignores it, so that two ranges of a counter-less
+// cipher which differ only by their counter are the same entry of a KeyStreamStore, as they share their keystream.
+func (tc *Transcipherer) keyStreamRange(rng NonceRange) NonceRange {
+	if !tc.usesCounter() {
+		rng.Counter = nil
+	}
+	return rng
+}
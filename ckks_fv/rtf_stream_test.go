@@ -0,0 +1,98 @@
+package ckks_fv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestTranscipherStream(t *testing.T) {
+	t.Run("TranscipherStream/StreamNonces/", func(t *testing.T) {
+		baseNonce := newTestNonces(1)[0]
+		nonces := StreamNonces(baseNonce, 1, 4)
+		require.Len(t, nonces, 4)
+		for i, nonce := range nonces {
+			require.True(t, bytes.HasPrefix(nonce, baseNonce))
+			require.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, byte(4 + i)}, nonce[len(baseNonce):])
+		}
+		assert.Equal(t, StreamNonces(baseNonce, 0, 8)[4:], nonces)
+	})
+
+	// The stream of the first case spans a full batch and a partial batch of two blocks.
+	// The stream of the second case (full coefficients) is a partial batch of two blocks,
+	// the second of which fills only one of its two CKKS ciphertexts.
+	testCases := []struct {
+		tcParams *TranscipherParameters
+		extra    int // Number of values after the full batches
+		batches  int // Number of full batches
+	}{
+		{genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1]), 19, 1},
+		{genTestTranscipherParams(RtFHeraParams[0], CipherHera, 5, 2, HeraModDownParams128[0]), 1024 + 3, 0},
+	}
+
+	for _, testCase := range testCases {
+		testctx, err := genTestTranscipherContext(testCase.tcParams)
+		require.NoError(t, err)
+		testTranscipherStream(testctx, t, testCase.batches, testCase.extra)
+	}
+}
+
+func testTranscipherStream(testctx *testTranscipherContext, t *testing.T, batches, extra int) {
+	tcParams := testctx.tcParams
+	params := testctx.params
+
+	name := fmt.Sprintf("TranscipherStream/%v/NumRound=%d/Radix=%d/", tcParams.Cipher, tcParams.NumRound(), tcParams.Radix)
+	t.Run(testString(name, params), func(t *testing.T) {
+		tc := testctx.tc
+		n := params.FVSlots()
+		key := newTestKey(tcParams.KeySize(), params.PlainModulus())
+		baseNonce := newTestNonces(1)[0]
+		counter := newTestNonces(1)[0][:8]
+
+		data := make([]float64, batches*tcParams.BlockSize()*n+extra)
+		for i := range data {
+			data[i] = utils.RandFloat64(-1, 1)
+		}
+
+		stream := NewRtFEncryptor(tcParams, key).EncryptStreamNew(data, baseNonce, counter)
+		require.Equal(t, len(data), stream.Length)
+		require.Len(t, stream.Batches, batches+1)
+		require.Len(t, stream.Batches[batches].Blocks, (extra+n-1)/n)
+		require.NoError(t, tc.CheckStream(stream))
+
+		// Invalid streams
+		stream.Length += n
+		assert.Error(t, tc.CheckStream(stream))
+		stream.Length -= n
+		stream.Counter[0]++
+		assert.Error(t, tc.CheckStream(stream))
+		stream.Counter[0]--
+		stream.Batches[0].Complex = true
+		assert.Error(t, tc.CheckStream(stream))
+		stream.Batches[0].Complex = false
+		stream.BaseNonce[0]++
+		_, err := tc.TranscipherStream(tc.EncKey(key), stream)
+		assert.Error(t, err)
+		stream.BaseNonce[0]--
+
+		cts, err := tc.TranscipherStream(tc.EncKey(key), stream)
+		require.NoError(t, err)
+
+		valuesPerCt := n
+		if tcParams.FullCoeffs() {
+			valuesPerCt = n / 2
+		}
+		require.Len(t, cts, (len(data)+valuesPerCt-1)/valuesPerCt)
+
+		want := make([]float64, len(cts)*valuesPerCt)
+		copy(want, data)
+		for k, ct := range cts {
+			testctx.verify(t, want[k*valuesPerCt:(k+1)*valuesPerCt], ct)
+		}
+	})
+}
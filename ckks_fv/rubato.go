@@ -0,0 +1,222 @@
+package ckks_fv
+
+import (
+	"fmt"
+
+	"github.com/ldsec/lattigo/v2/ring"
+	"github.com/ldsec/lattigo/v2/utils"
+	"golang.org/x/crypto/sha3"
+)
+
+// Rubato is an interface for the client-side (plaintext) Rubato stream cipher.
+// Without noise, the keystream it produces is bit-identical to the one evaluated homomorphically by MFVRubato.
+type Rubato interface {
+	Crypt(nonce []byte, counter []byte) (keystream []uint64)
+	CryptNoNoise(nonce []byte, counter []byte) (keystream []uint64)
+	KeyStream(nonces [][]byte, counter []byte) (keystream [][]uint64)
+	Blocksize() int
+	OutputSize() int
+	NumRound() int
+	PlainModulus() uint64
+	Sigma() float64
+}
+
+type plainRubato struct {
+	rubatoParam  int
+	blocksize    int
+	numRound     int
+	plainModulus uint64
+	sigma        float64
+	key          []uint64
+	mat          []uint64 // first row of the circulant mixing matrix
+
+	state           []uint64
+	buf             []uint64
+	rks             [][]uint64 // RoundKeys[round][state]
+	gaussianSampler *ring.GaussianSampler
+}
+
+// NewRubato creates a new client-side Rubato cipher with the parameters RubatoParams[rubatoParam].
+// The key should consist of Blocksize elements of Z_PlainModulus.
+func NewRubato(rubatoParam int, key []uint64) Rubato {
+	if rubatoParam < 0 || rubatoParam >= len(RubatoParams) {
+		panic(fmt.Sprintf("cannot NewRubato: invalid rubatoParam %d", rubatoParam))
+	}
+
+	rubato := new(plainRubato)
+	rubato.rubatoParam = rubatoParam
+	rubato.blocksize = RubatoParams[rubatoParam].Blocksize
+	rubato.numRound = RubatoParams[rubatoParam].NumRound
+	rubato.plainModulus = RubatoParams[rubatoParam].PlainModulus
+	rubato.sigma = RubatoParams[rubatoParam].Sigma
+	rubato.mat = mixingMatrixRow(rubato.blocksize)
+	if rubato.mat == nil {
+		panic("Invalid blocksize")
+	}
+
+	if len(key) != rubato.blocksize {
+		panic(fmt.Sprintf("cannot NewRubato: key should have %d elements but %d given", rubato.blocksize, len(key)))
+	}
+
+	rubato.key = make([]uint64, rubato.blocksize)
+	for i := 0; i < rubato.blocksize; i++ {
+		if key[i] >= rubato.plainModulus {
+			panic("cannot NewRubato: key elements should be smaller than PlainModulus")
+		}
+		rubato.key[i] = key[i]
+	}
+
+	rubato.state = make([]uint64, rubato.blocksize)
+	rubato.buf = make([]uint64, rubato.blocksize)
+	rubato.rks = make([][]uint64, rubato.numRound+1)
+	for r := 0; r <= rubato.numRound; r++ {
+		rubato.rks[r] = make([]uint64, rubato.blocksize)
+	}
+
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		panic(err)
+	}
+	rubato.gaussianSampler = ring.NewGaussianSampler(prng)
+	return rubato
+}
+
+// Blocksize returns the size of the internal state of the cipher.
+func (rubato *plainRubato) Blocksize() int {
+	return rubato.blocksize
+}
+
+// OutputSize returns the number of keystream elements produced per nonce, i.e. Blocksize - 4.
+func (rubato *plainRubato) OutputSize() int {
+	return rubato.blocksize - 4
+}
+
+// NumRound returns the number of rounds of the cipher.
+func (rubato *plainRubato) NumRound() int {
+	return rubato.numRound
+}
+
+// PlainModulus returns the modulus of the keystream elements.
+func (rubato *plainRubato) PlainModulus() uint64 {
+	return rubato.plainModulus
+}
+
+// Sigma returns the standard deviation of the Gaussian noise added to the keystream.
+func (rubato *plainRubato) Sigma() float64 {
+	return rubato.sigma
+}
+
+// KeyStream returns one noisy keystream block of OutputSize elements per nonce.
+func (rubato *plainRubato) KeyStream(nonces [][]byte, counter []byte) (keystream [][]uint64) {
+	keystream = make([][]uint64, len(nonces))
+	for i := range nonces {
+		keystream[i] = rubato.Crypt(nonces[i], counter)
+	}
+	return
+}
+
+// Crypt returns the keystream block of OutputSize elements for the given nonce and counter,
+// with a Gaussian noise of standard deviation Sigma (bounded by 6*Sigma) added to each element.
+func (rubato *plainRubato) Crypt(nonce []byte, counter []byte) (keystream []uint64) {
+	return rubato.crypt(nonce, counter, rubato.sigma)
+}
+
+// CryptNoNoise returns the keystream block of OutputSize elements for the given nonce and counter
+// without Gaussian noise. This is the keystream that MFVRubato evaluates homomorphically.
+func (rubato *plainRubato) CryptNoNoise(nonce []byte, counter []byte) (keystream []uint64) {
+	return rubato.crypt(nonce, counter, 0)
+}
+
+func (rubato *plainRubato) crypt(nonce []byte, counter []byte, sigma float64) (keystream []uint64) {
+	t := rubato.plainModulus
+	state := rubato.state
+
+	rubato.init(nonce, counter)
+
+	// Initial AddRoundKey
+	for i := 0; i < rubato.blocksize; i++ {
+		state[i] = (uint64(i+1) + rubato.rks[0][i]) % t // ic = 1, ..., blocksize
+	}
+
+	// Round Functions
+	for r := 1; r < rubato.numRound; r++ {
+		rubato.linLayer()
+		rubato.feistel()
+		rubato.addRoundKey(r)
+	}
+
+	// Finalization
+	rubato.linLayer()
+	rubato.feistel()
+	rubato.linLayer()
+	if sigma > 0 {
+		rubato.gaussianSampler.AGN(state, t, sigma, int(6*sigma))
+	}
+	rubato.addRoundKey(rubato.numRound)
+
+	keystream = make([]uint64, rubato.blocksize-4)
+	copy(keystream, state)
+	return
+}
+
+// Compute Round Keys
+func (rubato *plainRubato) init(nonce []byte, counter []byte) {
+	t := rubato.plainModulus
+	xof := sha3.NewShake256()
+	xof.Write(nonce)
+	xof.Write(counter)
+
+	for r := 0; r <= rubato.numRound; r++ {
+		for i := 0; i < rubato.blocksize; i++ {
+			rubato.rks[r][i] = SampleZqx(xof, t) * rubato.key[i] % t
+		}
+	}
+}
+
+func (rubato *plainRubato) addRoundKey(round int) {
+	for i := 0; i < rubato.blocksize; i++ {
+		rubato.state[i] = (rubato.state[i] + rubato.rks[round][i]) % rubato.plainModulus
+	}
+}
+
+// linLayer applies MixColumns then MixRows, using the circulant matrix given by mixingMatrixRow.
+func (rubato *plainRubato) linLayer() {
+	mat := rubato.mat
+	t := rubato.plainModulus
+	state := rubato.state
+	buf := rubato.buf
+	n := len(mat)
+
+	// MixColumns
+	for row := 0; row < n; row++ {
+		for col := 0; col < n; col++ {
+			var sum uint64
+			for k := 0; k < n; k++ {
+				sum += mat[k] * state[((row+k)%n)*n+col]
+			}
+			buf[row*n+col] = sum % t
+		}
+	}
+
+	// MixRows
+	for row := 0; row < n; row++ {
+		for col := 0; col < n; col++ {
+			var sum uint64
+			for k := 0; k < n; k++ {
+				sum += mat[k] * buf[row*n+(col+k)%n]
+			}
+			state[row*n+col] = sum % t
+		}
+	}
+}
+
+func (rubato *plainRubato) feistel() {
+	t := rubato.plainModulus
+	state := rubato.state
+	buf := rubato.buf
+	copy(buf, state)
+
+	for i := 1; i < rubato.blocksize; i++ {
+		state[i] = (buf[i] + buf[i-1]*buf[i-1]) % t
+	}
+}
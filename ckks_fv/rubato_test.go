@@ -0,0 +1,166 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRubato(t *testing.T) {
+	t.Run("Rubato/NewRubato/InvalidInputs/", func(t *testing.T) {
+		assert.Panics(t, func() { NewRubato(-1, make([]uint64, 16)) })
+		assert.Panics(t, func() { NewRubato(len(RubatoParams), make([]uint64, 16)) })
+		assert.Panics(t, func() { NewRubato(RUBATO80M, make([]uint64, 16)) })
+		key := make([]uint64, 16)
+		key[3] = RubatoParams[RUBATO80S].PlainModulus
+		assert.Panics(t, func() { NewRubato(RUBATO80S, key) })
+	})
+
+	for rubatoParam := range RubatoParams {
+		testRubatoNoise(t, rubatoParam)
+	}
+
+	t.Run("Rubato/CryptNoNoise/KnownAnswer/", func(t *testing.T) {
+		nonce := newKnownAnswerNonce(0)
+		counter := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+		for rubatoParam, param := range RubatoParams {
+			rubato := NewRubato(rubatoParam, newKnownAnswerKey(param.Blocksize, param.PlainModulus))
+			assert.Equal(t, rubatoKnownAnswers[rubatoParam], rubato.CryptNoNoise(nonce, counter), "Param %d", rubatoParam)
+		}
+	})
+
+	rubatoParams := []int{RUBATO80S, RUBATO80M, RUBATO80L, RUBATO128S, RUBATO128M, RUBATO128L}
+	if testing.Short() {
+		rubatoParams = []int{RUBATO80S, RUBATO128M}
+	}
+
+	for _, rubatoParam := range rubatoParams {
+		testRubatoAgainstMFVRubato(t, rubatoParam)
+	}
+}
+
+// testRubatoNoise checks that the noisy keystream differs from the noise-free one
+// by a centered error bounded by 6*Sigma.
+func testRubatoNoise(t *testing.T, rubatoParam int) {
+	t.Run(fmt.Sprintf("Rubato/Crypt/Noise/Param=%d/", rubatoParam), func(t *testing.T) {
+		blocksize := RubatoParams[rubatoParam].Blocksize
+		plainModulus := RubatoParams[rubatoParam].PlainModulus
+		bound := uint64(6 * RubatoParams[rubatoParam].Sigma)
+
+		rubato := NewRubato(rubatoParam, newTestKey(blocksize, plainModulus))
+		require.Equal(t, blocksize-4, rubato.OutputSize())
+
+		nonces := newTestNonces(64)
+		counter := newTestNonces(1)[0][:8]
+
+		noisy := rubato.KeyStream(nonces, counter)
+		var nonZero bool
+		for i := range nonces {
+			exact := rubato.CryptNoNoise(nonces[i], counter)
+			require.Len(t, noisy[i], rubato.OutputSize())
+			for j := range exact {
+				e := (noisy[i][j] + plainModulus - exact[j]) % plainModulus
+				if e > plainModulus>>1 {
+					e = plainModulus - e
+				}
+				require.LessOrEqual(t, e, bound)
+				nonZero = nonZero || e != 0
+			}
+		}
+		assert.True(t, nonZero)
+	})
+}
+
+// testRubatoAgainstMFVRubato checks that the keystream evaluated homomorphically by MFVRubato
+// decrypts exactly to the noise-free keystream of the client-side Rubato, slot by slot.
+func testRubatoAgainstMFVRubato(t *testing.T, rubatoParam int) {
+	blocksize := RubatoParams[rubatoParam].Blocksize
+	outputsize := blocksize - 4
+	rubatoModDown := RubatoModDownParams[rubatoParam].CipherModDown
+
+	hbtpParams := genTestHalfBootParams(RtFRubatoParams[0])
+	hbtpParams.PlainModulus = RubatoParams[rubatoParam].PlainModulus
+	params, _ := genTestParams(hbtpParams)
+
+	t.Run(testString(fmt.Sprintf("Rubato/MFVRubato/Param=%d/", rubatoParam), params), func(t *testing.T) {
+		kgen := NewKeyGenerator(params)
+		sk, pk := kgen.GenKeyPairSparse(hbtpParams.H)
+		rlk := kgen.GenRelinearizationKey(sk)
+
+		fvEncoder := NewMFVEncoder(params)
+		fvEncryptor := NewMFVEncryptorFromPk(params, pk)
+		fvDecryptor := NewMFVDecryptor(params, sk)
+		fvEvaluator := NewMFVEvaluator(params, EvaluationKey{Rlk: rlk}, nil)
+
+		key := newTestKey(blocksize, params.PlainModulus())
+		nonces := newTestNonces(params.FVSlots())
+		counter := newTestNonces(1)[0][:8]
+
+		mfvRubato := NewMFVRubato(rubatoParam, params, fvEncoder, fvEncryptor, fvEvaluator, rubatoModDown[0])
+		kCt := mfvRubato.EncKey(key)
+		fvKeystreams := mfvRubato.Crypt(nonces, counter, kCt, rubatoModDown)
+
+		rubato := NewRubato(rubatoParam, key)
+		require.Equal(t, params.PlainModulus(), rubato.PlainModulus())
+
+		keystream := make([][]uint64, len(nonces))
+		for i := range nonces {
+			keystream[i] = rubato.CryptNoNoise(nonces[i], counter)
+		}
+
+		for s := 0; s < outputsize; s++ {
+			have := fvEncoder.DecodeUintSmallNew(fvDecryptor.DecryptNew(fvKeystreams[s]))
+			for i := 0; i < params.FVSlots(); i++ {
+				require.Equal(t, keystream[i][s], have[i], "state %d, slot %d", s, i)
+			}
+		}
+	})
+}
+
+// rubatoKnownAnswers are the noise-free keystreams of the original implementation of Rubato, indexed by the
+// parameter, for the key newKnownAnswerKey(Blocksize, PlainModulus), the nonce newKnownAnswerNonce(0) and the
+// counter {0, 1, ..., 7}.
+var rubatoKnownAnswers = [][]uint64{
+	{
+		0x349d39, 0x227b255, 0x10acc52, 0x2ac44b4, 0x2072763, 0x124f32b, 0xa3ada2, 0x26428ca,
+		0xf3f736, 0x34dd72e, 0x369d162, 0x997df8,
+	},
+	{
+		0x1fd811, 0xae2595, 0x1199ebd, 0x1fb9361, 0x12abf0d, 0x1659a6b, 0xf9639c, 0x1875f37,
+		0x1e46b25, 0x2e56bd, 0xa06396, 0x2f52d0, 0x9d93df, 0x593b9d, 0x19b90b, 0xebf25e,
+		0x1e993d6, 0xf100fd, 0x13947c8, 0x1b2d67e, 0xe4b291, 0x15fe14b, 0x16b138e, 0xa8a7ea,
+		0x1ee0b57, 0x9bbc35, 0xf4e839, 0x1e49d2, 0x12dc9b9, 0x533d37, 0x13944aa, 0x1937e86,
+	},
+	{
+		0x48c92c, 0x17bac29, 0x1751d4b, 0x19d083e, 0x13027f1, 0xe31f99, 0x18ac190, 0xd01507,
+		0x1afe3e8, 0x193290b, 0x15b8177, 0xef4d7, 0x273cb6, 0x15bccd6, 0x1799203, 0x27c3b9,
+		0x475526, 0x4be33d, 0x1c625f8, 0x8efc3b, 0x6ee1d8, 0x1e3ee04, 0x1491742, 0xe19a31,
+		0x7f9e2, 0x1573f37, 0x1c6cc4, 0x53d3c7, 0x1086864, 0x28b20, 0x250b42, 0x1b02b1f,
+		0x4a241f, 0x138b835, 0x308e05, 0xa647a0, 0xe50f6a, 0x96c94a, 0x119bab2, 0x1df3547,
+		0x17186c9, 0x15d57d6, 0xfbf0df, 0x6037dd, 0x133b061, 0x167826c, 0x13d924a, 0x66a43b,
+		0x192d919, 0x151283d, 0x200d40, 0x1863c40, 0x15ec30d, 0x1b948c6, 0x248be8, 0x1503130,
+		0x440674, 0x9a402c, 0xe5e5fa, 0x13cf20d,
+	},
+	{
+		0x33e3a8b, 0xa35c82, 0x3c6d834, 0x2aea3ed, 0x2dd1c1b, 0xa1b2c4, 0x2b4a049, 0x318237d,
+		0x149dfe0, 0x34b5763, 0x21baa7d, 0x3082766,
+	},
+	{
+		0x1bacc93, 0xabce9c, 0x1d46a30, 0xc7dff0, 0x1c3ef52, 0x17a7b8f, 0x130b8fb, 0xeb84d1,
+		0x16ad8fa, 0x1e3e02c, 0xe2237d, 0x10a31e7, 0x13c31f1, 0xba993, 0x13c3b42, 0x989209,
+		0x13458ab, 0x1862ae, 0x197cbe6, 0x1e57bda, 0x12aa9ad, 0x3f7bea, 0xeeb87d, 0x1aebe,
+		0x139c76, 0x10b9072, 0x66206b, 0xa37a3e, 0x3b6726, 0x2a7c10, 0xfd9156, 0x686f05,
+	},
+	{
+		0x48c92c, 0x17bac29, 0x1751d4b, 0x19d083e, 0x13027f1, 0xe31f99, 0x18ac190, 0xd01507,
+		0x1afe3e8, 0x193290b, 0x15b8177, 0xef4d7, 0x273cb6, 0x15bccd6, 0x1799203, 0x27c3b9,
+		0x475526, 0x4be33d, 0x1c625f8, 0x8efc3b, 0x6ee1d8, 0x1e3ee04, 0x1491742, 0xe19a31,
+		0x7f9e2, 0x1573f37, 0x1c6cc4, 0x53d3c7, 0x1086864, 0x28b20, 0x250b42, 0x1b02b1f,
+		0x4a241f, 0x138b835, 0x308e05, 0xa647a0, 0xe50f6a, 0x96c94a, 0x119bab2, 0x1df3547,
+		0x17186c9, 0x15d57d6, 0xfbf0df, 0x6037dd, 0x133b061, 0x167826c, 0x13d924a, 0x66a43b,
+		0x192d919, 0x151283d, 0x200d40, 0x1863c40, 0x15ec30d, 0x1b948c6, 0x248be8, 0x1503130,
+		0x440674, 0x9a402c, 0xe5e5fa, 0x13cf20d,
+	},
+}
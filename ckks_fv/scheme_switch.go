@@ -0,0 +1,274 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// schemeSwitchLogMinRatio is the minimum size in bits of the ratio between the integer constant C = round(Q_l/(t*Delta))
+// of the CKKS to FV scheme switching and the plaintext modulus t.
+const schemeSwitchLogMinRatio = 20
+
+// SchemeSwitchParameters are the parameters of the CKKS to FV scheme switching.
+type SchemeSwitchParameters struct {
+	Level        int     // Level at which the SlotsToCoeffs starts (the input ciphertexts are dropped to this level)
+	Depth        int     // Number of levels consumed by the SlotsToCoeffs
+	MaxN1N2Ratio float64 // n1/n2 ratio for the bsgs algo for matrix x vector eval
+}
+
+// OutputLevel returns the level of the FV ciphertexts returned by the scheme switching.
+func (ssParams *SchemeSwitchParameters) OutputLevel() int {
+	return ssParams.Level - ssParams.Depth
+}
+
+// Validate checks that the SchemeSwitchParameters are consistent with the given Parameters: the FV slots
+// should be packed as in the RtF framework (1 <= LogFVSlots <= LogSlots+1), and the SlotsToCoeffs should fit
+// between the level 0 and MaxLevel and merge at most one layer of the DFT per level.
+func (ssParams *SchemeSwitchParameters) Validate(params *Parameters) error {
+	if params.LogFVSlots() < 1 || params.LogFVSlots() > params.LogSlots()+1 {
+		return fmt.Errorf("LogFVSlots %d should be between 1 and LogSlots+1 = %d", params.LogFVSlots(), params.LogSlots()+1)
+	}
+
+	if ssParams.Depth < 1 || ssParams.Depth > params.LogSlots() {
+		return fmt.Errorf("Depth %d should be between 1 and LogSlots %d", ssParams.Depth, params.LogSlots())
+	}
+
+	if ssParams.Level > params.MaxLevel() || ssParams.OutputLevel() < 0 {
+		return fmt.Errorf("Level %d should be between Depth %d and MaxLevel %d", ssParams.Level, ssParams.Depth, params.MaxLevel())
+	}
+
+	if ssParams.MaxN1N2Ratio <= 0 {
+		return fmt.Errorf("MaxN1N2Ratio should be positive")
+	}
+
+	return nil
+}
+
+// SchemeSwitcher is a struct to store the plaintext matrices and the keys of the CKKS to FV scheme switching.
+type SchemeSwitcher struct {
+	*ckksEvaluator
+	SchemeSwitchParameters
+	params *Parameters
+
+	logdslots int // Log of the number of plaintext slots of the SlotsToCoeffs matrices
+
+	pDFT []*PtDiagMatrix // SlotsToCoeffs matrices
+
+	rotKeyIndex []int // a list of the required rotation keys
+}
+
+// NewSchemeSwitcher creates a new SchemeSwitcher. The evaluation key should hold the conjugation key and the
+// rotation keys generated from KeyGenerator.GenRotationIndexesForSchemeSwitch.
+func NewSchemeSwitcher(params *Parameters, ssParams *SchemeSwitchParameters, evk EvaluationKey) (ss *SchemeSwitcher, err error) {
+	if err = ssParams.Validate(params); err != nil {
+		return nil, fmt.Errorf("invalid scheme switching parameters: %w", err)
+	}
+
+	ss = new(SchemeSwitcher)
+	ss.params = params.Copy()
+	ss.SchemeSwitchParameters = *ssParams
+	ss.logdslots = schemeSwitchLogdSlots(params)
+
+	encoder := NewCKKSEncoder(params)
+	qi := params.Qi()
+
+	ss.pDFT = make([]*PtDiagMatrix, ssParams.Depth)
+	ss.rotKeyIndex = []int{}
+	for i, pVec := range genSchemeSwitchDiagMatrices(params, ssParams) {
+		level := ssParams.Level - i
+		ss.pDFT[i] = encoder.EncodeDiagMatrixAtLvl(level, pVec, float64(qi[level]), ssParams.MaxN1N2Ratio, ss.logdslots)
+		ss.rotKeyIndex = AddMatrixRotToList(ss.pDFT[i], ss.rotKeyIndex, params.Slots(), ss.logdslots != params.LogSlots() && i == 0)
+	}
+
+	if err = ss.checkKeys(evk); err != nil {
+		return nil, fmt.Errorf("invalid evaluation key: %w", err)
+	}
+	ss.ckksEvaluator = NewCKKSEvaluator(params, evk).(*ckksEvaluator)
+
+	return ss, nil
+}
+
+// checkKeys checks if the conjugation key and the rotation keys are present.
+func (ss *SchemeSwitcher) checkKeys(evk EvaluationKey) error {
+	if evk.Rtks == nil {
+		return fmt.Errorf("rotation key is nil")
+	}
+
+	if _, generated := evk.Rtks.Keys[ss.params.GaloisElementForRowRotation()]; !generated {
+		return fmt.Errorf("conjugation key missing")
+	}
+
+	rotMissing := []int{}
+	for _, i := range ss.rotKeyIndex {
+		galEl := ss.params.GaloisElementForColumnRotationBy(i)
+		if _, generated := evk.Rtks.Keys[galEl]; !generated {
+			rotMissing = append(rotMissing, i)
+		}
+	}
+
+	if len(rotMissing) != 0 {
+		return fmt.Errorf("rotation key(s) missing: %d", rotMissing)
+	}
+
+	return nil
+}
+
+// CKKSToFV switches CKKS ciphertexts whose slots hold real values close to integers to an FV ciphertext under the
+// same secret key, whose plaintext holds these integers modulo t in its coefficients with the layout of the output
+// of Transcipherer.Transcipher, i.e. such that the half-bootstrapping maps them back to the slots. The k-th integer
+// is taken from the k-th slot of ct0 if LogFVSlots <= LogSlots, and from the k-th slot of ct0 (k < Slots) or the
+// (k-Slots)-th slot of ct1 (k >= Slots) if LogFVSlots = LogSlots+1; ct1 should be nil otherwise, and a nil ct1 is
+// treated as zero. The inputs are not modified.
+//
+// The real parts z_k of the slots are extracted with the conjugation, moved to the coefficients with the
+// SlotsToCoeffs, which consumes Depth levels from Level, and the result is multiplied by the integer
+// C = round(Q_l/(t*Delta)), where l = OutputLevel and Delta is the scale of the inputs, which turns the encoding
+// Delta*z of CKKS into the encoding Q_l/t*z of FV. The result decrypts to round(z_k) mod t as long as
+// |z_k - round(z_k)| + |e_k|/Delta + |z_k|/(2C) < 1/2 for all k, where e_k is the error of the SlotsToCoeffs,
+// i.e. its invariant noise is this sum. It returns an error if the inputs are below Level, if their scales differ,
+// or if C < 2^20*t.
+func (ss *SchemeSwitcher) CKKSToFV(ct0, ct1 *Ciphertext) (ct *Ciphertext, err error) {
+	params := ss.params
+
+	if ct1 != nil && !schemeSwitchFullCoeffs(params) {
+		return nil, fmt.Errorf("ct1 should be nil when LogFVSlots <= LogSlots")
+	}
+
+	for _, ctIn := range []*Ciphertext{ct0, ct1} {
+		if ctIn == nil {
+			continue
+		}
+
+		if ctIn.Level() < ss.Level {
+			return nil, fmt.Errorf("ciphertext level %d is below the scheme switching level %d", ctIn.Level(), ss.Level)
+		}
+
+		if ctIn.Scale() != ct0.Scale() {
+			return nil, fmt.Errorf("ciphertexts should have the same scale")
+		}
+	}
+
+	outLevel := ss.OutputLevel()
+	C := schemeSwitchConstant(params, outLevel, ct0.Scale())
+	if C.Cmp(new(big.Int).Lsh(new(big.Int).SetUint64(params.PlainModulus()), schemeSwitchLogMinRatio)) < 0 {
+		return nil, fmt.Errorf("Q_%d/(t*Delta) is below 2^%d*t, the scale of the ciphertexts is too large", outLevel, schemeSwitchLogMinRatio)
+	}
+
+	// 2*Re(ct0) + 2i*Re(ct1), the factor 1/2 is merged in the first SlotsToCoeffs matrix
+	vec := ss.realPartNew(ct0)
+	if ct1 != nil {
+		re1 := ss.realPartNew(ct1)
+		ss.MultByi(re1, re1)
+		ss.Add(vec, re1, vec)
+	}
+
+	vec = dft(vec, ss.pDFT, false, ss.ckksEvaluator)
+
+	ringQ := ss.ringQ
+	for _, pol := range vec.Value() {
+		ringQ.MulScalarBigintLvl(outLevel, pol, C, pol)
+	}
+
+	ct = NewCiphertextFVLvl(params, 1, outLevel)
+	vec.InvNTT(ringQ, ct.Element)
+	return ct, nil
+}
+
+// realPartNew returns ct + conj(ct) dropped to the scheme switching level, i.e. twice the real part of ct.
+func (ss *SchemeSwitcher) realPartNew(ct *Ciphertext) (ctOut *Ciphertext) {
+	ctOut = ss.DropLevelNew(ct, ct.Level()-ss.Level)
+	ctConj := ss.ConjugateNew(ctOut)
+	ss.Add(ctOut, ctConj, ctOut)
+	return
+}
+
+// schemeSwitchConstant returns round(Q_level/(t*scale)).
+func schemeSwitchConstant(params *Parameters, level int, scale float64) *big.Int {
+	prec := uint(params.LogQLvl(level) + 64)
+	C := new(big.Float).SetPrec(prec).SetInt(params.QLvl(level))
+	C.Quo(C, new(big.Float).SetPrec(prec).SetUint64(params.PlainModulus()))
+	C.Quo(C, new(big.Float).SetPrec(prec).SetFloat64(scale))
+	C.Add(C, new(big.Float).SetFloat64(0.5))
+	res, _ := C.Int(nil)
+	return res
+}
+
+// schemeSwitchFullCoeffs returns true if the FV slots are the 2*Slots coefficients of the CKKS plaintexts with
+// Slots slots, i.e. if LogFVSlots = LogSlots+1, in which case the scheme switching takes two ciphertexts.
+func schemeSwitchFullCoeffs(params *Parameters) bool {
+	return params.LogFVSlots() == params.LogSlots()+1
+}
+
+// schemeSwitchLogdSlots returns the log of the number of plaintext slots of the SlotsToCoeffs matrices.
+func schemeSwitchLogdSlots(params *Parameters) int {
+	if !schemeSwitchFullCoeffs(params) && params.LogSlots() < params.MaxLogSlots() {
+		return params.LogSlots() + 1
+	}
+	return params.LogSlots()
+}
+
+// genSchemeSwitchDiagMatrices generates the diagonals of the SlotsToCoeffs matrices of the scheme switching, i.e.
+// of the decoding matrix scaled by 1/2. If LogFVSlots <= LogSlots, the first matrix also moves the real values x of
+// the first FVSlots slots to the values u of the repacking, such that u_k = x_k and u_{Slots+k} = x_{FVSlots/2+k}
+// for k < FVSlots/2 (and zero otherwise), which are the coefficients at the positions of the FV slots. If LogSlots =
+// LogN-1, there are only Slots values u, whose imaginary parts hold the second half of the coefficients, so that
+// u_k = x_k + i*x_{FVSlots/2+k} instead.
+func genSchemeSwitchDiagMatrices(params *Parameters, ssParams *SchemeSwitchParameters) (pVec []map[int][]complex128) {
+	logSlots := params.LogSlots()
+	slots := 1 << logSlots
+	logdSlots := schemeSwitchLogdSlots(params)
+
+	roots := computeRoots(slots << 1)
+	pow5 := make([]int, (slots<<1)+1)
+	pow5[0] = 1
+	for i := 1; i < (slots<<1)+1; i++ {
+		pow5[i] = pow5[i-1] * 5
+		pow5[i] &= (slots << 2) - 1
+	}
+
+	pVec = computeDFTMatrices(logSlots, logdSlots, ssParams.Depth, roots, pow5, 1, false)
+
+	if schemeSwitchFullCoeffs(params) {
+		for j := range pVec[0] {
+			for i := range pVec[0][j] {
+				pVec[0][j][i] *= 0.5
+			}
+		}
+		return
+	}
+
+	dslots := 1 << logdSlots
+	fvSlots := params.FVSlots()
+	fold := make(map[int][]complex128)
+	a := make([]complex128, dslots)
+	b := make([]complex128, dslots)
+	for i := 0; i < fvSlots>>1; i++ {
+		a[i] = 0.5
+		if logdSlots == logSlots {
+			b[i] = 0.5i
+		} else {
+			b[i+slots] = 0.5
+		}
+	}
+	addToDiagMatrix(fold, 0, a)
+	addToDiagMatrix(fold, fvSlots>>1, b)
+
+	pVec[0] = mulDiagMatrices(pVec[0], fold, dslots)
+	return
+}
+
+// mulDiagMatrices returns the diagonals of the product a*b of two matrices of size n given by their diagonals,
+// i.e. of the matrix which applies b and then a.
+func mulDiagMatrices(a, b map[int][]complex128, n int) (c map[int][]complex128) {
+	c = make(map[int][]complex128)
+	for r, va := range a {
+		for s, vb := range b {
+			vec := make([]complex128, n)
+			for i := range vec {
+				vec[i] = va[i] * vb[(i+r)&(n-1)]
+			}
+			addToDiagMatrix(c, (r+s)&(n-1), vec)
+		}
+	}
+	return
+}
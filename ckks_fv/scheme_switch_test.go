@@ -0,0 +1,141 @@
+package ckks_fv
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+func TestSchemeSwitch(t *testing.T) {
+	for _, hb := range []*HalfBootParameters{RtFHeraParams[1], RtFHeraParams[0]} {
+		params, _ := genTestParams(genTestHalfBootParams(hb))
+		testSchemeSwitch(params, t)
+	}
+
+	// Other numbers of FV slots, as set by TranscipherParameters.LogBlockSlots
+	hbSparse := genTestHalfBootParams(RtFHeraParams[0])
+	hbSparse.LogSlots = 6
+	for _, tc := range []struct {
+		hb         *HalfBootParameters
+		logFVSlots int
+	}{
+		{genTestHalfBootParams(RtFHeraParams[1]), 5},
+		{genTestHalfBootParams(RtFHeraParams[0]), 5},
+		{hbSparse, 4},
+	} {
+		params, err := tc.hb.Params()
+		require.NoError(t, err)
+		params.SetLogFVSlots(tc.logFVSlots)
+		testSchemeSwitch(params, t)
+	}
+}
+
+func testSchemeSwitch(params *Parameters, t *testing.T) {
+	ssParams := &SchemeSwitchParameters{Level: 11, Depth: 3, MaxN1N2Ratio: 16.0}
+
+	kgen := NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPair()
+	rotkeys := kgen.GenRotationKeysForRotations(kgen.GenRotationIndexesForSchemeSwitch(ssParams), true, sk)
+
+	ss, err := NewSchemeSwitcher(params, ssParams, EvaluationKey{Rtks: rotkeys})
+	require.NoError(t, err)
+
+	encoder := NewCKKSEncoder(params)
+	encryptor := NewCKKSEncryptorFromPk(params, pk)
+	decryptor := NewMFVDecryptor(params, sk)
+	fvEncoder := NewMFVEncoder(params)
+	noiseEstimator := NewMFVNoiseEstimator(params, sk)
+
+	t0 := params.PlainModulus()
+	fullCoeffs := params.LogFVSlots() == params.LogSlots()+1
+
+	// Switches the values and checks that the FV plaintext holds their rounding modulo t at the positions of the FV slots.
+	switchAndVerify := func(t *testing.T, values []float64) *Ciphertext {
+		slots := make([]complex128, 2*params.Slots())
+		for i, v := range values {
+			slots[i] = complex(v, 0)
+		}
+
+		var ct0, ct1 *Ciphertext
+		ct0 = encryptor.EncryptNew(encoder.EncodeComplexNTTNew(slots[:params.Slots()], params.LogSlots()))
+		if fullCoeffs {
+			ct1 = encryptor.EncryptNew(encoder.EncodeComplexNTTNew(slots[params.Slots():], params.LogSlots()))
+		}
+
+		ct, err := ss.CKKSToFV(ct0, ct1)
+		require.NoError(t, err)
+		require.Equal(t, ssParams.OutputLevel(), ct.Level())
+		require.False(t, ct.IsNTT())
+
+		ptRt := NewPlaintextRingT(params)
+		fvEncoder.DecodeRingT(decryptor.DecryptNew(ct), ptRt)
+		for k, v := range values {
+			want := uint64((int64(math.Round(v))%int64(t0) + int64(t0)) % int64(t0))
+			require.Equal(t, want, ptRt.value.Coeffs[0][coeffIndex(params, k)], "FV slot %d", k)
+		}
+		return ct
+	}
+
+	t.Run(testString("SchemeSwitch/CKKSToFV/", params), func(t *testing.T) {
+		values := make([]float64, params.FVSlots())
+		for i := range values {
+			values[i] = float64(int64(utils.RandUint64()%(1<<21)) - (1 << 20))
+		}
+		values[0], values[1] = float64(t0/2), -float64(t0/2)
+
+		ct := switchAndVerify(t, values)
+		require.Greater(t, noiseEstimator.InvariantNoiseBudget(ct), 5)
+
+		// The result is a valid FV ciphertext
+		fvEvaluator := NewMFVEvaluator(params, EvaluationKey{}, nil)
+		fvEvaluator.Add(ct, ct, ct)
+		for i := range values {
+			values[i] *= 2
+		}
+		ptRt := NewPlaintextRingT(params)
+		fvEncoder.DecodeRingT(decryptor.DecryptNew(ct), ptRt)
+		for k, v := range values {
+			want := uint64((int64(v)%int64(t0) + int64(t0)) % int64(t0))
+			require.Equal(t, want, ptRt.value.Coeffs[0][coeffIndex(params, k)], "FV slot %d", k)
+		}
+	})
+
+	t.Run(testString("SchemeSwitch/Precision/", params), func(t *testing.T) {
+		// Values at distance up to 0.4 from an integer are rounded to this integer
+		values := make([]float64, params.FVSlots())
+		for i := range values {
+			values[i] = float64(int64(utils.RandUint64()%(1<<21))-(1<<20)) + utils.RandFloat64(-0.4, 0.4)
+		}
+
+		switchAndVerify(t, values)
+	})
+
+	t.Run(testString("SchemeSwitch/Errors/", params), func(t *testing.T) {
+		ct0 := encryptor.EncryptNew(encoder.EncodeComplexNTTNew(make([]complex128, params.Slots()), params.LogSlots()))
+
+		ctLow := ss.DropLevelNew(ct0, ct0.Level()-ssParams.Level+1)
+		_, err := ss.CKKSToFV(ctLow, nil)
+		assert.Error(t, err)
+
+		if !fullCoeffs {
+			_, err = ss.CKKSToFV(ct0, ct0)
+			assert.Error(t, err)
+		}
+
+		// Q_0/(t*Delta) is too small
+		ssLow, err := NewSchemeSwitcher(params, &SchemeSwitchParameters{Level: 3, Depth: 3, MaxN1N2Ratio: 16.0}, EvaluationKey{Rtks: rotkeys})
+		require.NoError(t, err)
+		_, err = ssLow.CKKSToFV(ct0, nil)
+		assert.Error(t, err)
+
+		_, err = NewSchemeSwitcher(params, &SchemeSwitchParameters{Level: 2, Depth: 3, MaxN1N2Ratio: 16.0}, EvaluationKey{Rtks: rotkeys})
+		assert.Error(t, err)
+
+		_, err = NewSchemeSwitcher(params, ssParams, EvaluationKey{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,384 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// stcPlanRatios are the candidate maxN1N2Ratio of the baby-step giant-step algorithm tried by PlanSlotsToCoeffs.
+var stcPlanRatios = []float64{1, 2, 4, 8, 16, 32, 64}
+
+// StCBudget is the budget given to PlanSlotsToCoeffs. A zero field means no limit.
+//
+// The latency is only estimated by the number of key-switches: the plaintext multiplications (see StCPlan.PlainMults)
+// and the levels at which the matrices are evaluated, which depend on StCModDown, are not taken into account.
+type StCBudget struct {
+	MaxDepth       int // Maximum number of sequential linear transforms, i.e. of elements of StCModDown
+	MaxKeyMemory   int // Maximum memory in bytes of the rotation keys of the SlotsToCoeffs (row rotation included)
+	MaxKeySwitches int // Maximum number of key-switches of one SlotsToCoeffs, which estimates its latency
+}
+
+// StCPlan is a decomposition of the FV decoding matrix for the SlotsToCoeffs, given by PlanSlotsToCoeffs.
+//
+// The decoding matrix is factorized into LogFVSlots sparse block diagonal matrices, the last two of which are
+// evaluated on the ciphertext and on its row rotation. Split[i] consecutive factors are merged into the i-th
+// linear transform, and the factors of the last group are merged into both matrices of the final pair, so
+// that there are len(Split)+1 matrices and the radix 1 of GenSlotToCoeffMatFV is given by Split = [1, ..., 1].
+// A plan is used by the RtF framework when it is set in TranscipherParameters.StCPlan.
+type StCPlan struct {
+	LogFVSlots int
+	Split      []int     // Split[i] is the number of factors merged into the i-th linear transform, sum(Split) = LogFVSlots-2
+	Ratios     []float64 // Ratios[i] is the maxN1N2Ratio of the i-th matrix
+	N1         []int     // N1[i] is the number of inner loops of the baby-step giant-step algorithm of the i-th matrix (0 if naive)
+
+	Depth       int   // Number of sequential linear transforms, i.e. the length of StCModDown
+	Rotations   []int // Rotations of the matrices, which need rotation keys in addition to the row rotation
+	KeySwitches int   // Number of key-switches of one SlotsToCoeffs (row rotation included)
+	PlainMults  int   // Number of plaintext-ciphertext multiplications of one SlotsToCoeffs
+	KeyMemory   int   // Memory in bytes of the rotation keys (row rotation included)
+}
+
+// Copy returns a deep copy of the target StCPlan.
+func (plan *StCPlan) Copy() *StCPlan {
+	planCopy := *plan
+	planCopy.Split = append([]int{}, plan.Split...)
+	planCopy.Ratios = append([]float64{}, plan.Ratios...)
+	planCopy.N1 = append([]int{}, plan.N1...)
+	planCopy.Rotations = append([]int{}, plan.Rotations...)
+	return &planCopy
+}
+
+// String returns a short description of the plan.
+func (plan *StCPlan) String() string {
+	return fmt.Sprintf("Split=%v Ratios=%v Depth=%d Rotations=%d KeySwitches=%d PlainMults=%d KeyMemory=%dMB",
+		plan.Split, plan.Ratios, plan.Depth, len(plan.Rotations), plan.KeySwitches, plan.PlainMults, plan.KeyMemory>>20)
+}
+
+// stcMatrixCost is the cost of one matrix of a decomposition for a given maxN1N2Ratio.
+type stcMatrixCost struct {
+	ratio       float64
+	N1          int
+	rotations   []int
+	keySwitches int
+	plainMults  int
+}
+
+// stcPlanner caches the costs of the matrices obtained by merging the factors start, ..., end-1 of the decoding
+// matrix (role 0), and by merging them into the first (role 1) or the second (role 2) matrix of the final pair.
+type stcPlanner struct {
+	params     *Parameters
+	logFVSlots int
+	factors    []map[int]bool
+	costs      map[[3]int][]stcMatrixCost
+}
+
+// PlanSlotsToCoeffs returns the decomposition of the FV decoding matrix, and the maxN1N2Ratio of each of its
+// matrices, with the smallest number of key-switches that fits the budget; ties are broken by the number of
+// rotation keys and then by the depth. All the splits of the factors are tried, each with the same ratio for
+// all the matrices and with the ratio minimizing the key-switches of each matrix. It returns an error if no
+// decomposition fits the budget. The matrices are generated by MFVEncoder.GenSlotToCoeffMatFVFromPlan.
+func PlanSlotsToCoeffs(params *Parameters, budget StCBudget) (plan *StCPlan, err error) {
+	if params.LogFVSlots() < MinLogN {
+		return nil, fmt.Errorf("cannot PlanSlotsToCoeffs: LogFVSlots should be at least %d", MinLogN)
+	}
+
+	p := newStCPlanner(params)
+	numFactors := p.logFVSlots - 2
+
+	// The splits are the compositions of numFactors, i.e. the subsets of the numFactors-1 cut points.
+	for cuts := 0; cuts < 1<<(numFactors-1); cuts++ {
+		split := []int{}
+		size := 1
+		for i := 0; i < numFactors-1; i++ {
+			if cuts>>i&1 == 1 {
+				split = append(split, size)
+				size = 0
+			}
+			size++
+		}
+		split = append(split, size)
+
+		if budget.MaxDepth > 0 && len(split) > budget.MaxDepth {
+			continue
+		}
+
+		choices := make([][]int, len(stcPlanRatios)+1)
+		for r := range stcPlanRatios {
+			choices[r] = make([]int, len(split)+1)
+			for i := range choices[r] {
+				choices[r][i] = r
+			}
+		}
+		choices[len(stcPlanRatios)] = p.bestRatios(split)
+
+		keys := p.keys(split)
+		for _, choice := range choices {
+			costs := make([]stcMatrixCost, len(keys))
+			for i, key := range keys {
+				costs[i] = p.matrixCosts(key)[choice[i]]
+			}
+
+			candidate := p.plan(split, costs)
+			if candidate.fits(budget) && (plan == nil || candidate.better(plan)) {
+				plan = candidate
+			}
+		}
+	}
+
+	if plan == nil {
+		return nil, fmt.Errorf("cannot PlanSlotsToCoeffs: no decomposition fits the budget")
+	}
+
+	return plan, nil
+}
+
+// NewStCPlan returns the plan of the given split of the FV decoding matrix (see StCPlan), with the given
+// maxN1N2Ratio for all its matrices, so that its costs can be compared with those of PlanSlotsToCoeffs.
+func NewStCPlan(params *Parameters, split []int, maxN1N2Ratio float64) (plan *StCPlan, err error) {
+	if err = validateStCSplit(params.LogFVSlots(), split); err != nil {
+		return nil, fmt.Errorf("cannot NewStCPlan: %w", err)
+	}
+
+	p := newStCPlanner(params)
+	keys := p.keys(split)
+	costs := make([]stcMatrixCost, len(keys))
+	for i, key := range keys {
+		costs[i] = p.matrixCost(p.matrixIndex(key), maxN1N2Ratio)
+	}
+
+	return p.plan(split, costs), nil
+}
+
+// validate checks that the plan is a decomposition of the decoding matrix for logFVSlots FV slots.
+func (plan *StCPlan) validate(logFVSlots int) error {
+	if plan.LogFVSlots != logFVSlots {
+		return fmt.Errorf("plan LogFVSlots %d should be %d", plan.LogFVSlots, logFVSlots)
+	}
+
+	if err := validateStCSplit(logFVSlots, plan.Split); err != nil {
+		return err
+	}
+
+	if len(plan.Ratios) != len(plan.Split)+1 || plan.Depth != len(plan.Split) {
+		return fmt.Errorf("plan should have %d ratios and a depth of %d", len(plan.Split)+1, len(plan.Split))
+	}
+
+	for _, ratio := range plan.Ratios {
+		if ratio <= 0 {
+			return fmt.Errorf("plan ratios should be positive")
+		}
+	}
+	return nil
+}
+
+// validateStCSplit checks that split is a split of the factors of the decoding matrix for logFVSlots FV slots.
+func validateStCSplit(logFVSlots int, split []int) error {
+	if logFVSlots < MinLogN {
+		return fmt.Errorf("LogFVSlots should be at least %d", MinLogN)
+	}
+
+	sum := 0
+	for _, size := range split {
+		if size < 1 {
+			return fmt.Errorf("split should be positive")
+		}
+		sum += size
+	}
+
+	if sum != logFVSlots-2 {
+		return fmt.Errorf("split should sum to LogFVSlots-2 = %d", logFVSlots-2)
+	}
+	return nil
+}
+
+func newStCPlanner(params *Parameters) (p *stcPlanner) {
+	p = new(stcPlanner)
+	p.params = params
+	p.logFVSlots = params.LogFVSlots()
+	p.factors = genDcdDiabDecompIndex(p.logFVSlots, params.PlainModulus())
+	p.costs = make(map[[3]int][]stcMatrixCost)
+	return
+}
+
+// bestRatios returns, for each matrix of the split, the index of the ratio with the fewest key-switches
+// (and then the fewest rotations).
+func (p *stcPlanner) bestRatios(split []int) (choice []int) {
+	choice = make([]int, len(split)+1)
+	for i, key := range p.keys(split) {
+		costs := p.matrixCosts(key)
+		for r := range costs {
+			best := costs[choice[i]]
+			if costs[r].keySwitches < best.keySwitches ||
+				(costs[r].keySwitches == best.keySwitches && len(costs[r].rotations) < len(best.rotations)) {
+				choice[i] = r
+			}
+		}
+	}
+	return
+}
+
+// keys returns the cache keys of the matrices of the split.
+func (p *stcPlanner) keys(split []int) (keys [][3]int) {
+	keys = make([][3]int, len(split)+1)
+	start := 0
+	for i, size := range split {
+		keys[i] = [3]int{start, start + size, 0}
+		start += size
+	}
+	keys[len(split)-1][2] = 1
+	keys[len(split)] = keys[len(split)-1]
+	keys[len(split)][2] = 2
+	return
+}
+
+// plan returns the plan of the split with the given costs of its matrices.
+func (p *stcPlanner) plan(split []int, costs []stcMatrixCost) (plan *StCPlan) {
+	params := p.params
+
+	plan = new(StCPlan)
+	plan.LogFVSlots = p.logFVSlots
+	plan.Split = append([]int{}, split...)
+	plan.Depth = len(split)
+	plan.Ratios = make([]float64, len(split)+1)
+	plan.N1 = make([]int, len(split)+1)
+
+	// Row rotation
+	plan.KeySwitches = 1
+
+	rotations := make(map[int]bool)
+	for i, cost := range costs {
+		plan.Ratios[i] = cost.ratio
+		plan.N1[i] = cost.N1
+		plan.KeySwitches += cost.keySwitches
+		plan.PlainMults += cost.plainMults
+		for _, rot := range cost.rotations {
+			rotations[rot] = true
+		}
+	}
+
+	plan.Rotations = make([]int, 0, len(rotations))
+	for rot := range rotations {
+		plan.Rotations = append(plan.Rotations, rot)
+	}
+	sort.Ints(plan.Rotations)
+
+	keySize := params.Beta() * 2 * params.QPiCount() * params.N() * 8
+	plan.KeyMemory = (len(plan.Rotations) + 1) * keySize
+	return
+}
+
+// matrixCosts returns the costs of the matrix of the given cache key for each candidate ratio.
+func (p *stcPlanner) matrixCosts(key [3]int) []stcMatrixCost {
+	if costs, ok := p.costs[key]; ok {
+		return costs
+	}
+
+	index := p.matrixIndex(key)
+	costs := make([]stcMatrixCost, len(stcPlanRatios))
+	for r, ratio := range stcPlanRatios {
+		costs[r] = p.matrixCost(index, ratio)
+	}
+
+	p.costs[key] = costs
+	return costs
+}
+
+// matrixIndex returns the indexes of the non-zero diagonals of the matrix of the given cache key.
+func (p *stcPlanner) matrixIndex(key [3]int) (index map[int]bool) {
+	fvSlots := 1 << p.logFVSlots
+	index = p.factors[key[0]]
+	for i := key[0] + 1; i < key[1]; i++ {
+		index = multDiabIndex(p.factors[i], index, fvSlots)
+	}
+
+	switch key[2] {
+	case 1:
+		index = multDiabIndex(p.factors[p.logFVSlots-2], index, fvSlots)
+	case 2:
+		index = multDiabIndex(p.factors[p.logFVSlots-1], index, fvSlots)
+	}
+	return
+}
+
+// matrixCost returns the cost of the matrix with the given non-zero diagonals, encoded by EncodeDiagMatrixT
+// with the given ratio. The rotations are those given by GenRotationIndexesForSlotsToCoeffsMat, without 0.
+func (p *stcPlanner) matrixCost(index map[int]bool, ratio float64) (cost stcMatrixCost) {
+	fvSlots := 1 << p.logFVSlots
+
+	cost.ratio = ratio
+	cost.plainMults = len(index)
+
+	if len(index) < 3 {
+		for j := range index {
+			if j != 0 {
+				cost.rotations = append(cost.rotations, j)
+				cost.keySwitches++
+			}
+		}
+		return
+	}
+
+	cost.N1 = findbestbabygiantstepsplit(index, fvSlots, ratio)
+	giant, baby := bsgsIndex(index, fvSlots, cost.N1)
+	for _, rot := range baby {
+		if rot != 0 {
+			cost.rotations = append(cost.rotations, rot)
+			cost.keySwitches++
+		}
+	}
+
+	for j := range giant {
+		if j != 0 {
+			cost.rotations = append(cost.rotations, (j*cost.N1)&(fvSlots-1))
+			cost.keySwitches++
+		}
+	}
+	return
+}
+
+// fits returns true if the plan fits the budget.
+func (plan *StCPlan) fits(budget StCBudget) bool {
+	return (budget.MaxDepth == 0 || plan.Depth <= budget.MaxDepth) &&
+		(budget.MaxKeyMemory == 0 || plan.KeyMemory <= budget.MaxKeyMemory) &&
+		(budget.MaxKeySwitches == 0 || plan.KeySwitches <= budget.MaxKeySwitches)
+}
+
+// better returns true if the plan has fewer key-switches than other, or as many with fewer rotations, or
+// as many rotations with a smaller depth.
+func (plan *StCPlan) better(other *StCPlan) bool {
+	if plan.KeySwitches != other.KeySwitches {
+		return plan.KeySwitches < other.KeySwitches
+	}
+
+	if len(plan.Rotations) != len(other.Rotations) {
+		return len(plan.Rotations) < len(other.Rotations)
+	}
+
+	return plan.Depth < other.Depth
+}
+
+// multDiabIndex returns the indexes of the non-zero diagonals of multDiabMats(A, B).
+func multDiabIndex(A, B map[int]bool, N int) (res map[int]bool) {
+	res = make(map[int]bool)
+	for rotA := range A {
+		for rotB := range B {
+			res[(rotA+rotB)%(N/2)] = true
+		}
+	}
+	return
+}
+
+// genDcdDiabDecompIndex returns the indexes of the non-zero diagonals of the factors given by genDcdDiabDecomp.
+func genDcdDiabDecompIndex(logSlots int, plainModulus uint64) (res []map[int]bool) {
+	roots := computePrimitiveRoots(1<<(logSlots+1), plainModulus)
+	diabMats := genDcdDiabDecomp(logSlots, roots)
+
+	res = make([]map[int]bool, len(diabMats))
+	for i, diabMat := range diabMats {
+		res[i] = make(map[int]bool, len(diabMat))
+		for rot := range diabMat {
+			res[i][rot] = true
+		}
+	}
+	return
+}
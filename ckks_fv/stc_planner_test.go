@@ -0,0 +1,139 @@
+package ckks_fv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanSlotsToCoeffs(t *testing.T) {
+	params, _ := genTestParams(genTestHalfBootParams(RtFHeraParams[0]))
+	encoder := NewMFVEncoder(params)
+	kgen := NewKeyGenerator(params)
+	numFactors := params.LogFVSlots() - 2
+
+	// The splits of the radix 1 and 2 of GenSlotToCoeffMatFV
+	radix1 := make([]int, numFactors)
+	for i := range radix1 {
+		radix1[i] = 1
+	}
+
+	radix2 := []int{}
+	if numFactors%2 == 0 {
+		radix2 = append(radix2, 1)
+	}
+	for len(radix2) < numFactors/2 {
+		radix2 = append(radix2, 2)
+	}
+	radix2 = append(radix2, 1)
+
+	radix1Plan, err := NewStCPlan(params, radix1, 16)
+	require.NoError(t, err)
+
+	t.Run(testString("PlanSlotsToCoeffs/Radix/", params), func(t *testing.T) {
+		for radix, split := range map[int][]int{1: radix1, 2: radix2} {
+			var want []map[int][]uint64
+			if radix == 1 {
+				want = genDcdMats(params.LogFVSlots(), params.PlainModulus())
+			} else {
+				want = genDcdMatsRad2(params.LogFVSlots(), params.PlainModulus())
+			}
+			require.Equal(t, want, genDcdMatsSplit(params.LogFVSlots(), params.PlainModulus(), split))
+
+			plan, err := NewStCPlan(params, split, 16)
+			require.NoError(t, err)
+
+			pDcds := encoder.GenSlotToCoeffMatFV(radix)
+			require.Equal(t, len(pDcds[0])-1, plan.Depth)
+			for i, matrix := range pDcds[0] {
+				require.Equal(t, matrix.N1, plan.N1[i])
+			}
+
+			rotations := []int{}
+			for _, rot := range kgen.GenRotationIndexesForSlotsToCoeffsMat(pDcds) {
+				if rot != 0 {
+					rotations = append(rotations, rot)
+				}
+			}
+			require.ElementsMatch(t, rotations, plan.Rotations)
+		}
+	})
+
+	t.Run(testString("PlanSlotsToCoeffs/Budget/", params), func(t *testing.T) {
+		plan, err := PlanSlotsToCoeffs(params, StCBudget{})
+		require.NoError(t, err)
+		if testing.Verbose() {
+			t.Log(plan.String())
+		}
+		require.LessOrEqual(t, plan.KeySwitches, radix1Plan.KeySwitches)
+
+		for depth := 1; depth < 4; depth++ {
+			plan, err := PlanSlotsToCoeffs(params, StCBudget{MaxDepth: depth})
+			require.NoError(t, err)
+			require.LessOrEqual(t, plan.Depth, depth)
+			require.Len(t, plan.Ratios, plan.Depth+1)
+		}
+
+		plan, err = PlanSlotsToCoeffs(params, StCBudget{MaxKeyMemory: radix1Plan.KeyMemory, MaxKeySwitches: radix1Plan.KeySwitches})
+		require.NoError(t, err)
+		require.LessOrEqual(t, plan.KeyMemory, radix1Plan.KeyMemory)
+		require.LessOrEqual(t, plan.KeySwitches, radix1Plan.KeySwitches)
+
+		_, err = PlanSlotsToCoeffs(params, StCBudget{MaxKeySwitches: 1})
+		assert.Error(t, err)
+
+		_, err = NewStCPlan(params, radix1[1:], 16)
+		assert.Error(t, err)
+
+		_, err = NewStCPlan(params, append([]int{0}, radix1...), 16)
+		assert.Error(t, err)
+	})
+
+	t.Run(testString("PlanSlotsToCoeffs/SlotsToCoeffs/", params), func(t *testing.T) {
+		plan, err := PlanSlotsToCoeffs(params, StCBudget{MaxDepth: 2})
+		require.NoError(t, err)
+
+		pDcds := encoder.GenSlotToCoeffMatFVFromPlan(plan)
+		require.Len(t, pDcds[0], plan.Depth+1)
+		pDcdsRadix1 := encoder.GenSlotToCoeffMatFV(1)
+
+		sk, pk := kgen.GenKeyPair()
+		rotations := append(kgen.GenRotationIndexesForSlotsToCoeffsMat(pDcdsRadix1), plan.Rotations...)
+		rtks := kgen.GenRotationKeysForRotations(rotations, true, sk)
+
+		coeffs := newTestKey(params.FVSlots(), params.PlainModulus())
+		pt := NewPlaintextFV(params)
+		encoder.EncodeUintSmall(coeffs, pt)
+		ct := NewMFVEncryptorFromPk(params, pk).EncryptNew(pt)
+		decryptor := NewMFVDecryptor(params, sk)
+
+		want := NewMFVEvaluator(params, EvaluationKey{Rtks: rtks}, pDcdsRadix1).SlotsToCoeffsNoModSwitch(ct)
+		have := NewMFVEvaluator(params, EvaluationKey{Rtks: rtks}, pDcds).SlotsToCoeffsNoModSwitch(ct)
+		require.Equal(t, encoder.DecodeUintNew(decryptor.DecryptNew(want)), encoder.DecodeUintNew(decryptor.DecryptNew(have)))
+	})
+
+	t.Run(testString("PlanSlotsToCoeffs/Transcipher/", params), func(t *testing.T) {
+		tcParams := genTestTranscipherParams(RtFHeraParams[0], CipherHera, 5, 1, HeraModDownParams128[0])
+		require.Equal(t, params.LogFVSlots(), tcParams.LogFVSlots())
+
+		plan, err := PlanSlotsToCoeffs(params, StCBudget{MaxDepth: 2})
+		require.NoError(t, err)
+		tcParams.StCPlan = plan
+		setTestStCModDown(tcParams)
+		require.Len(t, tcParams.StCModDown, plan.Depth)
+		require.NoError(t, tcParams.Validate())
+
+		// The plan should be a decomposition for the FV slots of the parameters
+		invalid := tcParams.Copy()
+		invalid.StCPlan.LogFVSlots--
+		assert.Error(t, invalid.Validate())
+		invalid.StCPlan = plan.Copy()
+		invalid.StCPlan.Ratios = invalid.StCPlan.Ratios[1:]
+		assert.Error(t, invalid.Validate())
+
+		testctx, err := genTestTranscipherContext(tcParams)
+		require.NoError(t, err)
+		testTranscipher(testctx, t)
+	})
+}
@@ -0,0 +1,594 @@
+package ckks_fv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// CipherType denotes the symmetric cipher used in the RtF transciphering framework.
+type CipherType int
+
+// Symmetric ciphers supported by the RtF transciphering framework.
+const (
+	CipherHera CipherType = iota
+	CipherRubato
+	CipherPasta
+)
+
+// String returns the name of the cipher.
+func (cipher CipherType) String() string {
+	switch cipher {
+	case CipherHera:
+		return "HERA"
+	case CipherRubato:
+		return "Rubato"
+	case CipherPasta:
+		return "PASTA"
+	default:
+		return fmt.Sprintf("CipherType(%d)", int(cipher))
+	}
+}
+
+// TranscipherParameters is a struct for the parameters of the RtF transciphering framework.
+// It gathers the half-bootstrapping parameters, the symmetric cipher, the radix of the
+// SlotsToCoeffs matrices and the modulus switching schedule of the cipher and of SlotsToCoeffs.
+//
+// By default, data are encoded in full coefficients (LogFVSlots = LogN) when LogSlots = LogN-1,
+// and in LogSlots slots otherwise. LogBlockSlots sets any other number of FV slots between MinLogN
+// and LogSlots+1, so that the size of the blocks follows the data rate.
+//
+// The SlotsToCoeffs matrices are given by the Radix, or by the StCPlan if it is set (see PlanSlotsToCoeffs,
+// whose Parameters should be those of Params()), in which case the Radix is ignored.
+type TranscipherParameters struct {
+	HalfBootParameters
+	ModDownParams
+	Cipher        CipherType
+	CipherParam   int      // Number of rounds for CipherHera, index of RubatoParams for CipherRubato, index of PastaParams for CipherPasta
+	Radix         int      // Radix of the SlotsToCoeffs matrices (0, 1 or 2)
+	LogBlockSlots int      // Log of the number of FV slots, i.e. of values per block (0 for the default)
+	StCPlan       *StCPlan // Decomposition of the SlotsToCoeffs matrices (nil for the Radix)
+}
+
+// Copy returns a deep copy of the target TranscipherParameters.
+func (tcParams *TranscipherParameters) Copy() *TranscipherParameters {
+	paramsCopy := &TranscipherParameters{
+		HalfBootParameters: *tcParams.HalfBootParameters.Copy(),
+		Cipher:             tcParams.Cipher,
+		CipherParam:        tcParams.CipherParam,
+		Radix:              tcParams.Radix,
+		LogBlockSlots:      tcParams.LogBlockSlots,
+	}
+
+	if tcParams.StCPlan != nil {
+		paramsCopy.StCPlan = tcParams.StCPlan.Copy()
+	}
+
+	paramsCopy.CipherModDown = make([]int, len(tcParams.CipherModDown))
+	copy(paramsCopy.CipherModDown, tcParams.CipherModDown)
+
+	paramsCopy.StCModDown = make([]int, len(tcParams.StCModDown))
+	copy(paramsCopy.StCModDown, tcParams.StCModDown)
+
+	return paramsCopy
+}
+
+// Validate checks the consistency of the cipher, its parameter and its modulus switching schedule.
+func (tcParams *TranscipherParameters) Validate() error {
+	if err := tcParams.HalfBootParameters.Validate(); err != nil {
+		return err
+	}
+
+	if err := tcParams.validateCipher(); err != nil {
+		return err
+	}
+
+	if len(tcParams.CipherModDown) != tcParams.NumRound()+1 {
+		return fmt.Errorf("CipherModDown should have %d elements but has %d", tcParams.NumRound()+1, len(tcParams.CipherModDown))
+	}
+
+	if err := tcParams.validateSlots(); err != nil {
+		return err
+	}
+
+	if len(tcParams.StCModDown) != tcParams.StCDepth() {
+		return fmt.Errorf("StCModDown should have %d elements but has %d", tcParams.StCDepth(), len(tcParams.StCModDown))
+	}
+
+	return tcParams.validateModDown(tcParams.CipherModDown, tcParams.StCModDown)
+}
+
+// validateCipher checks the consistency of the cipher and of its parameter.
+func (tcParams *TranscipherParameters) validateCipher() error {
+	switch tcParams.Cipher {
+	case CipherHera:
+		if tcParams.CipherParam < 1 {
+			return fmt.Errorf("invalid number of rounds for HERA: %d", tcParams.CipherParam)
+		}
+		if tcParams.HalfBootParameters.PlainModulus == 0 {
+			return fmt.Errorf("plaintext modulus is not set")
+		}
+		if err := validateCubeModulus(tcParams.HalfBootParameters.PlainModulus); err != nil {
+			return fmt.Errorf("invalid plaintext modulus for HERA: %v", err)
+		}
+	case CipherRubato:
+		if tcParams.CipherParam < 0 || tcParams.CipherParam >= len(RubatoParams) {
+			return fmt.Errorf("invalid Rubato parameter: %d", tcParams.CipherParam)
+		}
+		t := RubatoParams[tcParams.CipherParam].PlainModulus
+		if tcParams.HalfBootParameters.PlainModulus != 0 && tcParams.HalfBootParameters.PlainModulus != t {
+			return fmt.Errorf("plaintext modulus %d does not match the Rubato plaintext modulus %d", tcParams.HalfBootParameters.PlainModulus, t)
+		}
+	case CipherPasta:
+		if tcParams.CipherParam < 0 || tcParams.CipherParam >= len(PastaParams) {
+			return fmt.Errorf("invalid PASTA parameter: %d", tcParams.CipherParam)
+		}
+		if err := PastaParams[tcParams.CipherParam].Validate(); err != nil {
+			return fmt.Errorf("invalid PASTA parameter: %v", err)
+		}
+		t := PastaParams[tcParams.CipherParam].PlainModulus
+		if tcParams.HalfBootParameters.PlainModulus != 0 && tcParams.HalfBootParameters.PlainModulus != t {
+			return fmt.Errorf("plaintext modulus %d does not match the PASTA plaintext modulus %d", tcParams.HalfBootParameters.PlainModulus, t)
+		}
+	default:
+		return fmt.Errorf("invalid cipher: %v", tcParams.Cipher)
+	}
+	return nil
+}
+
+// validateSlots checks the FV slots and that the StCPlan, or the radix of the SlotsToCoeffs matrices, is supported
+// for the FV slots.
+func (tcParams *TranscipherParameters) validateSlots() error {
+	if err := tcParams.validateFVSlots(); err != nil {
+		return err
+	}
+
+	if tcParams.StCPlan != nil {
+		if err := tcParams.StCPlan.validate(tcParams.LogFVSlots()); err != nil {
+			return fmt.Errorf("invalid SlotsToCoeffs plan: %w", err)
+		}
+		return nil
+	}
+
+	return tcParams.validateRadix(tcParams.LogFVSlots())
+}
+
+// validateFVSlots checks LogSlots and LogBlockSlots, which set the number of FV slots.
+func (tcParams *TranscipherParameters) validateFVSlots() error {
+	if tcParams.LogSlots < 0 || tcParams.LogSlots > tcParams.LogN-1 {
+		return fmt.Errorf("LogSlots should be between 0 and %d", tcParams.LogN-1)
+	}
+
+	if tcParams.LogBlockSlots != 0 && (tcParams.LogBlockSlots < MinLogN || tcParams.LogBlockSlots > tcParams.LogSlots+1) {
+		return fmt.Errorf("LogBlockSlots should be 0 or between %d and LogSlots+1 = %d", MinLogN, tcParams.LogSlots+1)
+	}
+
+	return nil
+}
+
+// validateRadix checks that the radix of the SlotsToCoeffs matrices is supported for logFVSlots FV slots.
+func (tcParams *TranscipherParameters) validateRadix(logFVSlots int) error {
+	if tcParams.Radix < 0 || tcParams.Radix > 2 {
+		return fmt.Errorf("invalid radix: %d", tcParams.Radix)
+	}
+
+	if tcParams.Radix == 0 && logFVSlots != 4 {
+		return fmt.Errorf("radix 0 requires LogFVSlots = 4 but LogFVSlots = %d", logFVSlots)
+	}
+
+	return nil
+}
+
+// validateModDown checks that the modulus switching schedules are non-negative and do not drop more than MaxLevel moduli.
+func (tcParams *TranscipherParameters) validateModDown(schedules ...[]int) error {
+	total := 0
+	for _, schedule := range schedules {
+		for _, nbModDown := range schedule {
+			if nbModDown < 0 {
+				return fmt.Errorf("invalid modulus switching schedule: negative number of moduli dropped")
+			}
+			total += nbModDown
+		}
+	}
+
+	if total > tcParams.MaxLevel() {
+		return fmt.Errorf("modulus switching schedule drops %d moduli but MaxLevel = %d", total, tcParams.MaxLevel())
+	}
+	return nil
+}
+
+// Params generates a new set of Parameters from the TranscipherParameters,
+// with the plaintext modulus of the cipher and LogFVSlots set.
+func (tcParams *TranscipherParameters) Params() (p *Parameters, err error) {
+	hbtpParams := tcParams.HalfBootParameters
+	hbtpParams.PlainModulus = tcParams.PlainModulus()
+
+	if p, err = hbtpParams.Params(); err != nil {
+		return nil, err
+	}
+
+	p.SetLogFVSlots(tcParams.LogFVSlots())
+	return
+}
+
+// FullCoeffs returns true if data are encoded in the full coefficients of the plaintext with LogSlots slots,
+// i.e. if LogFVSlots = LogSlots+1, in which case the half-bootstrapping returns two ciphertexts.
+func (tcParams *TranscipherParameters) FullCoeffs() bool {
+	return tcParams.LogFVSlots() == tcParams.LogSlots+1
+}
+
+// StCDepth returns the number of SlotsToCoeffs matrices evaluated before the last one, i.e. the number
+// of elements of StCModDown, for the StCPlan, or the radix and the FV slots of the parameters.
+func (tcParams *TranscipherParameters) StCDepth() int {
+	if tcParams.StCPlan != nil {
+		return tcParams.StCPlan.Depth
+	}
+
+	switch tcParams.Radix {
+	case 0:
+		return 1
+	case 2:
+		return tcParams.LogFVSlots() / 2
+	}
+	return tcParams.LogFVSlots() - 2
+}
+
+// genSlotToCoeffMat generates the SlotsToCoeffs matrices of the StCPlan if it is set, and of the radix otherwise.
+func (tcParams *TranscipherParameters) genSlotToCoeffMat(encoder MFVEncoder) [][]*PtDiagMatrixT {
+	if tcParams.StCPlan != nil {
+		return encoder.GenSlotToCoeffMatFVFromPlan(tcParams.StCPlan)
+	}
+	return encoder.GenSlotToCoeffMatFV(tcParams.Radix)
+}
+
+// LogFVSlots returns the log2 of the number of FV slots, i.e. of the number of nonces per keystream.
+func (tcParams *TranscipherParameters) LogFVSlots() int {
+	switch {
+	case tcParams.LogBlockSlots != 0:
+		return tcParams.LogBlockSlots
+	case tcParams.LogSlots == tcParams.LogN-1:
+		return tcParams.LogN
+	}
+	return tcParams.LogSlots
+}
+
+// PlainModulus returns the plaintext modulus of the cipher.
+func (tcParams *TranscipherParameters) PlainModulus() uint64 {
+	switch tcParams.Cipher {
+	case CipherRubato:
+		return RubatoParams[tcParams.CipherParam].PlainModulus
+	case CipherPasta:
+		return PastaParams[tcParams.CipherParam].PlainModulus
+	}
+	return tcParams.HalfBootParameters.PlainModulus
+}
+
+// usesCounter returns false if the keystream of the cipher depends only on the nonces (HERA).
+func (tcParams *TranscipherParameters) usesCounter() bool {
+	return tcParams.Cipher != CipherHera
+}
+
+// NumRound returns the number of rounds of the cipher.
+func (tcParams *TranscipherParameters) NumRound() int {
+	switch tcParams.Cipher {
+	case CipherRubato:
+		return RubatoParams[tcParams.CipherParam].NumRound
+	case CipherPasta:
+		return PastaParams[tcParams.CipherParam].NumRound
+	}
+	return tcParams.CipherParam
+}
+
+// BlockSize returns the number of keystream elements produced by the cipher for each nonce.
+func (tcParams *TranscipherParameters) BlockSize() int {
+	switch tcParams.Cipher {
+	case CipherRubato:
+		return RubatoParams[tcParams.CipherParam].Blocksize - 4
+	case CipherPasta:
+		return PastaParams[tcParams.CipherParam].Blocksize
+	}
+	return 16
+}
+
+// KeySize returns the number of elements of the symmetric key of the cipher.
+func (tcParams *TranscipherParameters) KeySize() int {
+	switch tcParams.Cipher {
+	case CipherRubato:
+		return RubatoParams[tcParams.CipherParam].Blocksize
+	case CipherPasta:
+		return 2 * PastaParams[tcParams.CipherParam].Blocksize
+	}
+	return 16
+}
+
+// MessageScaling returns the scaling factor applied to the data before they are encoded in the plaintext space.
+func (tcParams *TranscipherParameters) MessageScaling() float64 {
+	return float64(tcParams.PlainModulus()) / tcParams.MessageRatio
+}
+
+// errIntOnly is returned by the methods of a Transcipherer created by NewIntTranscipherer which need the
+// half-bootstrapping or SlotsToCoeffs.
+var errIntOnly = errors.New("the transcipherer only supports the integer transciphering (see NewIntTranscipherer)")
+
+// Transcipherer is a struct to evaluate the RtF transciphering framework.
+// In the offline phase, the keystream of the symmetric cipher is evaluated in the FV scheme
+// and brought to the coefficients at level 0. In the online phase, the keystream is removed
+// from the symmetric ciphertext and the result is half-bootstrapped to a CKKS ciphertext.
+type Transcipherer struct {
+	TranscipherParameters
+	params *Parameters
+
+	fvEncoder   MFVEncoder
+	fvEvaluator MFVEvaluator
+	hbtp        *HalfBootstrapper
+
+	cipher       MFVCipher
+	fvEvaluators []MFVEvaluator // Worker pool of SlotsToCoeffs, where fvEvaluators[0] is fvEvaluator
+
+	scale float64 // Scale of the ciphertext before the half-bootstrapping
+}
+
+// NewTranscipherer creates a new Transcipherer.
+// The public key is used to encrypt the initial states of the cipher, and btpKey should contain the
+// rotation keys generated from KeyGenerator.GenRotationIndexesForTranscipher (with the conjugation key).
+func NewTranscipherer(tcParams *TranscipherParameters, pk *PublicKey, btpKey BootstrappingKey) (tc *Transcipherer, err error) {
+	if err = tcParams.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid transcipher parameters: %w", err)
+	}
+
+	if tc, err = newTranscipherer(tcParams); err != nil {
+		return nil, err
+	}
+	params := tc.params
+
+	pDcds := tc.genSlotToCoeffMat(tc.fvEncoder)
+
+	if tc.hbtp, err = NewHalfBootstrapper(params, &tc.HalfBootParameters, btpKey); err != nil {
+		return nil, err
+	}
+
+	tc.setCipher(pk, EvaluationKey{Rlk: btpKey.Rlk, Rtks: btpKey.Rtks}, pDcds)
+
+	tc.scale = math.Exp2(math.Round(math.Log2(float64(params.qi[0]) / float64(params.plainModulus) * tc.MessageScaling())))
+
+	return tc, nil
+}
+
+// newTranscipherer returns a Transcipherer with a copy of the parameters and the FV encoder.
+func newTranscipherer(tcParams *TranscipherParameters) (tc *Transcipherer, err error) {
+	tc = new(Transcipherer)
+	tc.TranscipherParameters = *tcParams.Copy()
+	tc.HalfBootParameters.PlainModulus = tcParams.PlainModulus()
+
+	if tc.params, err = tc.Params(); err != nil {
+		return nil, err
+	}
+
+	tc.fvEncoder = NewMFVEncoder(tc.params)
+	return tc, nil
+}
+
+// setCipher sets the FV evaluator of the Transcipherer, with the evaluation key and the SlotsToCoeffs matrices
+// pDcds, and the homomorphic cipher, whose initial states are encrypted with the public key.
+func (tc *Transcipherer) setCipher(pk *PublicKey, evk EvaluationKey, pDcds [][]*PtDiagMatrixT) {
+	fvEncryptor := NewMFVEncryptorFromPk(tc.params, pk)
+	tc.fvEvaluator = NewMFVEvaluator(tc.params, evk, pDcds)
+
+	tc.fvEvaluators = []MFVEvaluator{tc.fvEvaluator}
+
+	tc.cipher = NewMFVCipher(tc.Cipher, tc.CipherParam, tc.params, tc.fvEncoder, fvEncryptor, tc.fvEvaluator, tc.CipherModDown[0])
+}
+
+// Parameters returns the Parameters of the Transcipherer.
+func (tc *Transcipherer) Parameters() *Parameters {
+	return tc.params
+}
+
+// CheckCiphertext checks that the symmetric ciphertext has been produced with the cipher and the
+// parameters of the Transcipherer, and that it has at most BlockSize blocks.
+func (tc *Transcipherer) CheckCiphertext(symCt *SymmetricCiphertext) error {
+	if symCt.Cipher != tc.Cipher || symCt.CipherParam != tc.CipherParam {
+		return fmt.Errorf("cipher %v (%d) does not match the transcipherer cipher %v (%d)", symCt.Cipher, symCt.CipherParam, tc.Cipher, tc.CipherParam)
+	}
+
+	if len(symCt.Blocks) > tc.BlockSize() {
+		return fmt.Errorf("%d blocks given but at most %d are supported", len(symCt.Blocks), tc.BlockSize())
+	}
+
+	return symCt.Validate(tc.params)
+}
+
+// SetEncapsulationKeys sets the switching keys of the sparse-secret encapsulation of the half-bootstrapping
+// (see HalfBootstrapper.SetEncapsulationKeys), which are needed if EphemeralSecretWeight is non-zero.
+func (tc *Transcipherer) SetEncapsulationKeys(swkDtS, swkStD *SwitchingKey) error {
+	if tc.hbtp == nil {
+		return errIntOnly
+	}
+	return tc.hbtp.SetEncapsulationKeys(swkDtS, swkStD)
+}
+
+// SetWorkers sets the number of goroutines over which KeyStream spreads the state words of the cipher and
+// the SlotsToCoeffs of the keystream blocks. The keystream is identical to the serial evaluation, which is
+// the default (workers = 1).
+func (tc *Transcipherer) SetWorkers(workers int) {
+	tc.cipher.SetWorkers(workers)
+	for len(tc.fvEvaluators) < workers {
+		tc.fvEvaluators = append(tc.fvEvaluators, tc.fvEvaluator.ShallowCopy())
+	}
+	tc.fvEvaluators = tc.fvEvaluators[:workers]
+}
+
+// EncKey encrypts the symmetric key in the FV scheme, at the level expected by KeyStream.
+// As it requires the plaintext key, it is meant for testing: the server should rather use LoadKey
+// with a bundle produced by the client with RtFEncryptor.EncryptKeyNew.
+func (tc *Transcipherer) EncKey(key []uint64) (kCt []*Ciphertext) {
+	return tc.cipher.EncKey(key)
+}
+
+// LoadKey checks that the symmetric key bundle has been produced by the client for the cipher and the
+// parameters of the Transcipherer, and returns the encrypted symmetric key expected by KeyStream.
+func (tc *Transcipherer) LoadKey(bundle *SymmetricKeyBundle) (kCt []*Ciphertext, err error) {
+	if bundle.Cipher != tc.Cipher || bundle.CipherParam != tc.CipherParam {
+		return nil, fmt.Errorf("cipher %v (%d) does not match the transcipherer cipher %v (%d)", bundle.Cipher, bundle.CipherParam, tc.Cipher, tc.CipherParam)
+	}
+	return tc.cipher.LoadKey(bundle)
+}
+
+// KeyStreamBatch is the FV keystream of a batch of nonces, as returned by Transcipherer.KeyStream.
+// It records the nonces and the counter it has been evaluated with, so that the keystream of another
+// batch is rejected by Transcipher.
+type KeyStreamBatch struct {
+	Nonces  [][]byte
+	Counter []byte
+	Cts     []*Ciphertext // BlockSize FV ciphertexts at level 0 whose coefficients hold the keystream
+}
+
+// newKeyStreamBatch returns a KeyStreamBatch holding fvKeystreams and a copy of the nonces and of the counter.
+func newKeyStreamBatch(nonces [][]byte, counter []byte, fvKeystreams []*Ciphertext) (ks *KeyStreamBatch) {
+	ks = &KeyStreamBatch{Nonces: make([][]byte, len(nonces)), Counter: make([]byte, len(counter)), Cts: fvKeystreams}
+	for i := range nonces {
+		ks.Nonces[i] = make([]byte, len(nonces[i]))
+		copy(ks.Nonces[i], nonces[i])
+	}
+	copy(ks.Counter, counter)
+	return
+}
+
+// KeyStream is the offline phase of the transciphering. It evaluates the keystream of the cipher for the
+// encrypted symmetric key kCt, with one nonce per FV slot and the counter (ignored by HERA), and returns
+// BlockSize FV ciphertexts at level 0 whose coefficients hold the keystream, along with the nonces and the counter.
+func (tc *Transcipherer) KeyStream(kCt []*Ciphertext, nonces [][]byte, counter []byte) (ks *KeyStreamBatch) {
+	if tc.hbtp == nil {
+		panic(fmt.Errorf("cannot KeyStream: %w", errIntOnly))
+	}
+
+	if len(nonces) != tc.params.FVSlots() {
+		panic(fmt.Sprintf("cannot KeyStream: %d nonces are expected but %d given", tc.params.FVSlots(), len(nonces)))
+	}
+
+	stCt := tc.cipher.Crypt(nonces, counter, kCt, tc.CipherModDown)
+
+	fvKeystreams := make([]*Ciphertext, tc.BlockSize())
+	parallelize(len(tc.fvEvaluators), len(fvKeystreams), func(w, i int) {
+		fvKeystreams[i] = tc.fvEvaluators[w].SlotsToCoeffs(stCt[i], tc.StCModDown)
+		if level := fvKeystreams[i].Level(); level != 0 {
+			tc.fvEvaluators[w].ModSwitchMany(fvKeystreams[i], fvKeystreams[i], level)
+		}
+	})
+
+	// Resets the initial states for the next evaluation of the cipher
+	tc.cipher.Reset(tc.CipherModDown[0])
+	return newKeyStreamBatch(nonces, counter, fvKeystreams)
+}
+
+// Transcipher is the online phase of the transciphering of a symmetric ciphertext of real values, as encrypted
+// by RtFEncryptor.EncryptNew. After checking the symmetric ciphertext with CheckCiphertext, it removes from each
+// block the FV keystream of the same index, as returned by KeyStream for the nonces and the counter of the symmetric
+// ciphertext, and half-bootstraps the result to CKKS.
+// The CKKS ciphertexts of the blocks are returned in order. If data are encoded in full coefficients, each block
+// gives two ciphertexts, which hold the first and the second half of the block respectively. Otherwise, each block
+// gives one ciphertext, whose first FVSlots slots hold the block (and the other slots are zero).
+func (tc *Transcipherer) Transcipher(symCt *SymmetricCiphertext, ks *KeyStreamBatch) (cts []*Ciphertext, err error) {
+	if err = tc.checkTranscipher(symCt, ks); err != nil {
+		return nil, fmt.Errorf("cannot Transcipher: %w", err)
+	}
+
+	if symCt.Complex {
+		return nil, errors.New("cannot Transcipher: the symmetric ciphertext holds complex values (see TranscipherComplex)")
+	}
+
+	cts = make([]*Ciphertext, 0, 2*len(symCt.Blocks))
+	for s, block := range symCt.Blocks {
+		ct0, ct1 := tc.transcipherBlock(block, ks.Cts[s])
+		cts = append(cts, ct0)
+		if ct1 != nil {
+			cts = append(cts, ct1)
+		}
+	}
+	return cts, nil
+}
+
+// TranscipherComplex is the online phase of the transciphering of a symmetric ciphertext of complex values, as
+// encrypted by RtFEncryptor.EncryptComplexNew. It returns one CKKS ciphertext per block, whose k-th slot holds the
+// k-th complex value of the block for k < FVSlots/2, and zero otherwise (see HalfBootstrapper.HalfBootComplex).
+func (tc *Transcipherer) TranscipherComplex(symCt *SymmetricCiphertext, ks *KeyStreamBatch) (cts []*Ciphertext, err error) {
+	if err = tc.checkTranscipher(symCt, ks); err != nil {
+		return nil, fmt.Errorf("cannot TranscipherComplex: %w", err)
+	}
+
+	if !symCt.Complex {
+		return nil, errors.New("cannot TranscipherComplex: the symmetric ciphertext holds real values (see Transcipher)")
+	}
+
+	cts = make([]*Ciphertext, len(symCt.Blocks))
+	for s, block := range symCt.Blocks {
+		cts[s] = tc.hbtp.HalfBootComplex(tc.removeKeyStream(block, ks.Cts[s]))
+	}
+	return cts, nil
+}
+
+// checkTranscipher checks the symmetric ciphertext with CheckCiphertext, that the keystream has been evaluated
+// with its nonces and its counter (ignored by HERA), and that there is a keystream ciphertext at level 0 for each
+// of its blocks.
+func (tc *Transcipherer) checkTranscipher(symCt *SymmetricCiphertext, ks *KeyStreamBatch) error {
+	if tc.hbtp == nil {
+		return errIntOnly
+	}
+
+	if err := tc.CheckCiphertext(symCt); err != nil {
+		return fmt.Errorf("invalid symmetric ciphertext: %w", err)
+	}
+
+	return tc.checkKeyStream(ks, symCt.Nonces, symCt.Counter, len(symCt.Blocks), 0)
+}
+
+// checkKeyStream checks that the keystream has been evaluated with the nonces and the counter (ignored by HERA),
+// and that it has a keystream ciphertext at the given level for each of the nbBlocks blocks.
+func (tc *Transcipherer) checkKeyStream(ks *KeyStreamBatch, nonces [][]byte, counter []byte, nbBlocks, level int) error {
+	if len(ks.Nonces) != len(nonces) {
+		return fmt.Errorf("keystream of %d nonces given for %d nonces", len(ks.Nonces), len(nonces))
+	}
+	for i := range nonces {
+		if !bytes.Equal(ks.Nonces[i], nonces[i]) {
+			return fmt.Errorf("nonce %d of the keystream does not match the symmetric ciphertext", i)
+		}
+	}
+
+	if tc.usesCounter() && !bytes.Equal(ks.Counter, counter) {
+		return errors.New("counter of the keystream does not match the symmetric ciphertext")
+	}
+
+	if len(ks.Cts) < nbBlocks {
+		return fmt.Errorf("%d keystream ciphertexts given for %d blocks", len(ks.Cts), nbBlocks)
+	}
+
+	for s := 0; s < nbBlocks; s++ {
+		if ks.Cts[s].Level() != level {
+			return fmt.Errorf("keystream %d should be at level %d but is at level %d", s, level, ks.Cts[s].Level())
+		}
+	}
+	return nil
+}
+
+// transcipherBlock removes the FV keystream from a block of a symmetric ciphertext and half-bootstraps the result
+// to CKKS. If data are encoded in full coefficients, ct0 and ct1 hold the first and the second half of the block
+// respectively. Otherwise, the first FVSlots slots of ct0 hold the block and ct1 is nil.
+func (tc *Transcipherer) transcipherBlock(block *PlaintextRingT, fvKeystream *Ciphertext) (ct0, ct1 *Ciphertext) {
+	return tc.hbtp.HalfBoot(tc.removeKeyStream(block, fvKeystream), !tc.FullCoeffs())
+}
+
+// removeKeyStream returns the FV ciphertext symCt - fvKeystream at level 0, in the NTT domain and with the
+// scale of the half-bootstrapping, marked as a CKKS ciphertext.
+func (tc *Transcipherer) removeKeyStream(symCt *PlaintextRingT, fvKeystream *Ciphertext) (ct *Ciphertext) {
+	pt := NewPlaintextFVLvl(tc.params, 0)
+	tc.fvEncoder.FVScaleUp(symCt, pt)
+
+	ct = NewCiphertextFVLvl(tc.params, 1, 0)
+	ct.Value()[0].Copy(pt.Value()[0])
+	tc.fvEvaluator.Sub(ct, fvKeystream, ct)
+	tc.fvEvaluator.TransformToNTT(ct, ct)
+	ct.SetScale(tc.scale)
+	ct.SetIsCKKS(true)
+	return
+}
@@ -0,0 +1,468 @@
+package ckks_fv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ldsec/lattigo/v2/utils"
+)
+
+// testTranscipherMinPrecision is the minimum number of bits of precision expected after transciphering.
+const testTranscipherMinPrecision = 10
+
+type testTranscipherContext struct {
+	tcParams      *TranscipherParameters
+	params        *Parameters
+	sk            *SecretKey
+	pk            *PublicKey
+	ckksEncoder   CKKSEncoder
+	ckksDecryptor CKKSDecryptor
+	tc            *Transcipherer
+}
+
+// genTestTranscipherParams returns TranscipherParameters scaled down by genTestHalfBootParams.
+// The SlotsToCoeffs modulus switching schedule is replaced by a schedule without modulus switching
+// as the tuned schedules depend on the ring degree.
+func genTestTranscipherParams(hbtpParams *HalfBootParameters, cipher CipherType, cipherParam, radix int, modDown ModDownParams) (tcParams *TranscipherParameters) {
+	tcParams = &TranscipherParameters{
+		HalfBootParameters: *genTestHalfBootParams(hbtpParams),
+		Cipher:             cipher,
+		CipherParam:        cipherParam,
+		Radix:              radix,
+	}
+	tcParams.CipherModDown = append([]int{}, modDown.CipherModDown...)
+	setTestStCModDown(tcParams)
+	return
+}
+
+// setTestStCModDown sets a SlotsToCoeffs schedule without modulus switching for the SlotsToCoeffs matrices of tcParams.
+func setTestStCModDown(tcParams *TranscipherParameters) {
+	tcParams.StCModDown = make([]int, tcParams.StCDepth())
+}
+
+func genTestTranscipherContext(tcParams *TranscipherParameters) (testctx *testTranscipherContext, err error) {
+	testctx = new(testTranscipherContext)
+	testctx.tcParams = tcParams
+
+	if testctx.params, err = tcParams.Params(); err != nil {
+		return nil, err
+	}
+
+	kgen := NewKeyGenerator(testctx.params)
+	testctx.sk, testctx.pk = kgen.GenKeyPairForHalfBoot(&tcParams.HalfBootParameters)
+	rotkeys := kgen.GenRotationKeysForRotations(kgen.GenRotationIndexesForTranscipher(tcParams), true, testctx.sk)
+	rlk := kgen.GenRelinearizationKey(testctx.sk)
+	btpKey := BootstrappingKey{Rlk: rlk, Rtks: rotkeys}
+
+	testctx.ckksEncoder = NewCKKSEncoder(testctx.params)
+	testctx.ckksDecryptor = NewCKKSDecryptor(testctx.params, testctx.sk)
+
+	if testctx.tc, err = NewTranscipherer(tcParams, testctx.pk, btpKey); err != nil {
+		return nil, err
+	}
+
+	if tcParams.EphemeralSecretWeight != 0 {
+		if err = testctx.tc.SetEncapsulationKeys(kgen.GenEncapsulationSwitchingKeys(&tcParams.HalfBootParameters, testctx.sk)); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (testctx *testTranscipherContext) verify(t *testing.T, valuesWant []float64, ct *Ciphertext) {
+	params := testctx.params
+	want := make([]complex128, params.Slots())
+	for i := range valuesWant {
+		want[i] = complex(valuesWant[i], 0)
+	}
+
+	precStats := GetPrecisionStats(params, testctx.ckksEncoder, testctx.ckksDecryptor, want, ct, params.LogSlots(), 0)
+	if testing.Verbose() {
+		t.Log(precStats.String())
+	}
+	require.GreaterOrEqual(t, real(precStats.MinPrecision), float64(testTranscipherMinPrecision))
+}
+
+func TestTranscipher(t *testing.T) {
+	t.Run("Transcipher/TranscipherParameters/Validate/", func(t *testing.T) {
+		tcParams := &TranscipherParameters{HalfBootParameters: *RtFHeraParams[1], Cipher: CipherHera, CipherParam: 4, Radix: 0}
+		tcParams.ModDownParams = HeraModDownParams80[1]
+		assert.NoError(t, tcParams.Validate())
+
+		tcParams.CipherParam = 5
+		assert.Error(t, tcParams.Validate())
+
+		tcParams.CipherParam = 4
+		tcParams.Radix = 2
+		tcParams.LogSlots = tcParams.LogN - 1
+		assert.Error(t, tcParams.Validate())
+		setTestStCModDown(tcParams)
+		assert.NoError(t, tcParams.Validate())
+
+		tcParams.Radix = 0
+		assert.Error(t, tcParams.Validate())
+
+		tcParams.Cipher = CipherType(-1)
+		assert.Error(t, tcParams.Validate())
+
+		tcParams = &TranscipherParameters{HalfBootParameters: *RtFRubatoParams[0], Cipher: CipherRubato, CipherParam: RUBATO80S, Radix: 2}
+		tcParams.ModDownParams = RubatoModDownParams[RUBATO80S]
+		assert.NoError(t, tcParams.Validate())
+		assert.Equal(t, RubatoParams[RUBATO80S].PlainModulus, tcParams.PlainModulus())
+		assert.Equal(t, 12, tcParams.BlockSize())
+
+		tcParams.HalfBootParameters.PlainModulus = RtFHeraParams[0].PlainModulus
+		assert.Error(t, tcParams.Validate())
+
+		tcParams = &TranscipherParameters{HalfBootParameters: *RtFRubatoParams[0], Cipher: CipherPasta, CipherParam: PASTA4, Radix: 2}
+		tcParams.CipherModDown = make([]int, PastaParams[PASTA4].NumRound+1)
+		setTestStCModDown(tcParams)
+		assert.NoError(t, tcParams.Validate())
+		assert.Equal(t, PastaParams[PASTA4].PlainModulus, tcParams.PlainModulus())
+		assert.Equal(t, 32, tcParams.BlockSize())
+		assert.Equal(t, 64, tcParams.KeySize())
+
+		tcParams.CipherParam = len(PastaParams)
+		assert.Error(t, tcParams.Validate())
+
+		tcParams = &TranscipherParameters{HalfBootParameters: *RtFHeraParams[1], Cipher: CipherHera, CipherParam: 4, Radix: 1}
+		tcParams.ModDownParams = HeraModDownParams80[1]
+		for _, logBlockSlots := range []int{MinLogN, tcParams.LogSlots + 1} {
+			tcParams.LogBlockSlots = logBlockSlots
+			setTestStCModDown(tcParams)
+			assert.NoError(t, tcParams.Validate())
+			assert.Equal(t, logBlockSlots, tcParams.LogFVSlots())
+			assert.Equal(t, logBlockSlots == tcParams.LogSlots+1, tcParams.FullCoeffs())
+		}
+
+		for _, logBlockSlots := range []int{MinLogN - 1, tcParams.LogSlots + 2} {
+			tcParams.LogBlockSlots = logBlockSlots
+			assert.Error(t, tcParams.Validate())
+		}
+
+		tcParams.LogBlockSlots = 0
+		setTestStCModDown(tcParams)
+		require.NoError(t, tcParams.Validate())
+		stcModDown := tcParams.StCModDown
+
+		tcParams.StCModDown = nil
+		assert.Error(t, tcParams.Validate())
+		tcParams.StCModDown = stcModDown[1:]
+		assert.Error(t, tcParams.Validate())
+		tcParams.StCModDown = append([]int{-1}, stcModDown[1:]...)
+		assert.Error(t, tcParams.Validate())
+		tcParams.StCModDown = append([]int{tcParams.MaxLevel()}, stcModDown[1:]...)
+		assert.Error(t, tcParams.Validate())
+	})
+
+	testCases := []*TranscipherParameters{
+		genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1]),
+		genTestTranscipherParams(RtFHeraParams[0], CipherHera, 5, 2, HeraModDownParams128[0]),
+		genTestTranscipherParams(RtFRubatoParams[0], CipherRubato, RUBATO80S, 2, RubatoModDownParams[RUBATO80S]),
+		genTestTranscipherParams(RtFHeraParams[1], CipherPasta, PASTA4, 0, testPastaModDown),
+		genTestTranscipherParams(RtFHeraParams[1], CipherPasta, PASTA4L, 0, testPastaLModDown),
+	}
+	testCases[3].HalfBootParameters.PlainModulus = 0 // set by the cipher
+	testCases[4].HalfBootParameters.PlainModulus = 0
+
+	for _, tcParams := range testCases {
+		testctx, err := genTestTranscipherContext(tcParams)
+		require.NoError(t, err)
+		testTranscipher(testctx, t)
+	}
+}
+
+func TestTranscipherKeyStreamLevelZero(t *testing.T) {
+	// SlotsToCoeffs drops all the remaining moduli, so that the keystream is already at level 0
+	tcParams := genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1])
+	tcParams.StCModDown = []int{tcParams.MaxLevel()}
+	for _, nbModDown := range tcParams.CipherModDown {
+		tcParams.StCModDown[0] -= nbModDown
+	}
+
+	testctx, err := genTestTranscipherContext(tcParams)
+	require.NoError(t, err)
+	params := testctx.params
+
+	enc := NewRtFEncryptor(tcParams, newTestKey(tcParams.KeySize(), params.PlainModulus()))
+	kCt, err := testctx.tc.LoadKey(enc.EncryptKeyNew(testctx.pk))
+	require.NoError(t, err)
+
+	ks := testctx.tc.KeyStream(kCt, newTestNonces(params.FVSlots()), newTestNonces(1)[0][:8])
+	for _, ct := range ks.Cts {
+		require.Equal(t, 0, ct.Level())
+	}
+}
+
+func TestTranscipherLogBlockSlots(t *testing.T) {
+	// Blocks filling the real and imaginary parts of the sparse slots, and blocks smaller than the sparse slots
+	testCases := []*TranscipherParameters{
+		genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 1, HeraModDownParams80[1]),
+		genTestTranscipherParams(RtFHeraParams[0], CipherHera, 5, 2, HeraModDownParams128[0]),
+		genTestTranscipherParams(RtFHeraParams[0], CipherHera, 5, 0, HeraModDownParams128[0]),
+	}
+	for i, logBlockSlots := range []int{5, 6, 4} {
+		testCases[i].LogBlockSlots = logBlockSlots
+		setTestStCModDown(testCases[i])
+	}
+
+	for _, tcParams := range testCases {
+		testctx, err := genTestTranscipherContext(tcParams)
+		require.NoError(t, err)
+		require.Equal(t, tcParams.LogBlockSlots, testctx.params.LogFVSlots())
+		testTranscipher(testctx, t)
+	}
+
+	testctx, err := genTestTranscipherContext(testCases[2])
+	require.NoError(t, err)
+	require.Less(t, testctx.params.LogFVSlots(), testctx.params.LogSlots())
+	testHalfBootRepackOverflows(testctx, t)
+}
+
+// testHalfBootRepackOverflows checks that the repacking of HalfBoot is correct when the overflows of the modulus
+// raising of both halves are each within SinRange but their sum is not, which happens with sparse FV slots if the
+// halves are added before the SineEval.
+func testHalfBootRepackOverflows(testctx *testTranscipherContext, t *testing.T) {
+	params := testctx.params
+	hbtp := testctx.tc.hbtp
+
+	t.Run(testString("TranscipherLogBlockSlots/HalfBootRepackOverflows/", params), func(t *testing.T) {
+		// Level and scale of the outputs of the CoeffsToSlots
+		ct0, _ := hbtp.modRaiseCoeffsToSlots(NewCiphertextCKKS(params, 1, 0, params.Scale()))
+		level, scale := ct0.Level(), ct0.Scale()
+
+		// Outputs of the CoeffsToSlots with the given overflow of the modulus raising (see modRaiseCoeffsToSlots)
+		encryptor := NewCKKSEncryptorFromPk(params, testctx.pk)
+		encrypt := func(values []float64, overflow int) *Ciphertext {
+			slots := make([]complex128, params.Slots())
+			for i := range slots {
+				slots[i] = complex((values[i]+hbtp.MessageRatio*float64(overflow))/float64(hbtp.SinRange), 0)
+			}
+			pt := NewPlaintextCKKS(params, level, scale)
+			testctx.ckksEncoder.EncodeComplexNTT(pt, slots, params.LogSlots())
+			return encryptor.EncryptNew(pt)
+		}
+
+		values0, values1 := make([]float64, params.Slots()), make([]float64, params.Slots())
+		for i := range values0 {
+			values0[i] = utils.RandFloat64(-1, 1)
+			values1[i] = utils.RandFloat64(-1, 1)
+		}
+
+		want, _ := hbtp.evalModRepack(encrypt(values0, 0), encrypt(values1, 0), true)
+		wantValues := testctx.ckksEncoder.DecodeComplex(testctx.ckksDecryptor.DecryptNew(want), params.LogSlots())
+
+		overflow := (2*hbtp.SinRange + 2) / 3
+		have, _ := hbtp.evalModRepack(encrypt(values0, overflow), encrypt(values1, overflow), true)
+
+		precStats := GetPrecisionStats(params, testctx.ckksEncoder, testctx.ckksDecryptor, wantValues, have, params.LogSlots(), 0)
+		if testing.Verbose() {
+			t.Log(precStats.String())
+		}
+		require.GreaterOrEqual(t, real(precStats.MinPrecision), float64(testTranscipherMinPrecision))
+	})
+}
+
+func TestTranscipherDenseSecret(t *testing.T) {
+	t.Run("TranscipherDenseSecret/Keys/", func(t *testing.T) {
+		tcParams := genTestTranscipherParams(RtFHeraDenseParams[1], CipherHera, 4, 0, HeraModDownParams80[1])
+		params, err := tcParams.Params()
+		require.NoError(t, err)
+
+		kgen := NewKeyGenerator(params)
+		sk, pk := kgen.GenKeyPairForHalfBoot(&tcParams.HalfBootParameters)
+		rotkeys := kgen.GenRotationKeysForRotations(kgen.GenRotationIndexesForTranscipher(tcParams), true, sk)
+		rlk := kgen.GenRelinearizationKey(sk)
+
+		// The switching keys of the sparse-secret encapsulation are required by HalfBoot
+		tc, err := NewTranscipherer(tcParams, pk, BootstrappingKey{Rlk: rlk, Rtks: rotkeys})
+		require.NoError(t, err)
+		assert.Error(t, tc.SetEncapsulationKeys(nil, nil))
+		ct := NewCKKSEncryptorFromPk(params, pk).EncryptNew(NewCKKSEncoder(params).EncodeComplexNTTNew(make([]complex128, params.Slots()), params.LogSlots()))
+		assert.Panics(t, func() { tc.hbtp.HalfBoot(ct, false) })
+
+		// The switching key to the ephemeral secret key is restricted to Q_0*P
+		swkDtS, swkStD := kgen.GenEncapsulationSwitchingKeys(&tcParams.HalfBootParameters, sk)
+		require.NoError(t, tc.SetEncapsulationKeys(swkDtS, swkStD))
+		for _, pol := range swkDtS.Value[0] {
+			for j := 1; j < params.QiCount(); j++ {
+				for _, c := range pol.Coeffs[j] {
+					require.Zero(t, c)
+				}
+			}
+		}
+
+		tcParams.EphemeralSecretWeight = 0
+		assert.Panics(t, func() { kgen.GenEncapsulationSwitchingKeys(&tcParams.HalfBootParameters, sk) })
+
+		tcParams = genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1])
+		tc, err = NewTranscipherer(tcParams, pk, BootstrappingKey{Rlk: rlk, Rtks: rotkeys})
+		require.NoError(t, err)
+		assert.Error(t, tc.SetEncapsulationKeys(swkDtS, swkStD))
+	})
+
+	testCases := []*TranscipherParameters{
+		genTestTranscipherParams(RtFHeraDenseParams[1], CipherHera, 4, 0, HeraModDownParams80[1]),
+		genTestTranscipherParams(RtFRubatoDenseParams[0], CipherRubato, RUBATO80S, 2, RubatoModDownParams[RUBATO80S]),
+	}
+
+	for _, tcParams := range testCases {
+		require.Zero(t, tcParams.H)
+		testctx, err := genTestTranscipherContext(tcParams)
+		require.NoError(t, err)
+		testTranscipher(testctx, t)
+	}
+}
+
+func TestTranscipherComplex(t *testing.T) {
+	testCases := []*TranscipherParameters{
+		genTestTranscipherParams(RtFHeraParams[1], CipherHera, 4, 0, HeraModDownParams80[1]),
+		genTestTranscipherParams(RtFHeraParams[0], CipherHera, 5, 2, HeraModDownParams128[0]),
+	}
+
+	for _, tcParams := range testCases {
+		testctx, err := genTestTranscipherContext(tcParams)
+		require.NoError(t, err)
+		params := testctx.params
+
+		t.Run(testString("TranscipherComplex/", params), func(t *testing.T) {
+			tc := testctx.tc
+			half := params.FVSlots() / 2
+			nonces := newTestNonces(params.FVSlots())
+			counter := newTestNonces(1)[0][:8]
+			enc := NewRtFEncryptor(tcParams, newTestKey(tcParams.KeySize(), params.PlainModulus()))
+
+			kCt, err := tc.LoadKey(enc.EncryptKeyNew(testctx.pk))
+			require.NoError(t, err)
+			ks := tc.KeyStream(kCt, nonces, counter)
+
+			data := make([]complex128, half+1)
+			for i := range data {
+				data[i] = complex(utils.RandFloat64(-1, 1), utils.RandFloat64(-1, 1))
+			}
+
+			symCt := enc.EncryptComplexNew(data, nonces, counter)
+			require.True(t, symCt.Complex)
+			require.Len(t, symCt.Blocks, 2)
+			require.NoError(t, tc.CheckCiphertext(symCt))
+
+			_, err = tc.Transcipher(symCt, ks)
+			assert.Error(t, err)
+			cts, err := tc.TranscipherComplex(symCt, ks)
+			require.NoError(t, err)
+			require.Len(t, cts, 2)
+
+			for s, ct := range cts {
+				// The k-th value of the block is in the k-th slot, and the other slots are zero
+				want := make([]complex128, params.Slots())
+				copy(want, data[s*half:utils.MinInt((s+1)*half, len(data))])
+
+				precStats := GetPrecisionStats(params, testctx.ckksEncoder, testctx.ckksDecryptor, want, ct, params.LogSlots(), 0)
+				if testing.Verbose() {
+					t.Log(precStats.String())
+				}
+				require.GreaterOrEqual(t, real(precStats.MinPrecision), float64(testTranscipherMinPrecision))
+				require.GreaterOrEqual(t, imag(precStats.MinPrecision), float64(testTranscipherMinPrecision))
+			}
+		})
+	}
+}
+
+func testTranscipher(testctx *testTranscipherContext, t *testing.T) {
+	tcParams := testctx.tcParams
+	params := testctx.params
+
+	name := fmt.Sprintf("Transcipher/%v/NumRound=%d/Radix=%d/", tcParams.Cipher, tcParams.NumRound(), tcParams.Radix)
+	t.Run(testString(name, params), func(t *testing.T) {
+		tc := testctx.tc
+		n := params.FVSlots()
+		key := newTestKey(tcParams.KeySize(), params.PlainModulus())
+		nonces := newTestNonces(n)
+		counter := newTestNonces(1)[0][:8]
+
+		enc := NewRtFEncryptor(tcParams, key)
+
+		// The symmetric key is encrypted by the client and sent to the server in binary form
+		b, err := enc.EncryptKeyNew(testctx.pk).MarshalBinary()
+		require.NoError(t, err)
+		bundle := new(SymmetricKeyBundle)
+		assert.Error(t, bundle.UnmarshalBinary(b[:len(b)-1]))
+		require.NoError(t, bundle.UnmarshalBinary(b))
+		kCt, err := tc.LoadKey(bundle)
+		require.NoError(t, err)
+
+		bundle.CipherParam++
+		_, err = tc.LoadKey(bundle)
+		assert.Error(t, err)
+		bundle.CipherParam--
+		bundle.Ciphertexts = bundle.Ciphertexts[1:]
+		_, err = tc.LoadKey(bundle)
+		assert.Error(t, err)
+
+		// The offline keystream is evaluated on a worker pool and sent to the online phase in binary form
+		tc.SetWorkers(4)
+		ks := tc.KeyStream(kCt, nonces, counter)
+		tc.SetWorkers(1)
+		require.Len(t, ks.Cts, tcParams.BlockSize())
+		for s := range ks.Cts {
+			b, err := ks.Cts[s].MarshalBinary()
+			require.NoError(t, err)
+			ks.Cts[s] = new(Ciphertext)
+			require.NoError(t, ks.Cts[s].UnmarshalBinary(b))
+		}
+
+		// Only two blocks are transciphered to save time, the last one being half filled to check the padding
+		data := make([]float64, 2*n-n/2)
+		for i := range data {
+			data[i] = utils.RandFloat64(-1, 1)
+		}
+		want := make([]float64, 2*n)
+		copy(want, data)
+
+		b, err = enc.EncryptNew(data, nonces, counter).MarshalBinary()
+		require.NoError(t, err)
+		symCt := new(SymmetricCiphertext)
+		require.NoError(t, symCt.UnmarshalBinary(b))
+		require.Len(t, symCt.Blocks, 2)
+
+		cts, err := tc.Transcipher(symCt, ks)
+		require.NoError(t, err)
+		if tcParams.FullCoeffs() {
+			require.Len(t, cts, 4)
+			for i, ct := range cts {
+				testctx.verify(t, want[i*n/2:(i+1)*n/2], ct)
+			}
+		} else {
+			require.Len(t, cts, 2)
+			for i, ct := range cts {
+				testctx.verify(t, want[i*n:(i+1)*n], ct)
+			}
+		}
+
+		// The symmetric ciphertext and the keystream are checked
+		_, err = tc.Transcipher(symCt, &KeyStreamBatch{Nonces: ks.Nonces, Counter: ks.Counter, Cts: ks.Cts[:1]})
+		assert.Error(t, err)
+		_, err = tc.TranscipherComplex(symCt, ks)
+		assert.Error(t, err)
+		symCt.CipherParam++
+		_, err = tc.Transcipher(symCt, ks)
+		assert.Error(t, err)
+		symCt.CipherParam--
+
+		// The keystream of another batch of nonces is rejected, and so is the keystream of another counter
+		// if the cipher uses it
+		_, err = tc.Transcipher(symCt, &KeyStreamBatch{Nonces: newTestNonces(n), Counter: ks.Counter, Cts: ks.Cts})
+		assert.Error(t, err)
+		if tcParams.usesCounter() {
+			_, err = tc.Transcipher(symCt, &KeyStreamBatch{Nonces: ks.Nonces, Counter: newTestNonces(1)[0][:8], Cts: ks.Cts})
+			assert.Error(t, err)
+		}
+
+		ks.Cts[1] = NewCiphertextFVLvl(params, 1, 1)
+		_, err = tc.Transcipher(symCt, ks)
+		assert.Error(t, err)
+	})
+}
@@ -1,6 +1,7 @@
 package ckks_fv
 
 import (
+	"fmt"
 	"io"
 	"math"
 	"math/big"
@@ -9,6 +10,18 @@ import (
 	"github.com/ldsec/lattigo/v2/ring"
 )
 
+// validateCubeModulus checks that x -> x^3 is a permutation of Z_t, i.e. that gcd(3, t-1) = 1,
+// and that the sum of two elements of Z_t does not overflow.
+func validateCubeModulus(t uint64) error {
+	if t < 2 || t >= 1<<63 {
+		return fmt.Errorf("plaintext modulus should be in [2, 2^63) but is %d", t)
+	}
+	if (t-1)%3 == 0 {
+		return fmt.Errorf("plaintext modulus %d is 1 mod 3, so that the cube is not invertible", t)
+	}
+	return nil
+}
+
 // Returns uniform random value in (0,q) by rejection sampling
 func SampleZqx(rand io.Reader, q uint64) (res uint64) {
 	bitLen := bits.Len64(q - 2)
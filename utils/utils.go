@@ -48,6 +48,18 @@ func EqualSliceInt64(a, b []int64) (v bool) {
 	return
 }
 
+// EqualSliceInt checks the equality between two int slices.
+func EqualSliceInt(a, b []int) (v bool) {
+	if len(a) != len(b) {
+		return false
+	}
+	v = true
+	for i := range a {
+		v = v && (a[i] == b[i])
+	}
+	return
+}
+
 // EqualSliceUint8 checks the equality between two uint8 slices.
 func EqualSliceUint8(a, b []uint8) (v bool) {
 	v = true
@@ -13,3 +13,11 @@ func TestAllDistinct(t *testing.T) {
 	require.False(t, AllDistinct([]uint64{1, 1}))
 	require.False(t, AllDistinct([]uint64{1, 2, 3, 4, 5, 5}))
 }
+
+func TestEqualSliceInt(t *testing.T) {
+	require.True(t, EqualSliceInt([]int{}, nil))
+	require.True(t, EqualSliceInt([]int{1, 2, 3}, []int{1, 2, 3}))
+	require.False(t, EqualSliceInt([]int{1, 2, 3}, []int{1, 2, 4}))
+	require.False(t, EqualSliceInt([]int{1, 2, 3}, []int{1, 2}))
+	require.False(t, EqualSliceInt([]int{1, 2}, []int{1, 2, 3}))
+}